@@ -0,0 +1,32 @@
+// embed.js — drop-in widget that shows a live compterm session on another site.
+//
+//   <script src="https://host:2200/embed.js" data-token="..." data-font-size="14"
+//     data-background="#1e1e2e" async></script>
+//
+// The script replaces itself with an iframe of the chrome-less viewer served at
+// /embed. Every data-* attribute becomes a query parameter of that page:
+// token (an access or embed token), fontSize, and any xterm.js theme color.
+// data-width and data-height size the iframe itself. data-key, the key of an
+// end-to-end encrypted session, goes in the fragment so it never reaches the
+// server.
+(() => {
+  const script = document.currentScript;
+  if (!script) return;
+
+  const src = new URL('embed', script.src);
+  const { width, height, key, ...params } = script.dataset;
+  for (const [name, value] of Object.entries(params)) {
+    src.searchParams.set(name, value);
+  }
+  if (key) src.hash = `key=${encodeURIComponent(key)}`;
+
+  const frame = document.createElement('iframe');
+  frame.src = src.toString();
+  frame.title = 'compterm';
+  frame.style.border = '0';
+  frame.style.width = width || '100%';
+  frame.style.height = height || '32em';
+  frame.setAttribute('allow', 'clipboard-write');
+
+  script.replaceWith(frame);
+})();
@@ -0,0 +1,16 @@
+package assets
+
+import "io"
+
+// FontFile is the viewer's font, also used to draw screenshots.
+const FontFile = "3270NerdFontMono-Regular.ttf"
+
+// ReadFile returns the content of the named asset.
+func ReadFile(name string) ([]byte, error) {
+	f, err := FS.Open("/" + name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	return io.ReadAll(f)
+}
@@ -4,12 +4,26 @@ import { registerIIP, makeReserver } from './iip.js';
 
 const MSG = 0x1;
 const RESIZE = 0x2;
+const SEQ = 0x3;
+const ENC = 0x4;
+const REWIND = 0x5;
+const LIVE = 0x6;
+const DIFF = 0x7;
+const KEYFRAME = 0x1;
 
 const decoder = new TextDecoder();
 
+// The query string carries the access token of a shared or embedded link and,
+// on the chrome-less /embed page, the display settings the host site chose.
+const params = new URLSearchParams(window.location.search);
+const embedded = document.body.classList.contains('embed');
+// The end-to-end key of an encrypted session travels in the URL fragment
+// (#key=...), which the browser never sends to the server or any relay.
+const fragment = new URLSearchParams(window.location.hash.slice(1));
+
 const termOptions = {
-  // compterm is strictly one-way: the viewer never sends anything back, so the
-  // terminal accepts no input.
+  // compterm is strictly one-way: the viewer never sends input back (only its
+  // own rewind and live requests), so the terminal accepts none.
   disableStdin: true,
   // the decoration API used to render inline images (OSC 1337) is proposed.
   allowProposedApi: true,
@@ -49,6 +63,44 @@ let reserveIIP = (b) => b;
 const progress = '/-\\|';
 let progressIndex = 0;
 
+// lastSeq is the payload of the last SEQ frame, the stream position to resume
+// from after a dropped connection so only the missed frames are replayed.
+let lastSeq = '';
+
+// cryptoKey opens the ENC frames of an end-to-end encrypted session. synced is
+// set once a keyframe (a sealed snapshot) has been applied: until then sealed
+// deltas are skipped, and afterwards the periodic keyframes are. sealedSeq
+// and sealedEpoch number the last payload opened, so a relay can neither
+// replay nor reorder them (see e2e.Order).
+let cryptoKey = null;
+let synced = false;
+let sealedSeq = 0n;
+let sealedEpoch = 0n;
+
+// socket is the open websocket, for the playback controls; offset is how many
+// seconds behind live the server is playing to this viewer (0 is live).
+// useEvents is set when the first websocket could not be opened at all (a
+// network that blocks upgrades): from then on the viewer uses the Server-Sent
+// Events stream, which carries the same frames but no controls.
+let socket = null;
+let wsOpened = false;
+let useEvents = false;
+let offset = 0;
+const rewindStep = 30;
+
+// setStatus shows a connection notice over the terminal (empty hides it). The
+// screen itself is left alone so a resumed stream continues where it stopped.
+function setStatus(text) {
+  let el = document.getElementById('status');
+  if (!el) {
+    el = document.createElement('div');
+    el.id = 'status';
+    document.body.appendChild(el);
+  }
+  el.textContent = text;
+  el.hidden = !text;
+}
+
 // fnv1a computes the FNV-1a 32-bit hash, matching the Go protocol package.
 function fnv1a(bytes) {
   let hash = 0x811c9dc5;
@@ -84,64 +136,329 @@ function decodeProtocol(buffer) {
   return { command, payloadLength, payload };
 }
 
-function connectWS() {
+// encodeFrame builds a frame [cmd][len][payload][fnv32] to send to the server.
+function encodeFrame(command, payload) {
+  const buffer = new Uint8Array(payload.length + 9);
+  const view = new DataView(buffer.buffer);
+  buffer[0] = command;
+  view.setUint32(1, payload.length, false);
+  buffer.set(payload, 5);
+  view.setUint32(5 + payload.length, fnv1a(buffer.subarray(0, 5 + payload.length)), false);
+  return buffer;
+}
+
+// sendControl asks the server to rewind or go live; the viewer sends nothing
+// else.
+function sendControl(command, text = '') {
+  if (socket && socket.readyState === WebSocket.OPEN) {
+    socket.send(encodeFrame(command, new TextEncoder().encode(text)));
+  }
+}
+
+// formatOffset renders an offset in seconds as -m:ss.
+function formatOffset(secs) {
+  return `-${Math.floor(secs / 60)}:${String(secs % 60).padStart(2, '0')}`;
+}
+
+// setupDVR adds the rewind and live controls.
+function setupDVR() {
+  const bar = document.createElement('div');
+  bar.id = 'dvr';
+  bar.innerHTML = '<button id="dvr-rewind" title="Rewind 30 seconds">⏪ 30s</button>' +
+    '<span id="dvr-position"></span>' +
+    '<button id="dvr-live" title="Back to live">Live</button>';
+  document.body.appendChild(bar);
+  document.getElementById('dvr-rewind').onclick = () => sendControl(REWIND, String(offset + rewindStep));
+  document.getElementById('dvr-live').onclick = () => sendControl(LIVE);
+  updateDVR();
+}
+
+// updateDVR shows the current playback position.
+function updateDVR() {
+  const position = document.getElementById('dvr-position');
+  if (!position) return;
+  position.textContent = offset ? formatOffset(offset) : 'LIVE';
+  document.getElementById('dvr-live').disabled = !offset;
+  document.getElementById('dvr').classList.toggle('shifted', offset > 0);
+  document.getElementById('dvr').hidden = useEvents;
+}
+
+// maxPayload is the largest frame payload, constants.BufferSize.
+const maxPayload = 256 * 1024;
+
+// frameStream returns the function that takes the next message of a stream of
+// frames and returns the frames it completes. The server writes the stream in
+// chunks that need not end at frame boundaries, so a frame a message cuts off
+// is kept for the next one, as protocol.Stream does.
+function frameStream() {
+  let tail = new Uint8Array(0);
+  return (array) => {
+    let data = array;
+    if (tail.length) {
+      data = new Uint8Array(tail.length + array.length);
+      data.set(tail);
+      data.set(array, tail.length);
+    }
+    tail = new Uint8Array(0);
+    const out = [];
+    while (data.length >= 9) {
+      const length = new DataView(data.buffer, data.byteOffset, 9).getUint32(1, false);
+      if (length > maxPayload) throw new Error('frame too long: ' + length);
+      if (length + 9 > data.length) break;
+      const frame = decodeProtocol(data);
+      out.push(frame);
+      data = data.subarray(length + 9);
+    }
+    tail = data.slice();
+    return out;
+  };
+}
+
+// frames iterates the concatenated frames of a sealed payload, which holds
+// whole frames.
+function* frames(array) {
+  while (array.length >= 9) {
+    const frame = decodeProtocol(array);
+    yield frame;
+    array = array.subarray(frame.payloadLength + 9);
+  }
+}
+
+// importKey turns the link's key (base64, URL-safe or not) into an AES-GCM key.
+async function importKey(text) {
+  const b64 = text.replace(/[ -]/g, '+').replace(/_/g, '/').replace(/=+$/, '');
+  const bin = atob(b64 + '==='.slice((b64.length + 3) % 4));
+  const raw = Uint8Array.from(bin, (c) => c.charCodeAt(0));
+  return crypto.subtle.importKey('raw', raw, 'AES-GCM', false, ['decrypt']);
+}
+
+// openSealed decrypts an ENC payload, [flags][seq][epoch][12-byte nonce]
+// [ciphertext], whose 17-byte header is authenticated as additional data (see
+// the Go e2e package). It returns null for a payload replayed or out of order.
+async function openSealed(payload) {
+  const plain = await crypto.subtle.decrypt(
+    { name: 'AES-GCM', iv: payload.subarray(17, 29), additionalData: payload.subarray(0, 17) },
+    cryptoKey,
+    payload.subarray(29),
+  );
+  const view = new DataView(payload.buffer, payload.byteOffset, 17);
+  const keyframe = (payload[0] & KEYFRAME) !== 0;
+  const seq = view.getBigUint64(1);
+  const epoch = view.getBigUint64(9);
+  if (seq <= sealedSeq || epoch < sealedEpoch || epoch > seq || (keyframe && epoch !== seq)) {
+    return null;
+  }
+  sealedSeq = seq;
+  sealedEpoch = epoch;
+  return { keyframe, inner: new Uint8Array(plain) };
+}
+
+// sgr returns the escape sequence for a DIFF run's style bytes: ColorType,
+// Flags, the FG, BG, and underline colors, then Extra (see mterm.SGRState).
+function sgr(style) {
+  const [types, flags] = style;
+  const extra = style[11];
+  let s = '\x1b[0';
+  const color = (type, c, base) => {
+    switch (type) {
+      case 1: return base === 58 ? '' : `;${c[0]}`;
+      case 2: return `;${base};5;${c[0]}`;
+      case 3: return `;${base};2;${c[0]};${c[1]};${c[2]}`;
+      default: return '';
+    }
+  };
+  s += color(types & 3, style.subarray(2, 5), 38);
+  s += color((types >> 2) & 3, style.subarray(5, 8), 48);
+  s += color((types >> 4) & 3, style.subarray(8, 11), 58);
+  [1, 2, 3, 4, 5, 7, 8, 9].forEach((code, bit) => {
+    if (flags & (1 << bit)) s += `;${code}`;
+    if (code === 4 && flags & (1 << bit) && extra & 7) s += `:${extra & 7}`;
+  });
+  if (extra & 16) s += ';6';
+  if (extra & 8) s += ';53';
+  return `${s}m`;
+}
+
+// renderDiff turns a DIFF payload (the changed cells, in diff mode) into the
+// escape sequences that draw them; the Go side is diff.Render.
+function renderDiff(payload) {
+  const view = new DataView(payload.buffer, payload.byteOffset, payload.byteLength);
+  let out = payload[0] & 1 ? '\x1b[0m\x1b[H\x1b[2J' : '';
+  let i = 5;
+  while (i + 18 <= payload.length) {
+    const n = view.getUint16(i + 16);
+    out += `\x1b[${view.getUint16(i) + 1};${view.getUint16(i + 2) + 1}H`;
+    out += sgr(payload.subarray(i + 4, i + 16));
+    out += decoder.decode(payload.subarray(i + 18, i + 18 + n));
+    i += 18 + n;
+  }
+  return `${out}\x1b[0m\x1b[${view.getUint16(1) + 1};${view.getUint16(3) + 1}H`;
+}
+
+// applyFrame applies one plain frame to the terminal.
+function applyFrame(command, payload) {
+  switch (command) {
+    case MSG:
+      // pass raw bytes: xterm.js reassembles UTF-8 across writes, so a
+      // multibyte glyph split across frames (common with image ANSI) doesn't
+      // turn into replacement characters. reserveIIP inserts the blank rows an
+      // inline image occupies before xterm parses them.
+      terminal.write(reserveIIP(payload));
+      break;
+    case DIFF:
+      terminal.write(renderDiff(payload));
+      break;
+    case RESIZE: {
+      const [cols, rows] = decoder.decode(payload).split(':');
+      terminal.resize(+rows, +cols);
+      break;
+    }
+    case SEQ:
+      lastSeq = decoder.decode(payload);
+      break;
+    case REWIND:
+    case LIVE:
+      // The screen as of the new position follows. Start over from it, and
+      // come back live after a dropped connection.
+      offset = command === REWIND ? parseInt(decoder.decode(payload), 10) || 0 : 0;
+      lastSeq = '';
+      synced = false;
+      terminal.reset();
+      updateDVR();
+      break;
+    default:
+      console.log('unknown command', command);
+  }
+}
+
+// applySealed opens an ENC frame and applies the frames inside it.
+async function applySealed(payload) {
+  if (!cryptoKey) {
+    setStatus('This session is end-to-end encrypted: open it with the link that carries its #key.');
+    return;
+  }
+  const opened = await openSealed(payload);
+  if (!opened) return;
+  const { keyframe, inner } = opened;
+  // out of sync, wait for a keyframe; in sync, skip the periodic ones
+  if (keyframe === synced) return;
+  if (keyframe) {
+    synced = true;
+    terminal.reset();
+  }
+  for (const { command, payload: p } of frames(inner)) {
+    applyFrame(command, p);
+  }
+}
+
+// streamURL resolves the websocket (ws) or the event stream (events) next to
+// the viewer. The main page strips any trailing slash so a subpath (e.g.
+// /compterm/) yields /compterm/ws, not /compterm//ws (which the server would
+// redirect and break the upgrade); /embed is a sibling of ws. An embedded
+// viewer may have no session cookie, so the link's token is forwarded.
+function streamURL(name) {
   const { host, pathname, protocol: proto } = window.location;
-  // strip trailing slash so a subpath (e.g. /compterm/) yields /compterm/ws,
-  // not /compterm//ws (which the server would redirect and break the upgrade).
-  const base = pathname.replace(/\/+$/, '');
-  const url = `${proto === 'https:' ? 'wss' : 'ws'}://${host}${base}/ws`;
-  const ws = new WebSocket(url);
-
-  ws.binaryType = 'blob';
-
-  ws.onopen = () => terminal.reset();
-
-  ws.onmessage = ({ data }) => {
-    const reader = new FileReader();
-    reader.onload = () => {
-      let array = new Uint8Array(reader.result);
-      try {
-        // A single websocket message may carry several concatenated frames.
-        while (array.length >= 9) {
-          const { command, payloadLength, payload } = decodeProtocol(array);
-          switch (command) {
-            case MSG:
-              // pass raw bytes: xterm.js reassembles UTF-8 across writes, so a
-              // multibyte glyph split across frames (common with image ANSI)
-              // doesn't turn into replacement characters. reserveIIP inserts the
-              // blank rows an inline image occupies before xterm parses them.
-              terminal.write(reserveIIP(payload));
-              break;
-            case RESIZE: {
-              const [cols, rows] = decoder.decode(payload).split(':');
-              terminal.resize(+rows, +cols);
-              break;
-            }
-            default:
-              console.log('unknown command', command);
-          }
-          array = array.subarray(payloadLength + 9);
+  const base = embedded ? pathname.replace(/\/[^/]*$/, '') : pathname.replace(/\/+$/, '');
+  const scheme = name === 'ws' ? (proto === 'https:' ? 'wss' : 'ws') : proto.slice(0, -1);
+  const url = new URL(`${scheme}://${host}${base}/${name}`);
+  const token = params.get('token');
+  if (token) url.searchParams.set('token', token);
+  if (lastSeq) url.searchParams.set('since', lastSeq);
+  // ?mode=diff: the changed cells at a capped rate, for slow links
+  if (params.get('mode') === 'diff') url.searchParams.set('mode', 'diff');
+  return url.toString();
+}
+
+// receiver returns the function that applies one connection's messages, each
+// a batch of frames.
+function receiver() {
+  // Messages are chained on pending because decrypting is asynchronous, so
+  // frames are applied strictly in order — a resumed stream depends on it.
+  let pending = Promise.resolve();
+
+  // A resumed stream starts with a SEQ frame; anything else is a full
+  // snapshot, which needs a clean terminal.
+  let first = true;
+  const next = frameStream();
+
+  const handleMessage = async (array) => {
+    // A message may carry several frames, and end in part of one.
+    for (const { command, payload } of next(array)) {
+      if (first) {
+        first = false;
+        if (command !== SEQ) {
+          terminal.reset();
+          synced = false;
         }
-      } catch (err) {
-        console.log('frame decode error:', err.message);
       }
-    };
-    reader.readAsArrayBuffer(data);
+      if (command === ENC) {
+        await applySealed(payload);
+      } else {
+        applyFrame(command, payload);
+      }
+    }
   };
 
-  ws.onerror = () => ws.close();
+  return (array) => {
+    pending = pending.then(() => handleMessage(array)).catch((err) => {
+      console.log('frame decode error:', err.message || err);
+      if (err.name === 'OperationError') setStatus('Cannot decrypt the session: wrong key?');
+    });
+  };
+}
+
+// opened marks a new connection: it always starts live.
+function opened() {
+  setStatus('');
+  offset = 0;
+  updateDVR();
+}
+
+// reconnect shows the notice and retries with connect.
+function reconnect(connect) {
+  setStatus(`Connection closed. Reconnecting… ${progress[progressIndex]}`);
+  progressIndex = (progressIndex + 1) % progress.length;
+  setTimeout(connect, 1000);
+}
+
+function connectWS() {
+  const ws = new WebSocket(streamURL('ws'));
+  socket = ws;
+  // arraybuffer (not blob) keeps reading synchronous
+  ws.binaryType = 'arraybuffer';
+  const receive = receiver();
 
+  ws.onopen = () => {
+    wsOpened = true;
+    opened();
+  };
+  ws.onmessage = ({ data }) => receive(new Uint8Array(data));
+  ws.onerror = () => ws.close();
   ws.onclose = () => {
-    terminal.reset();
-    terminal.write(`\x1b[2J\x1b[0;0HConnection closed.\r\nReconnecting… ${progress[progressIndex]}\r\n`);
-    progressIndex = (progressIndex + 1) % progress.length;
-    document.title = 'compterm';
-    setTimeout(connectWS, 1000);
+    // never opened: upgrades may be blocked, fall back to the event stream
+    if (!wsOpened) {
+      useEvents = true;
+      socket = null;
+      connectEvents();
+      return;
+    }
+    reconnect(connectWS);
   };
+}
 
-  // No terminal.onData handler: the viewer never sends input back to the host.
-  terminal.onTitleChange((title) => document.title = title);
-  terminal.onerror = (err) => console.log(err);
+// connectEvents watches the session over Server-Sent Events, each event a
+// base64 batch of frames. EventSource's own retry would not resume, so errors
+// reconnect the same way the websocket does.
+function connectEvents() {
+  const es = new EventSource(streamURL('events'));
+  const receive = receiver();
+
+  es.onopen = opened;
+  es.onmessage = ({ data }) => receive(Uint8Array.from(atob(data), (c) => c.charCodeAt(0)));
+  es.onerror = () => {
+    es.close();
+    reconnect(connectEvents);
+  };
 }
 
 // loadTheme fetches an optional palette from the server so the viewer can match
@@ -156,6 +473,17 @@ async function loadTheme() {
   return {};
 }
 
+// embedOptions applies the /embed query parameters: fontSize, and any xterm.js
+// theme field (e.g. background=%231e1e2e), layered over the server theme.
+function embedOptions() {
+  const fontSize = parseInt(params.get('fontSize'), 10);
+  if (fontSize > 0) termOptions.fontSize = fontSize;
+
+  for (const [key, value] of params) {
+    if (key in termOptions.theme) termOptions.theme[key] = value;
+  }
+}
+
 window.onload = async () => {
   const cfg = await loadTheme();
   // imageScale is a display setting, not an xterm theme field: pull it out
@@ -163,12 +491,29 @@ window.onload = async () => {
   const imageScale = typeof cfg.imageScale === 'number' ? cfg.imageScale : undefined;
   delete cfg.imageScale;
   termOptions.theme = Object.assign({}, termOptions.theme, cfg);
+  if (embedded) embedOptions();
+
+  const key = fragment.get('key');
+  if (key) {
+    try {
+      cryptoKey = await importKey(key);
+    } catch (err) {
+      setStatus(window.isSecureContext
+        ? 'The #key in this link is not valid.'
+        : 'Decrypting the session needs a secure page (https or localhost).');
+    }
+  }
 
   terminal = new Terminal(termOptions);
   terminal.loadAddon(new WebLinksAddon());
   terminal.open(document.getElementById('terminal'));
   registerIIP(terminal, imageScale);
   reserveIIP = makeReserver(terminal, imageScale);
+  if (!embedded) setupDVR();
+
+  // No terminal.onData handler: the viewer never sends input back to the host.
+  terminal.onTitleChange((title) => document.title = title);
+  terminal.onerror = (err) => console.log(err);
 
   connectWS();
 };
@@ -0,0 +1,479 @@
+// Package client consumes a compterm session. Dial connects to a server's
+// websocket and turns the framed broadcast into typed events, optionally
+// keeping a local terminal emulator in sync with the shared screen, and
+// reconnects with backoff when the connection drops. A reconnection resumes
+// from the last frame received, so only the missed output is replayed.
+//
+//	conn, err := client.Dial(ctx, "ws://localhost:2200/ws", &client.Options{Mirror: true})
+//	if err != nil {
+//		return err
+//	}
+//	defer conn.Close()
+//	for ev := range conn.Events() {
+//		if ev.Type == client.Message {
+//			fmt.Println(conn.Terminal().CursorPos())
+//		}
+//	}
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+
+	"github.com/crgimenes/compterm/constants"
+	"github.com/crgimenes/compterm/diff"
+	"github.com/crgimenes/compterm/e2e"
+	"github.com/crgimenes/compterm/mterm"
+	"github.com/crgimenes/compterm/protocol"
+)
+
+// EventType identifies what an Event carries.
+type EventType int
+
+const (
+	// Message carries terminal output (a MSG frame, or a DIFF rendered to
+	// ANSI) in Data.
+	Message EventType = iota + 1
+	// Resize carries the new screen size in Rows and Columns.
+	Resize
+	// Connected is sent after every successful (re)connection. Resumed
+	// reports whether the server replayed just the missed frames; otherwise a
+	// full snapshot follows and the screen should be reset.
+	Connected
+	// Disconnected is sent when the connection drops; Err says why.
+	Disconnected
+	// Sealed carries an end-to-end encrypted payload (an ENC frame) in Data,
+	// for a client without Options.Key. It cannot be read, only forwarded.
+	Sealed
+	// Shift reports that the server is now playing the session Offset behind
+	// live, after Rewind, or live again (Offset 0). The screen as of then
+	// follows; it replaces the current one.
+	Shift
+)
+
+// Event is one item of a session's stream.
+type Event struct {
+	Type    EventType
+	Data    []byte
+	Rows    int
+	Columns int
+	Resumed bool
+	Offset  time.Duration
+	Err     error
+}
+
+// Options configures Dial. A nil *Options uses the defaults.
+type Options struct {
+	// Token is the access token, sent as the token query parameter.
+	Token string
+	// Header is sent with the websocket handshake.
+	Header http.Header
+	// Key opens an end-to-end encrypted session. Sealed frames are decrypted
+	// into the usual events, starting at the first keyframe.
+	Key *e2e.Key
+	// Mirror keeps a local mterm.Terminal in sync with the shared screen.
+	Mirror bool
+	// Diff asks the server for the diff mode: the changed cells at a capped
+	// rate (see package diff) instead of the raw output. They arrive as
+	// Message events of ANSI that redraw them. A relay that cannot read the
+	// session sends the raw output anyway.
+	Diff bool
+	// NoReconnect ends the stream at the first disconnection instead of
+	// reconnecting.
+	NoReconnect bool
+	// MinBackoff and MaxBackoff bound the delay between reconnection
+	// attempts; it doubles after each failure. Defaults: 500ms and 30s.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+const (
+	defaultMinBackoff = 500 * time.Millisecond
+	defaultMaxBackoff = 30 * time.Second
+	eventBuffer       = 64
+)
+
+// Conn is a live subscription to a session.
+type Conn struct {
+	url    string
+	opts   Options
+	events chan Event
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mx     sync.Mutex
+	term   *mterm.Terminal
+	ws     *websocket.Conn // the current connection, for Rewind and Live
+	offset time.Duration   // how far behind live the server is playing
+	mark   string          // payload of the last SEQ frame, the resume position
+	// synced is set once an encrypted stream's keyframe has been applied;
+	// until then sealed deltas are skipped, and later keyframes always are.
+	synced bool
+	// order keeps sealed payloads in the order the host sealed them, across
+	// reconnections.
+	order e2e.Order
+}
+
+// Dial connects to the websocket at rawURL and starts streaming its events.
+// The first connection is made before Dial returns, so an unreachable or
+// unauthorized server is reported as an error; later drops are retried.
+func Dial(ctx context.Context, rawURL string, opts *Options) (*Conn, error) {
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	if o.MinBackoff <= 0 {
+		o.MinBackoff = defaultMinBackoff
+	}
+	if o.MaxBackoff < o.MinBackoff {
+		o.MaxBackoff = max(defaultMaxBackoff, o.MinBackoff)
+	}
+
+	target, err := buildURL(rawURL, o.Token, o.Diff)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Conn{
+		url:    target,
+		opts:   o,
+		events: make(chan Event, eventBuffer),
+		done:   make(chan struct{}),
+	}
+
+	ws, err := c.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, c.cancel = context.WithCancel(context.Background())
+	go c.run(ctx, ws)
+
+	return c, nil
+}
+
+// Events returns the stream of events. It is closed when the connection ends
+// for good: after Close, or at the first drop with NoReconnect.
+func (c *Conn) Events() <-chan Event {
+	return c.events
+}
+
+// Terminal returns the local mirror of the shared screen, or nil without
+// Options.Mirror. The mirror is replaced whenever the server sends a fresh
+// snapshot instead of resuming.
+func (c *Conn) Terminal() *mterm.Terminal {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.term
+}
+
+// Rewind asks the server to play the session from d ago (whole seconds, as
+// far back as it recorded) without disturbing anyone else. A Shift event
+// confirms the actual offset.
+func (c *Conn) Rewind(d time.Duration) error {
+	secs := max(int64(d/time.Second), 1)
+	return c.send(constants.REWIND, strconv.AppendInt(nil, secs, 10))
+}
+
+// Live returns a rewound session to live.
+func (c *Conn) Live() error {
+	return c.send(constants.LIVE, nil)
+}
+
+// Offset returns how far behind live the session is being played; 0 is live.
+func (c *Conn) Offset() time.Duration {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.offset
+}
+
+func (c *Conn) send(cmd byte, payload []byte) error {
+	c.mx.Lock()
+	ws := c.ws
+	c.mx.Unlock()
+	if ws == nil {
+		return errNotConnected
+	}
+	return ws.Write(context.Background(), websocket.MessageBinary, protocol.Append(nil, payload, cmd))
+}
+
+// Close disconnects and ends the event stream.
+func (c *Conn) Close() error {
+	c.cancel()
+	<-c.done
+	return nil
+}
+
+func (c *Conn) connect(ctx context.Context) (*websocket.Conn, error) {
+	target := c.url
+	if c.mark != "" {
+		u, err := url.Parse(target)
+		if err != nil {
+			return nil, err
+		}
+		q := u.Query()
+		q.Set("since", c.mark)
+		u.RawQuery = q.Encode()
+		target = u.String()
+	}
+
+	ws, _, err := websocket.Dial(ctx, target, &websocket.DialOptions{
+		HTTPHeader: c.opts.Header,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ws.SetReadLimit(-1)
+
+	c.mx.Lock()
+	c.ws, c.offset = ws, 0
+	c.mx.Unlock()
+	return ws, nil
+}
+
+// run pumps events until the context is cancelled, reconnecting with backoff.
+func (c *Conn) run(ctx context.Context, ws *websocket.Conn) {
+	defer close(c.done)
+	defer close(c.events)
+
+	for {
+		err := c.read(ctx, ws)
+		_ = ws.CloseNow()
+		if ctx.Err() != nil {
+			return
+		}
+		if !c.emit(ctx, Event{Type: Disconnected, Err: err}) || c.opts.NoReconnect {
+			return
+		}
+
+		ws = c.reconnect(ctx)
+		if ws == nil {
+			return
+		}
+	}
+}
+
+// reconnect retries with exponential backoff until it connects or ctx ends.
+func (c *Conn) reconnect(ctx context.Context) *websocket.Conn {
+	backoff := c.opts.MinBackoff
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+
+		ws, err := c.connect(ctx)
+		if err == nil {
+			return ws
+		}
+		backoff = min(backoff*2, c.opts.MaxBackoff)
+	}
+}
+
+// read turns websocket messages into events until the connection fails. The
+// first frame tells a resumed stream (SEQ) from a fresh snapshot. The server
+// writes the stream in chunks, so a frame may span messages.
+func (c *Conn) read(ctx context.Context, ws *websocket.Conn) error {
+	var stream protocol.Stream
+	first := true
+	for {
+		_, data, err := ws.Read(ctx)
+		if err != nil {
+			return err
+		}
+
+		var fail error
+		ok := true
+		err = stream.Decode(data, func(cmd byte, payload []byte) {
+			if ok && first {
+				first = false
+				ok = c.connected(ctx, cmd == constants.SEQ)
+			}
+			if ok && cmd == constants.ENC && c.opts.Key != nil {
+				ok, fail = c.open(ctx, payload)
+				return
+			}
+			if ok {
+				ok = c.handle(ctx, cmd, payload)
+			}
+		})
+		if fail != nil {
+			return fail
+		}
+		if !ok {
+			return ctx.Err()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// connected emits Connected, starting a new mirror unless the stream resumed.
+func (c *Conn) connected(ctx context.Context, resumed bool) bool {
+	if !resumed {
+		c.synced = false
+		c.resetMirror()
+	}
+	return c.emit(ctx, Event{Type: Connected, Resumed: resumed})
+}
+
+func (c *Conn) resetMirror() {
+	if c.opts.Mirror {
+		c.mx.Lock()
+		c.term = mterm.New(24, 80) // resized by the RESIZE that leads the snapshot
+		c.mx.Unlock()
+	}
+}
+
+// open decrypts an ENC payload and handles the frames inside it. A stream
+// that is not in sync starts at a keyframe, one in sync ignores them; payloads
+// replayed or out of order are dropped. It fails when the payload does not
+// open with the key.
+func (c *Conn) open(ctx context.Context, payload []byte) (bool, error) {
+	h, plain, err := c.opts.Key.Open(payload)
+	if err != nil {
+		return false, err
+	}
+	if c.order.Check(h) != nil {
+		// replayed or out of order: a relay at work, or one starting us over
+		// at a keyframe already seen
+		return true, nil
+	}
+	keyframe := h.Flags&e2e.Keyframe != 0
+	switch {
+	case keyframe && c.synced, !keyframe && !c.synced:
+		return true, nil
+	case keyframe:
+		c.synced = true
+		c.resetMirror()
+	}
+
+	ok := true
+	buf := make([]byte, len(plain))
+	err = protocol.DecodeFrames(buf, plain, func(cmd byte, p []byte) {
+		if ok {
+			ok = c.handle(ctx, cmd, p)
+		}
+	})
+	if err != nil {
+		return false, err
+	}
+	return ok, nil
+}
+
+// handle applies one frame to the mirror and emits its event. It reports false
+// when the context ended while emitting.
+func (c *Conn) handle(ctx context.Context, cmd byte, payload []byte) bool {
+	term := c.Terminal()
+
+	switch cmd {
+	case constants.SEQ:
+		c.mark = string(payload)
+		return true
+	case constants.ENC:
+		return c.emit(ctx, Event{Type: Sealed, Data: bytes.Clone(payload)})
+	case constants.REWIND, constants.LIVE:
+		var offset time.Duration
+		if cmd == constants.REWIND {
+			secs, err := strconv.Atoi(string(payload))
+			if err != nil {
+				return true
+			}
+			offset = time.Duration(secs) * time.Second
+		}
+		// a snapshot follows: start over from it, and from live on reconnect
+		c.mark, c.synced = "", false
+		c.resetMirror()
+		c.mx.Lock()
+		c.offset = offset
+		c.mx.Unlock()
+		return c.emit(ctx, Event{Type: Shift, Offset: offset})
+	case constants.MSG:
+		if term != nil {
+			_, _ = term.Write(payload)
+		}
+		return c.emit(ctx, Event{Type: Message, Data: bytes.Clone(payload)})
+	case constants.DIFF:
+		out, err := diff.Render(nil, payload)
+		if err != nil {
+			return true
+		}
+		if term != nil {
+			_, _ = term.Write(out)
+		}
+		return c.emit(ctx, Event{Type: Message, Data: out})
+	case constants.RESIZE:
+		rows, columns, err := parseSize(payload)
+		if err != nil {
+			return true
+		}
+		if term != nil {
+			term.Resize(rows, columns)
+		}
+		return c.emit(ctx, Event{Type: Resize, Rows: rows, Columns: columns})
+	}
+	return true
+}
+
+func (c *Conn) emit(ctx context.Context, ev Event) bool {
+	select {
+	case c.events <- ev:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+var (
+	errBadSize      = errors.New("malformed resize payload")
+	errNotConnected = errors.New("not connected")
+)
+
+// parseSize parses a RESIZE payload, "rows:columns".
+func parseSize(p []byte) (rows, columns int, err error) {
+	r, c, ok := strings.Cut(string(p), ":")
+	if !ok {
+		return 0, 0, errBadSize
+	}
+	rows, err = strconv.Atoi(r)
+	if err != nil {
+		return 0, 0, errBadSize
+	}
+	columns, err = strconv.Atoi(c)
+	if err != nil {
+		return 0, 0, errBadSize
+	}
+	if rows <= 0 || columns <= 0 {
+		return 0, 0, errBadSize
+	}
+	return rows, columns, nil
+}
+
+// buildURL appends the access token, when provided, and the diff mode, when
+// asked for, to the websocket URL.
+func buildURL(rawURL, token string, diffMode bool) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	if token != "" {
+		q.Set("token", token)
+	}
+	if diffMode {
+		q.Set("mode", "diff")
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
@@ -0,0 +1,252 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+
+	"github.com/crgimenes/compterm/constants"
+	"github.com/crgimenes/compterm/e2e"
+	"github.com/crgimenes/compterm/protocol"
+)
+
+func frame(t *testing.T, cmd byte, payload string) []byte {
+	t.Helper()
+	enc := make([]byte, constants.BufferSize)
+	n, err := protocol.Encode(enc, []byte(payload), cmd)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	return bytes.Clone(enc[:n])
+}
+
+func TestBuildURL(t *testing.T) {
+	tests := []struct {
+		name, raw, token string
+		diff             bool
+		want             string
+	}{
+		{"no token", "ws://localhost:2200/ws", "", false, "ws://localhost:2200/ws"},
+		{"with token", "ws://localhost:2200/ws", "s3cr3t", false, "ws://localhost:2200/ws?token=s3cr3t"},
+		{"wss with spaced token", "wss://example.com/term/ws", "ab cd", false, "wss://example.com/term/ws?token=ab+cd"},
+		{"diff mode", "ws://localhost:2200/ws", "s3cr3t", true, "ws://localhost:2200/ws?mode=diff&token=s3cr3t"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildURL(tt.raw, tt.token, tt.diff)
+			if err != nil {
+				t.Fatalf("buildURL: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("buildURL(%q, %q, %v) = %q, want %q", tt.raw, tt.token, tt.diff, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in         string
+		rows, cols int
+		wantErr    bool
+	}{
+		{"25:80", 25, 80, false},
+		{"1:1", 1, 1, false},
+		{"25", 0, 0, true},
+		{"a:80", 0, 0, true},
+		{"0:80", 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		rows, cols, err := parseSize([]byte(tt.in))
+		if (err != nil) != tt.wantErr || rows != tt.rows || cols != tt.cols {
+			t.Errorf("parseSize(%q) = %d, %d, %v", tt.in, rows, cols, err)
+		}
+	}
+}
+
+// TestDialMirrorAndReconnect serves a snapshot and drops the connection; the
+// client must mirror the screen, report the drop, and reconnect on its own.
+func TestDialMirrorAndReconnect(t *testing.T) {
+	var conns atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("token") != "s3cr3t" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		c, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		n := conns.Add(1)
+
+		var msg []byte
+		msg = append(msg, frame(t, constants.RESIZE, "5:20")...)
+		msg = append(msg, frame(t, constants.MSG, "\033[2;3Hconn")...)
+		_ = c.Write(r.Context(), websocket.MessageBinary, msg)
+		if n == 1 {
+			_ = c.Close(websocket.StatusGoingAway, "bye")
+			return
+		}
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	if _, err := Dial(ctx, url, &Options{Token: "wrong"}); err == nil {
+		t.Fatal("Dial with a wrong token succeeded")
+	}
+
+	conn, err := Dial(ctx, url, &Options{
+		Token:      "s3cr3t",
+		Mirror:     true,
+		MinBackoff: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	var types []EventType
+	messages := 0
+	for ev := range conn.Events() {
+		types = append(types, ev.Type)
+		if ev.Type == Resize && (ev.Rows != 5 || ev.Columns != 20) {
+			t.Fatalf("resize event = %dx%d, want 5x20", ev.Rows, ev.Columns)
+		}
+		if ev.Type == Message {
+			messages++
+			if messages == 2 {
+				break
+			}
+		}
+	}
+
+	want := []EventType{Connected, Resize, Message, Disconnected, Connected, Resize, Message}
+	if len(types) != len(want) {
+		t.Fatalf("events = %v, want %v", types, want)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Fatalf("events = %v, want %v", types, want)
+		}
+	}
+
+	row, col := conn.Terminal().CursorPos()
+	if row != 1 || col != 6 {
+		t.Fatalf("mirror cursor = %d,%d, want 1,6", row, col)
+	}
+}
+
+// TestDialSplitFrames reads a stream larger than a message written in chunks
+// that cut frames in two, as the server does.
+func TestDialSplitFrames(t *testing.T) {
+	var stream, want []byte
+	stream = append(stream, frame(t, constants.RESIZE, "5:20")...)
+	for i := range 3 {
+		payload := strings.Repeat(string(rune('a'+i)), constants.BufferSize/2)
+		stream = append(stream, frame(t, constants.MSG, payload)...)
+		want = append(want, payload...)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		for p := stream; len(p) > 0; {
+			n := min(len(p), 100_000)
+			if c.Write(r.Context(), websocket.MessageBinary, p[:n]) != nil {
+				return
+			}
+			p = p[n:]
+		}
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http"), &Options{NoReconnect: true})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	var got []byte
+	for len(got) < len(want) {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("read %d of %d bytes", len(got), len(want))
+		case ev := <-conn.Events():
+			switch ev.Type {
+			case Message:
+				got = append(got, ev.Data...)
+			case Disconnected:
+				t.Fatalf("disconnected after %d of %d bytes: %v", len(got), len(want), ev.Err)
+			}
+		}
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("output does not match the frames sent")
+	}
+}
+
+// TestOpenKeyframes checks that an encrypted stream starts at a keyframe, that
+// a stream in sync skips the periodic ones, and that replays are dropped.
+func TestOpenKeyframes(t *testing.T) {
+	key, err := e2e.ParseKey(e2e.NewKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Conn{opts: Options{Key: key}, events: make(chan Event, eventBuffer)}
+	ctx := context.Background()
+
+	sealed := []struct {
+		flags byte
+		text  string
+	}{
+		{0, "delta before any keyframe"},
+		{e2e.Keyframe, "keyframe"},
+		{0, "delta"},
+		{e2e.Keyframe, "periodic keyframe"},
+	}
+	var payloads [][]byte
+	for _, s := range sealed {
+		payloads = append(payloads, key.Seal(s.flags, frame(t, constants.MSG, s.text)))
+	}
+	// a relay replaying the delta gets it dropped
+	payloads = append(payloads, payloads[2])
+	for i, p := range payloads {
+		ok, err := c.open(ctx, p)
+		if !ok || err != nil {
+			t.Fatalf("open(%d) = %v, %v", i, ok, err)
+		}
+	}
+	close(c.events)
+
+	var got []string
+	for ev := range c.events {
+		got = append(got, string(ev.Data))
+	}
+	if strings.Join(got, "|") != "keyframe|delta" {
+		t.Fatalf("events = %q, want [keyframe delta]", got)
+	}
+
+	other, _ := e2e.ParseKey(e2e.NewKey())
+	if _, err := c.open(ctx, other.Seal(0, frame(t, constants.MSG, "x"))); err != e2e.ErrOpen {
+		t.Fatalf("open with another key: err = %v, want ErrOpen", err)
+	}
+}
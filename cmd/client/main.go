@@ -1,7 +1,8 @@
 // Command client is a terminal (TUI) viewer for a compterm session. It connects
 // to the broadcast websocket and renders the shared terminal in place, since the
 // stream is already a complete ANSI feed (a snapshot on connect, then live
-// deltas). Press q or Ctrl-C to quit.
+// deltas). Press r to rewind 30 seconds (again to go further back), l to
+// return to live, and q or Ctrl-C to quit.
 package main
 
 import (
@@ -13,28 +14,40 @@ import (
 	"os"
 	"os/signal"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/crgimenes/compterm/constants"
-	"github.com/crgimenes/compterm/protocol"
+	"github.com/crgimenes/compterm/client"
+	"github.com/crgimenes/compterm/e2e"
 
-	"github.com/coder/websocket"
 	"golang.org/x/term"
 )
 
 func main() {
 	wsURL := flag.String("url", "ws://localhost:2200/ws", "compterm websocket URL")
 	token := flag.String("token", os.Getenv("COMPTERM_AUTH_TOKEN"), "access token, if the server requires one")
+	keyFlag := flag.String("key", os.Getenv("COMPTERM_E2E_KEY"), "end-to-end encryption key, if the session is encrypted")
+	diffMode := flag.Bool("diff", false, "receive the changed cells at a capped rate instead of the raw output")
 	flag.Parse()
 
-	target, err := buildURL(*wsURL, *token)
-	if err != nil {
+	if _, err := url.Parse(*wsURL); err != nil {
 		fmt.Fprintf(os.Stderr, "invalid url: %v\n", err)
 		os.Exit(1)
 	}
 
-	cleanup := enterScreen()
+	var key *e2e.Key
+	if *keyFlag != "" {
+		var err error
+		key, err = e2e.ParseKey(*keyFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid key: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	keys := make(chan byte, 8)
+	cleanup := enterScreen(keys)
 	defer cleanup()
 
 	sig := make(chan os.Signal, 1)
@@ -45,31 +58,74 @@ func main() {
 		os.Exit(0)
 	}()
 
-	// Reconnect until the user quits.
+	// Reconnect until the user quits: the first dial is retried here, later
+	// drops by the client package.
+	ctx := context.Background()
+	var current atomic.Pointer[client.Conn]
+	go control(keys, &current)
 	for {
-		err := stream(target)
-		_, _ = fmt.Fprintf(os.Stdout, "\r\n\033[33mdisconnected: %v — reconnecting...\033[0m\r\n", err)
-		time.Sleep(time.Second)
+		conn, err := client.Dial(ctx, *wsURL, &client.Options{Token: *token, Key: key, Diff: *diffMode})
+		if err != nil {
+			disconnected(err)
+			time.Sleep(time.Second)
+			continue
+		}
+		current.Store(conn)
+		render(conn.Events(), os.Stdout)
 	}
 }
 
-// buildURL appends the access token to the websocket URL when provided.
-func buildURL(rawURL, token string) (string, error) {
-	u, err := url.Parse(rawURL)
-	if err != nil {
-		return "", err
+// rewindStep is how much further back each press of r goes.
+const rewindStep = 30 * time.Second
+
+// control turns the playback keys into requests on the current connection.
+func control(keys <-chan byte, current *atomic.Pointer[client.Conn]) {
+	for k := range keys {
+		conn := current.Load()
+		if conn == nil {
+			continue
+		}
+		switch k {
+		case 'r':
+			_ = conn.Rewind(conn.Offset() + rewindStep)
+		case 'l':
+			_ = conn.Live()
+		}
 	}
-	if token != "" {
-		q := u.Query()
-		q.Set("token", token)
-		u.RawQuery = q.Encode()
+}
+
+// render writes the session's output to out until the event stream ends. A
+// dropped connection resumes without a redraw, so the reconnection status goes
+// to the window title rather than over the shared screen.
+func render(events <-chan client.Event, out io.Writer) {
+	for ev := range events {
+		switch ev.Type {
+		case client.Message:
+			_, _ = out.Write(ev.Data)
+		case client.Connected:
+			_, _ = io.WriteString(out, "\033]2;compterm\a")
+		case client.Shift:
+			// the screen as of the new position follows: start from a clean one
+			title := "compterm"
+			if ev.Offset > 0 {
+				title = fmt.Sprintf("compterm: %v behind live (l: back to live)", ev.Offset)
+			}
+			_, _ = fmt.Fprintf(out, "\033]2;%s\a\033[H\033[2J", title)
+		case client.Disconnected:
+			_, _ = fmt.Fprintf(out, "\033]2;compterm: disconnected (%v), reconnecting...\a", ev.Err)
+		}
 	}
-	return u.String(), nil
+}
+
+// disconnected reports a failed dial, before any session output is on screen.
+func disconnected(err error) {
+	_, _ = fmt.Fprintf(os.Stdout, "\r\n\033[33mdisconnected: %v — reconnecting...\033[0m\r\n", err)
 }
 
 // enterScreen switches to the alternate screen in raw mode and returns a
-// cleanup func (safe to call more than once) that restores the terminal.
-func enterScreen() func() {
+// cleanup func (safe to call more than once) that restores the terminal. Keys
+// other than the quit keys are passed on to keys.
+func enterScreen(keys chan<- byte) func() {
 	fd := int(os.Stdin.Fd())
 	if !term.IsTerminal(fd) {
 		return func() {}
@@ -98,53 +154,19 @@ func enterScreen() func() {
 			if err != nil {
 				return
 			}
-			if n == 1 && (b[0] == 'q' || b[0] == 0x03) {
+			if n != 1 {
+				continue
+			}
+			if b[0] == 'q' || b[0] == 0x03 {
 				cleanup()
 				os.Exit(0)
 			}
+			select {
+			case keys <- b[0]:
+			default:
+			}
 		}
 	}()
 
 	return cleanup
 }
-
-// stream renders the broadcast until the connection drops, returning the error.
-func stream(wsURL string) error {
-	ctx := context.Background()
-	c, _, err := websocket.Dial(ctx, wsURL, nil)
-	if err != nil {
-		return err
-	}
-	defer func() { _ = c.CloseNow() }()
-	c.SetReadLimit(-1)
-
-	buf := make([]byte, constants.BufferSize)
-	for {
-		_, data, err := c.Read(ctx)
-		if err != nil {
-			return err
-		}
-		renderFrames(buf, data, os.Stdout)
-	}
-}
-
-// renderFrames decodes every protocol frame in a websocket message (a single
-// message may carry several concatenated frames) and writes the payload of each
-// MSG frame to out. RESIZE frames carry no displayable content — the resize
-// escape is already part of the MSG stream — so they are skipped.
-func renderFrames(buf, data []byte, out io.Writer) {
-	for len(data) > 0 {
-		cmd, n, err := protocol.Decode(buf, data)
-		if err != nil {
-			return
-		}
-		if cmd == constants.MSG {
-			_, _ = out.Write(buf[:n])
-		}
-		advance := n + protocol.Overhead
-		if advance > len(data) {
-			return
-		}
-		data = data[advance:]
-	}
-}
@@ -2,54 +2,53 @@ package main
 
 import (
 	"bytes"
+	"io"
 	"testing"
+	"time"
 
-	"github.com/crgimenes/compterm/constants"
-	"github.com/crgimenes/compterm/protocol"
+	"github.com/crgimenes/compterm/client"
 )
 
-func TestRenderFrames(t *testing.T) {
-	enc := make([]byte, constants.BufferSize)
-	frame := func(cmd byte, payload string) []byte {
-		n, err := protocol.Encode(enc, []byte(payload), cmd)
-		if err != nil {
-			t.Fatalf("encode: %v", err)
-		}
-		return bytes.Clone(enc[:n])
-	}
-
-	// One websocket message carrying MSG, RESIZE (skipped), MSG.
-	var msg []byte
-	msg = append(msg, frame(constants.MSG, "hello ")...)
-	msg = append(msg, frame(constants.RESIZE, "25:80")...)
-	msg = append(msg, frame(constants.MSG, "world")...)
+func TestRender(t *testing.T) {
+	events := make(chan client.Event, 4)
+	events <- client.Event{Type: client.Message, Data: []byte("hello ")}
+	events <- client.Event{Type: client.Resize, Rows: 25, Columns: 80}
+	events <- client.Event{Type: client.Message, Data: []byte("world")}
+	close(events)
 
 	var out bytes.Buffer
-	renderFrames(make([]byte, constants.BufferSize), msg, &out)
+	render(events, &out)
 
 	if got := out.String(); got != "hello world" {
-		t.Fatalf("renderFrames output = %q, want %q", got, "hello world")
+		t.Fatalf("render output = %q, want %q", got, "hello world")
 	}
-}
 
-func TestBuildURL(t *testing.T) {
-	tests := []struct {
-		name, raw, token, want string
-	}{
-		{"no token", "ws://localhost:2200/ws", "", "ws://localhost:2200/ws"},
-		{"with token", "ws://localhost:2200/ws", "s3cr3t", "ws://localhost:2200/ws?token=s3cr3t"},
-		{"wss with spaced token", "wss://example.com/term/ws", "ab cd", "wss://example.com/term/ws?token=ab+cd"},
+	// a drop is reported in the title, leaving the screen for the resume
+	events = make(chan client.Event, 2)
+	events <- client.Event{Type: client.Disconnected, Err: io.EOF}
+	events <- client.Event{Type: client.Connected, Resumed: true}
+	close(events)
+
+	out.Reset()
+	render(events, &out)
+
+	want := "\033]2;compterm: disconnected (EOF), reconnecting...\a\033]2;compterm\a"
+	if got := out.String(); got != want {
+		t.Fatalf("render output = %q, want %q", got, want)
 	}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			got, err := buildURL(tt.raw, tt.token)
-			if err != nil {
-				t.Fatalf("buildURL: %v", err)
-			}
-			if got != tt.want {
-				t.Errorf("buildURL(%q, %q) = %q, want %q", tt.raw, tt.token, got, tt.want)
-			}
-		})
+	// a shift clears the screen for the snapshot that follows
+	events = make(chan client.Event, 2)
+	events <- client.Event{Type: client.Shift, Offset: 30 * time.Second}
+	events <- client.Event{Type: client.Shift}
+	close(events)
+
+	out.Reset()
+	render(events, &out)
+
+	want = "\033]2;compterm: 30s behind live (l: back to live)\a\033[H\033[2J" +
+		"\033]2;compterm\a\033[H\033[2J"
+	if got := out.String(); got != want {
+		t.Fatalf("render output = %q, want %q", got, want)
 	}
 }
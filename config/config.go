@@ -8,6 +8,8 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 	"unicode"
 
 	"github.com/crgimenes/compterm/constants"
@@ -15,14 +17,34 @@ import (
 )
 
 type Config struct {
-	IgnorePID bool
-	Listen    string
-	Command   string
-	AuthToken string
-	Term      string
-	ColorTerm string
-	Path      string
-	InitFile  string
+	Mode           string // subcommand: "" shares a command, "relay" re-serves Upstream, "hub" accepts pushed sessions, "keygen" prints an E2EKey, "render" animates or converts Input, "replay" serves it
+	Upstream       string
+	UpstreamToken  string
+	Push           string
+	PushToken      string
+	IgnorePID      bool
+	Listen         string
+	Command        string
+	AuthToken      string
+	EmbedToken     string
+	E2EKey         string
+	AllowedOrigins string
+	Term           string
+	ColorTerm      string
+	Path           string
+	InitFile       string
+	Record         string // file to record the shared session to (asciicast v2)
+
+	// render and replay: the recording (and, for a script(1) typescript,
+	// its Timing file), the GIF, PNG, or asciicast file to write, and how
+	// (see render.AnimationOptions)
+	Input     string
+	Timing    string
+	Output    string
+	FPS       int
+	IdleLimit time.Duration
+	FontSize  float64
+	Loop      bool
 }
 
 var CFG = &Config{}
@@ -53,24 +75,62 @@ const defaultInitFilo = `;; init.filo — compterm configuration
 ;;
 ;; (set Listen "0.0.0.0:2200") ; web/websocket listen address
 ;; (set AuthToken "")          ; viewer access token (empty disables auth)
+;; (set EmbedToken "")         ; read-only token for /embed links only
+;; (set AllowedOrigins "")     ; sites allowed to embed, e.g. "*.example.com"
+;; (set E2EKey "")             ; end-to-end encryption key (see compterm keygen)
 ;; (set Command "/bin/zsh")    ; command to share (defaults to $SHELL)
 ;; (set Term "xterm-256color") ; TERM for the shared command (empty = inherit)
 ;; (set ColorTerm "truecolor") ; COLORTERM (empty disables 24-bit color)
 ;; (set IgnorePID #f)          ; ignore the COMPTERM pid guard
+;; (set Upstream "")           ; relay: websocket URL of the compterm to re-serve
+;; (set UpstreamToken "")      ; relay: access token for the upstream
+;; (set Push "")               ; hub URL to push this session to (wss://hub/push/name)
+;; (set PushToken "")          ; token for pushing to (or, for a hub, accepting) sessions
+;; (set Record "")             ; file to record the shared session to (asciicast v2)
 ;;
 ;; getEnv reads an environment variable, falling back to the second argument:
 ;; (set AuthToken (getEnv "COMPTERM_AUTH_TOKEN" ""))
 `
 
+// Modes selected by the first command-line argument.
+const (
+	ModeShare  = ""
+	ModeRelay  = "relay"
+	ModeHub    = "hub"
+	ModeKeygen = "keygen"
+	ModeRender = "render"
+	ModeReplay = "replay"
+)
+
+// Defaults of the render options.
+const (
+	defaultFPS       = 10
+	defaultIdleLimit = 2 * time.Second
+	defaultFontSize  = 16
+)
+
 // Load resolves the configuration from defaults, environment variables,
 // command-line flags, and finally the Filo configuration file (which takes
-// precedence). The resulting values are validated before returning.
+// precedence). A leading subcommand (compterm relay ...) selects the Mode. The
+// resulting values are validated before returning.
 func Load() error {
 	if err := applyDefaultsAndEnv(CFG); err != nil {
 		return err
 	}
 
-	parseFlags(CFG)
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		CFG.Mode, args = args[0], args[1:]
+	}
+	// compterm render session.cast -o session.gif: flag parsing stops at the
+	// first argument, so take the recording first
+	if (CFG.Mode == ModeRender || CFG.Mode == ModeReplay) && len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		CFG.Input, args = args[0], args[1:]
+	}
+	parseFlags(CFG, args)
+	if CFG.Input == "" {
+		CFG.Input = flag.Arg(0)
+	}
 
 	if err := loadFilo(CFG); err != nil {
 		return err
@@ -92,28 +152,53 @@ func applyDefaultsAndEnv(c *Config) error {
 
 	c.Listen = envOr("COMPTERM_LISTEN", defaultListen)
 	c.AuthToken = os.Getenv("COMPTERM_AUTH_TOKEN")
+	c.EmbedToken = os.Getenv("COMPTERM_EMBED_TOKEN")
+	c.E2EKey = os.Getenv("COMPTERM_E2E_KEY")
+	c.AllowedOrigins = os.Getenv("COMPTERM_ALLOWED_ORIGINS")
 	c.Command = envOr("COMPTERM_COMMAND", os.Getenv("SHELL"))
 	c.Term = envOr("COMPTERM_TERM", defaultTerm)
 	c.ColorTerm = envOr("COMPTERM_COLORTERM", defaultColorTerm)
 	c.Path = envOr("COMPTERM_PATH", defaultPath)
 	c.InitFile = envOr("COMPTERM_INIT_FILE", defaultInitFile)
 	c.IgnorePID = os.Getenv("COMPTERM_IGNORE_PID") == "true"
+	c.Upstream = os.Getenv("COMPTERM_UPSTREAM")
+	c.UpstreamToken = os.Getenv("COMPTERM_UPSTREAM_TOKEN")
+	c.Push = os.Getenv("COMPTERM_PUSH")
+	c.PushToken = os.Getenv("COMPTERM_PUSH_TOKEN")
+	c.Record = os.Getenv("COMPTERM_RECORD")
+	c.FPS = defaultFPS
+	c.IdleLimit = defaultIdleLimit
+	c.FontSize = defaultFontSize
 
 	return nil
 }
 
-func parseFlags(c *Config) {
+func parseFlags(c *Config, args []string) {
 	flag.StringVar(&c.Listen, "listen", c.Listen, "web/websocket listen address")
 	flag.StringVar(&c.AuthToken, "auth_token", c.AuthToken, "viewer access token (empty disables authentication)")
+	flag.StringVar(&c.EmbedToken, "embed_token", c.EmbedToken, "read-only token accepted only by /embed and its websocket")
+	flag.StringVar(&c.E2EKey, "e2e_key", c.E2EKey, "end-to-end encryption key; viewers need it too (see compterm keygen)")
+	flag.StringVar(&c.AllowedOrigins, "allowed_origins", c.AllowedOrigins, "comma-separated host patterns allowed to embed the viewer")
 	flag.StringVar(&c.Command, "command", c.Command, "command to share (defaults to $SHELL)")
 	flag.StringVar(&c.Term, "term", c.Term, "TERM for the shared command (empty inherits the host's)")
 	flag.StringVar(&c.ColorTerm, "colorterm", c.ColorTerm, "COLORTERM for the shared command (empty disables truecolor)")
 	flag.StringVar(&c.Path, "path", c.Path, "path to configuration files")
 	flag.StringVar(&c.InitFile, "init", c.InitFile, "configuration file name")
 	flag.BoolVar(&c.IgnorePID, "ignore_pid", c.IgnorePID, "ignore the COMPTERM pid guard")
+	flag.StringVar(&c.Upstream, "upstream", c.Upstream, "relay: websocket URL of the compterm to re-serve")
+	flag.StringVar(&c.UpstreamToken, "upstream_token", c.UpstreamToken, "relay: access token for the upstream")
+	flag.StringVar(&c.Push, "push", c.Push, "hub URL to push this session to (e.g. wss://hub:2200/push/name)")
+	flag.StringVar(&c.PushToken, "push_token", c.PushToken, "token for pushing to a hub; a hub requires it from pushing hosts")
+	flag.StringVar(&c.Record, "record", c.Record, "record the shared session to this file (asciicast v2)")
+	flag.StringVar(&c.Timing, "timing", c.Timing, "render, replay: timing file of a script(1) typescript")
+	flag.StringVar(&c.Output, "o", c.Output, "render: file to write, .gif, .png (animated PNG), or .cast (asciicast v2)")
+	flag.IntVar(&c.FPS, "fps", c.FPS, "render: most frames per second")
+	flag.DurationVar(&c.IdleLimit, "idle_limit", c.IdleLimit, "render, replay: longest pause kept")
+	flag.Float64Var(&c.FontSize, "font_size", c.FontSize, "render: font size in pixels")
+	flag.BoolVar(&c.Loop, "loop", c.Loop, "replay: start over at the end")
 
 	flag.Usage = usage
-	flag.Parse()
+	_ = flag.CommandLine.Parse(args) // ExitOnError: never returns an error
 }
 
 // loadFilo seeds the current configuration as Filo globals, evaluates the
@@ -139,10 +224,18 @@ func loadFilo(c *Config) error {
 
 	f.SetGlobal("Listen", c.Listen)
 	f.SetGlobal("AuthToken", c.AuthToken)
+	f.SetGlobal("EmbedToken", c.EmbedToken)
+	f.SetGlobal("AllowedOrigins", c.AllowedOrigins)
+	f.SetGlobal("E2EKey", c.E2EKey)
 	f.SetGlobal("Command", c.Command)
 	f.SetGlobal("Term", c.Term)
 	f.SetGlobal("ColorTerm", c.ColorTerm)
 	f.SetGlobal("IgnorePID", c.IgnorePID)
+	f.SetGlobal("Upstream", c.Upstream)
+	f.SetGlobal("UpstreamToken", c.UpstreamToken)
+	f.SetGlobal("Push", c.Push)
+	f.SetGlobal("PushToken", c.PushToken)
+	f.SetGlobal("Record", c.Record)
 	f.SetGlobal("Path", c.Path)
 	f.SetGlobal("InitFile", c.InitFile)
 
@@ -160,10 +253,18 @@ func loadFilo(c *Config) error {
 
 	c.Listen = filoString(f, "Listen", c.Listen)
 	c.AuthToken = filoString(f, "AuthToken", c.AuthToken)
+	c.EmbedToken = filoString(f, "EmbedToken", c.EmbedToken)
+	c.AllowedOrigins = filoString(f, "AllowedOrigins", c.AllowedOrigins)
+	c.E2EKey = filoString(f, "E2EKey", c.E2EKey)
 	c.Command = filoString(f, "Command", c.Command)
 	c.Term = filoString(f, "Term", c.Term)
 	c.ColorTerm = filoString(f, "ColorTerm", c.ColorTerm)
 	c.IgnorePID = filoBool(f, "IgnorePID", c.IgnorePID)
+	c.Upstream = filoString(f, "Upstream", c.Upstream)
+	c.UpstreamToken = filoString(f, "UpstreamToken", c.UpstreamToken)
+	c.Push = filoString(f, "Push", c.Push)
+	c.PushToken = filoString(f, "PushToken", c.PushToken)
+	c.Record = filoString(f, "Record", c.Record)
 
 	return nil
 }
@@ -215,6 +316,39 @@ func builtinGetEnv(_ context.Context, args []filo.Value) (filo.Value, error) {
 }
 
 func validate(c *Config) error {
+	switch c.Mode {
+	case ModeShare:
+	case ModeRelay:
+		if c.Upstream == "" {
+			return errors.New("relay needs an -upstream websocket URL")
+		}
+	case ModeKeygen:
+	case ModeHub:
+		if c.Push != "" {
+			return errors.New("a hub cannot -push; it accepts pushed sessions")
+		}
+	case ModeRender:
+		if c.Input == "" || c.Output == "" {
+			return errors.New("render needs a recording and an -o output file")
+		}
+		switch strings.ToLower(filepath.Ext(c.Output)) {
+		case ".gif", ".png", ".apng", ".cast":
+		default:
+			return fmt.Errorf("cannot render to %q: want a .gif, .png, or .cast file", c.Output)
+		}
+		if c.FPS <= 0 || c.IdleLimit <= 0 || c.FontSize <= 0 {
+			return errors.New("-fps, -idle_limit, and -font_size must be positive")
+		}
+	case ModeReplay:
+		if c.Input == "" {
+			return errors.New("replay needs a recording")
+		}
+		if c.IdleLimit < 0 {
+			return errors.New("-idle_limit must not be negative")
+		}
+	default:
+		return fmt.Errorf("unknown command %q", c.Mode)
+	}
 	if c.Listen == "" {
 		return errors.New("listen address must not be empty")
 	}
@@ -230,6 +364,14 @@ func validate(c *Config) error {
 	return nil
 }
 
+// OriginPatterns splits AllowedOrigins on commas and whitespace into the host
+// patterns (e.g. "example.com", "*.example.com") accepted as embedding origins.
+func (c *Config) OriginPatterns() []string {
+	return strings.FieldsFunc(c.AllowedOrigins, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
+
 func envOr(key, fallback string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
@@ -280,12 +422,21 @@ func usage() {
 	}
 
 	p("Compterm - A terminal sharing tool\n\n")
-	p("Usage: compterm [options]\n\n")
+	p("Usage: compterm [options]\n")
+	p("       compterm relay -upstream ws://origin:2200/ws [options]\n")
+	p("       compterm hub [-push_token token] [options]\n")
+	p("       compterm keygen\n")
+	p("       compterm render recording -o out.gif [-fps n] [-idle_limit d]\n")
+	p("       compterm replay recording [-loop] [options]\n")
+	p("    (a recording is asciicast, ttyrec, or a script(1) typescript with -timing)\n\n")
 	p("Options:\n")
 	flag.PrintDefaults()
 	p("\nEnvironment variables (override defaults, overridden by flags and the config file):\n")
-	p("    COMPTERM_LISTEN, COMPTERM_AUTH_TOKEN, COMPTERM_COMMAND, COMPTERM_TERM,\n")
-	p("    COMPTERM_COLORTERM, COMPTERM_PATH, COMPTERM_INIT_FILE, COMPTERM_IGNORE_PID\n")
+	p("    COMPTERM_LISTEN, COMPTERM_AUTH_TOKEN, COMPTERM_EMBED_TOKEN, COMPTERM_E2E_KEY,\n")
+	p("    COMPTERM_ALLOWED_ORIGINS, COMPTERM_COMMAND, COMPTERM_TERM,\n")
+	p("    COMPTERM_COLORTERM, COMPTERM_PATH, COMPTERM_INIT_FILE, COMPTERM_IGNORE_PID,\n")
+	p("    COMPTERM_UPSTREAM, COMPTERM_UPSTREAM_TOKEN, COMPTERM_PUSH,\n")
+	p("    COMPTERM_PUSH_TOKEN, COMPTERM_RECORD\n")
 	p("\nConfiguration file (Filo):\n")
 	p("    Looked up at ./init.filo, then $COMPTERM_PATH/init.filo.\n")
 	p("    Overrides every other setting except -path and -init.\n")
@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func newTestConfig(path string) *Config {
@@ -119,6 +120,23 @@ func TestValidate(t *testing.T) {
 		{name: "empty listen", mutate: func(c *Config) { c.Listen = "" }, wantErr: true},
 		{name: "empty command", mutate: func(c *Config) { c.Command = "" }, wantErr: true},
 		{name: "empty path", mutate: func(c *Config) { c.Path = "" }, wantErr: true},
+		{name: "relay", mutate: func(c *Config) { c.Mode, c.Upstream = ModeRelay, "ws://origin/ws" }},
+		{name: "relay without upstream", mutate: func(c *Config) { c.Mode = ModeRelay }, wantErr: true},
+		{name: "hub", mutate: func(c *Config) { c.Mode = ModeHub }},
+		{name: "hub with push", mutate: func(c *Config) { c.Mode, c.Push = ModeHub, "ws://hub/push/x" }, wantErr: true},
+		{name: "keygen", mutate: func(c *Config) { c.Mode = ModeKeygen }},
+		{name: "render", mutate: func(c *Config) {
+			c.Mode, c.Input, c.Output, c.FPS, c.IdleLimit, c.FontSize = ModeRender, "a.cast", "a.gif", 10, time.Second, 16
+		}},
+		{name: "render without output", mutate: func(c *Config) {
+			c.Mode, c.Input, c.FPS, c.IdleLimit, c.FontSize = ModeRender, "a.cast", 10, time.Second, 16
+		}, wantErr: true},
+		{name: "render to a bad format", mutate: func(c *Config) {
+			c.Mode, c.Input, c.Output, c.FPS, c.IdleLimit, c.FontSize = ModeRender, "a.cast", "a.mp4", 10, time.Second, 16
+		}, wantErr: true},
+		{name: "replay", mutate: func(c *Config) { c.Mode, c.Input = ModeReplay, "a.ttyrec" }},
+		{name: "replay without recording", mutate: func(c *Config) { c.Mode = ModeReplay }, wantErr: true},
+		{name: "unknown mode", mutate: func(c *Config) { c.Mode = "bogus" }, wantErr: true},
 	}
 
 	for _, tt := range tests {
@@ -137,3 +155,28 @@ func TestValidate(t *testing.T) {
 		})
 	}
 }
+
+func TestOriginPatterns(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"example.com", []string{"example.com"}},
+		{"example.com, *.school.edu", []string{"example.com", "*.school.edu"}},
+		{" a.com,,b.com  c.com ", []string{"a.com", "b.com", "c.com"}},
+	}
+
+	for _, tt := range tests {
+		c := &Config{AllowedOrigins: tt.in}
+		got := c.OriginPatterns()
+		if len(got) != len(tt.want) {
+			t.Fatalf("OriginPatterns(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Fatalf("OriginPatterns(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		}
+	}
+}
@@ -8,4 +8,9 @@ const (
 
 	MSG    = 0x1
 	RESIZE = 0x2
+	SEQ    = 0x3 // stream position "epoch:seq", see screen.ResumeClient
+	ENC    = 0x4 // frames sealed end to end, see package e2e
+	REWIND = 0x5 // viewer: play from this many seconds ago; server: now playing that far back
+	LIVE   = 0x6 // viewer: back to live; server: live again, a snapshot follows
+	DIFF   = 0x7 // changed cells, for viewers in diff mode, see package diff
 )
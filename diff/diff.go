@@ -0,0 +1,177 @@
+// Package diff implements compterm's cell-diff wire mode, in the spirit of
+// mosh's state synchronization: instead of the raw output, a viewer is sent
+// the cells of the screen that changed since the last state it received. A
+// slow viewer skips intermediate states and still converges on the current
+// screen.
+//
+// A DIFF payload is a header followed by runs of cells that share a style:
+//
+//	header  flags u8, cursor row u16, cursor column u16
+//	run     row u16, column u16, style [12]byte, text length u16, text
+//
+// The style is the mterm.SGRState as ColorType, Flags, FG, BG, UL, Extra;
+// the text is UTF-8, one grapheme per cell (a rune and the zero width ones
+// after it) and none for the second cell of a wide character. Numbers are big
+// endian. A payload flagged Full redraws the screen from blank, and a RESIZE
+// frame precedes it when the size changed.
+package diff
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/crgimenes/compterm/constants"
+	"github.com/crgimenes/compterm/mterm"
+)
+
+// Full flags a payload drawn on a cleared screen.
+const Full byte = 1
+
+const (
+	headerSize = 5
+	styleSize  = 12
+	runHeader  = 2 + 2 + styleSize + 2
+	// maxPayload bounds one payload so its frame fits a client's buffer.
+	maxPayload = constants.BufferSize - 1024
+	// maxGap is how many unchanged cells a run spans rather than starting a
+	// new one.
+	maxGap = 4
+)
+
+var ErrMalformed = errors.New("diff: malformed payload")
+
+func grapheme(c mterm.Cell) string {
+	if c.Char < ' ' {
+		return " "
+	}
+	return c.Grapheme()
+}
+
+func same(a, b mterm.Cell) bool {
+	return grapheme(a) == grapheme(b) && a.Width() == b.Width() && a.SGRState == b.SGRState
+}
+
+// Encode returns the payloads that turn prev into cur, nil when nothing
+// changed. Against a nil prev, or one of another size, they redraw the whole
+// screen: the first is flagged Full.
+func Encode(prev, cur *mterm.Snapshot) [][]byte {
+	full := prev == nil || prev.Rows != cur.Rows || prev.Cols != cur.Cols
+	var flags byte
+	if full {
+		flags = Full
+	}
+
+	var (
+		out   [][]byte
+		buf   = header(nil, flags, cur.Cursor)
+		blank = make([]mterm.Cell, cur.Cols)
+	)
+	for r := range cur.Rows {
+		row := cur.Cells[r*cur.Cols : (r+1)*cur.Cols]
+		old := blank
+		if !full {
+			old = prev.Cells[r*cur.Cols : (r+1)*cur.Cols]
+		}
+
+		for c := 0; c < cur.Cols; {
+			if same(row[c], old[c]) {
+				c++
+				continue
+			}
+			// a run: cells of one style, bridging short unchanged gaps,
+			// from the start of a wide character
+			if row[c].Width() == 0 && c > 0 {
+				c--
+			}
+			start, last := c, c
+			for end := c + 1; end < cur.Cols && row[end].SGRState == row[start].SGRState; end++ {
+				if !same(row[end], old[end]) {
+					last = end
+				} else if end-last > maxGap {
+					break
+				}
+			}
+
+			run := appendRun(nil, r, start, row[start:last+1])
+			if len(buf)+len(run) > maxPayload {
+				out = append(out, buf)
+				buf = header(nil, 0, cur.Cursor)
+			}
+			buf = append(buf, run...)
+			c = last + 1
+		}
+	}
+
+	if full || len(buf) > headerSize || prev.Cursor != cur.Cursor {
+		out = append(out, buf)
+	}
+	return out
+}
+
+func header(b []byte, flags byte, cursor [2]int) []byte {
+	b = append(b, flags)
+	b = binary.BigEndian.AppendUint16(b, uint16(cursor[0]))    // #nosec G115 -- screen coordinates
+	return binary.BigEndian.AppendUint16(b, uint16(cursor[1])) // #nosec G115 -- screen coordinates
+}
+
+func appendRun(b []byte, row, col int, cells []mterm.Cell) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(row)) // #nosec G115 -- screen coordinates
+	b = binary.BigEndian.AppendUint16(b, uint16(col)) // #nosec G115 -- screen coordinates
+	st := cells[0].SGRState
+	b = append(b, st.ColorType, st.Flags)
+	b = append(b, st.FG[:]...)
+	b = append(b, st.BG[:]...)
+	b = append(b, st.UL[:]...)
+	b = append(b, st.Extra)
+
+	n := len(b)
+	b = append(b, 0, 0)
+	for _, c := range cells {
+		if c.Width() > 0 {
+			b = append(b, grapheme(c)...)
+		}
+	}
+	binary.BigEndian.PutUint16(b[n:], uint16(len(b)-n-2)) // #nosec G115 -- a row of graphemes
+	return b
+}
+
+// Render appends the ANSI that applies a payload to a terminal showing the
+// state it was encoded against.
+func Render(dst, payload []byte) ([]byte, error) {
+	if len(payload) < headerSize {
+		return dst, ErrMalformed
+	}
+	flags := payload[0]
+	crow := binary.BigEndian.Uint16(payload[1:])
+	ccol := binary.BigEndian.Uint16(payload[3:])
+	p := payload[headerSize:]
+
+	if flags&Full != 0 {
+		dst = append(dst, "\033[0m\033[H\033[2J"...)
+	}
+	for len(p) > 0 {
+		if len(p) < runHeader {
+			return dst, ErrMalformed
+		}
+		row := binary.BigEndian.Uint16(p)
+		col := binary.BigEndian.Uint16(p[2:])
+		var st mterm.SGRState
+		st.ColorType, st.Flags = p[4], p[5]
+		copy(st.FG[:], p[6:9])
+		copy(st.BG[:], p[9:12])
+		copy(st.UL[:], p[12:15])
+		st.Extra = p[15]
+		n := int(binary.BigEndian.Uint16(p[16:]))
+		if len(p) < runHeader+n {
+			return dst, ErrMalformed
+		}
+
+		dst = fmt.Appendf(dst, "\033[%d;%dH", row+1, col+1)
+		dst = st.AppendANSI(dst)
+		dst = append(dst, p[runHeader:runHeader+n]...)
+		p = p[runHeader+n:]
+	}
+	dst = append(dst, "\033[0m"...)
+	return fmt.Appendf(dst, "\033[%d;%dH", crow+1, ccol+1), nil
+}
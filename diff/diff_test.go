@@ -0,0 +1,108 @@
+package diff
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/crgimenes/compterm/mterm"
+)
+
+// apply renders the payloads into a mirror terminal.
+func apply(t *testing.T, mirror *mterm.Terminal, payloads [][]byte) {
+	t.Helper()
+	for _, p := range payloads {
+		out, err := Render(nil, p)
+		if err != nil {
+			t.Fatalf("Render: %v", err)
+		}
+		_, _ = mirror.Write(out)
+	}
+}
+
+func equal(t *testing.T, want, got *mterm.Snapshot) {
+	t.Helper()
+	if got.Cursor != want.Cursor {
+		t.Errorf("cursor = %v, want %v", got.Cursor, want.Cursor)
+	}
+	for i := range want.Cells {
+		if !same(want.Cells[i], got.Cells[i]) {
+			t.Fatalf("cell %d,%d = %q %+v, want %q %+v", i/want.Cols, i%want.Cols,
+				got.Cells[i].Char, got.Cells[i].SGRState, want.Cells[i].Char, want.Cells[i].SGRState)
+		}
+	}
+}
+
+func TestEncodeRender(t *testing.T) {
+	host := mterm.New(6, 20)
+	mirror := mterm.New(6, 20)
+
+	steps := []string{
+		"hello \033[1;31mred\033[0m world\r\n\033[44mblue\033[0m é",
+		"\033[1;7Hthere",
+		"\033[3;1H\033[38;5;200mx\033[0m   \033[4my\033[0m",
+		"\033[2J\033[H" + strings.Repeat("z", 30),
+		"\033[5;5H",
+		"\033[6;1H中文 and 😀\033[6;3Hx", // wide characters, one half overwritten
+	}
+
+	var prev *mterm.Snapshot
+	for _, s := range steps {
+		_, _ = host.Write([]byte(s))
+		cur := host.Snapshot()
+		apply(t, mirror, Encode(prev, cur))
+		equal(t, cur, mirror.Snapshot())
+		prev = cur
+	}
+
+	if got := Encode(prev, host.Snapshot()); got != nil {
+		t.Errorf("Encode of an unchanged screen = %d payloads, want none", len(got))
+	}
+}
+
+func TestEncodeFull(t *testing.T) {
+	host := mterm.New(4, 10)
+	_, _ = host.Write([]byte("abc"))
+	prev := host.Snapshot()
+
+	host.Resize(5, 12)
+	cur := host.Snapshot()
+	payloads := Encode(prev, cur)
+	if len(payloads) != 1 || payloads[0][0]&Full == 0 {
+		t.Fatalf("Encode after a resize is not a full redraw")
+	}
+
+	mirror := mterm.New(5, 12)
+	_, _ = mirror.Write([]byte("garbage"))
+	apply(t, mirror, payloads)
+	equal(t, cur, mirror.Snapshot())
+}
+
+func TestEncodeChunks(t *testing.T) {
+	host := mterm.New(200, 200)
+	for range 200 {
+		_, _ = host.Write([]byte("\033[1m" + strings.Repeat("\033[7mж\033[27mж", 100) + "\033[0m"))
+	}
+	_, _ = host.Write([]byte("\033[H"))
+	cur := host.Snapshot()
+	payloads := Encode(nil, cur)
+	if len(payloads) < 2 {
+		t.Fatalf("a large screen fit in %d payload", len(payloads))
+	}
+	for _, p := range payloads {
+		if len(p) > maxPayload {
+			t.Fatalf("payload of %d bytes, limit %d", len(p), maxPayload)
+		}
+	}
+
+	mirror := mterm.New(200, 200)
+	apply(t, mirror, payloads)
+	equal(t, cur, mirror.Snapshot())
+}
+
+func TestRenderMalformed(t *testing.T) {
+	for _, p := range []string{"", "\x00\x00", "\x00\x00\x00\x00\x00\x00\x01"} {
+		if _, err := Render(nil, []byte(p)); err == nil {
+			t.Errorf("Render(%q) succeeded", p)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Package e2e encrypts a compterm stream end to end, so relays and hubs can
+// forward a session without reading it. Host and viewers share a 256-bit key
+// out of band (the URL fragment for the browser, a flag elsewhere); everyone in
+// between only ever sees ENC frames.
+//
+// An ENC payload is [flags][seq][epoch][nonce][ciphertext]: the ciphertext,
+// sealed with AES-256-GCM (the AEAD every browser's WebCrypto provides), holds
+// one or more ordinary protocol frames. The flags byte stays readable, and
+// authenticated, so an intermediary can tell keyframes — a full snapshot
+// sealed by the host — from deltas and start late joiners at the latest
+// keyframe. Seq numbers the payloads the host seals and epoch is the seq of
+// the keyframe they follow, both big endian 64-bit and authenticated too, so
+// viewers can tell a relay replayed or reordered payloads (see Order).
+package e2e
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// KeySize is the key length in bytes.
+	KeySize = 32
+	// Overhead is what sealing adds to the plaintext: the header, nonce, and
+	// tag.
+	Overhead = headerSize + nonceSize + tagSize
+
+	headerSize = 1 + 8 + 8 // flags, seq, epoch
+	nonceSize  = 12
+	tagSize    = 16
+)
+
+// Keyframe flags a payload that starts a full snapshot of the screen. A viewer
+// joining mid-stream skips deltas until the next keyframe.
+const Keyframe byte = 1
+
+var (
+	ErrInvalidKey = errors.New("e2e: key must be 32 bytes of base64")
+	ErrOpen       = errors.New("e2e: message authentication failed (wrong key?)")
+	ErrReplay     = errors.New("e2e: payload replayed or out of order")
+)
+
+// Key seals and opens ENC payloads.
+type Key struct {
+	aead cipher.AEAD
+
+	mu         sync.Mutex
+	seq, epoch uint64 // of the last payload sealed
+}
+
+// Header is what an ENC payload carries in the clear: its flags, its sequence
+// number, and the sequence number of the keyframe it follows.
+type Header struct {
+	Flags      byte
+	Seq, Epoch uint64
+}
+
+// NewKey returns a fresh random key, encoded as ParseKey expects.
+func NewKey() string {
+	k := make([]byte, KeySize)
+	_, _ = rand.Read(k) // never fails, see crypto/rand.Read
+	return base64.RawURLEncoding.EncodeToString(k)
+}
+
+// ParseKey decodes a key written in base64, standard or URL-safe, with or
+// without padding, so the output of NewKey and of openssl rand -base64 32 both
+// work.
+func ParseKey(s string) (*Key, error) {
+	s = strings.TrimRight(strings.TrimSpace(s), "=")
+	s = strings.NewReplacer("+", "-", "/", "_").Replace(s)
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(raw) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// counting from the clock, a restarted host seals above what viewers saw
+	return &Key{aead: aead, seq: uint64(time.Now().UnixNano())}, nil // #nosec G115 -- after 1970
+}
+
+// Seal encrypts plaintext (one or more protocol frames) into an ENC payload,
+// numbered after the last one sealed. A keyframe starts a new epoch.
+func (k *Key) Seal(flags byte, plaintext []byte) []byte {
+	k.mu.Lock()
+	k.seq++
+	if flags&Keyframe != 0 {
+		k.epoch = k.seq
+	}
+	seq, epoch := k.seq, k.epoch
+	k.mu.Unlock()
+
+	out := make([]byte, headerSize+nonceSize, Overhead+len(plaintext))
+	out[0] = flags
+	binary.BigEndian.PutUint64(out[1:], seq)
+	binary.BigEndian.PutUint64(out[9:], epoch)
+	_, _ = rand.Read(out[headerSize:])
+	return k.aead.Seal(out, out[headerSize:], plaintext, out[:headerSize])
+}
+
+// Open decrypts an ENC payload, returning its header and plaintext.
+func (k *Key) Open(payload []byte) (h Header, plaintext []byte, err error) {
+	if len(payload) < Overhead {
+		return Header{}, nil, ErrOpen
+	}
+	nonce := payload[headerSize : headerSize+nonceSize]
+	plaintext, err = k.aead.Open(nil, nonce, payload[headerSize+nonceSize:], payload[:headerSize])
+	if err != nil {
+		return Header{}, nil, ErrOpen
+	}
+	h = Header{
+		Flags: payload[0],
+		Seq:   binary.BigEndian.Uint64(payload[1:]),
+		Epoch: binary.BigEndian.Uint64(payload[9:]),
+	}
+	return h, plaintext, nil
+}
+
+// Order holds a viewer to the order the host sealed payloads in: each must be
+// numbered above the last one accepted and follow the same keyframe or a later
+// one, so a relay can neither replay nor reorder them. The zero value accepts
+// any first payload.
+type Order struct {
+	seq, epoch uint64
+}
+
+// Check accepts the payload with header h, or returns ErrReplay.
+func (o *Order) Check(h Header) error {
+	if h.Seq <= o.seq || h.Epoch < o.epoch || h.Epoch > h.Seq ||
+		h.Flags&Keyframe != 0 && h.Epoch != h.Seq {
+		return ErrReplay
+	}
+	o.seq, o.epoch = h.Seq, h.Epoch
+	return nil
+}
+
+// IsKeyframe reports whether an ENC payload starts a snapshot. It needs no key.
+func IsKeyframe(payload []byte) bool {
+	return len(payload) > 0 && payload[0]&Keyframe != 0
+}
@@ -0,0 +1,117 @@
+package e2e
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		wantErr bool
+	}{
+		{name: "new key", in: NewKey()},
+		{name: "standard padded", in: "q83vEjRWeJCrze8SNFZ4kKvN7xI0VniQq83vEjRWeJA="},
+		{name: "url safe", in: "-_-_EjRWeJCrze8SNFZ4kKvN7xI0VniQq83vEjRWeJA"},
+		{name: "surrounding space", in: " q83vEjRWeJCrze8SNFZ4kKvN7xI0VniQq83vEjRWeJA=\n"},
+		{name: "empty", in: "", wantErr: true},
+		{name: "too short", in: "q83vEjRWeJA", wantErr: true},
+		{name: "not base64", in: "not a key at all, not a key at all, not a key", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseKey(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseKey(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	k, err := ParseKey(NewKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := ParseKey(NewKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := []byte("frames go here")
+	sealed := k.Seal(Keyframe, msg)
+	if len(sealed) != len(msg)+Overhead {
+		t.Fatalf("sealed length = %d, want %d", len(sealed), len(msg)+Overhead)
+	}
+	if bytes.Contains(sealed, msg) {
+		t.Fatal("sealed payload contains the plaintext")
+	}
+	if !IsKeyframe(sealed) || IsKeyframe(k.Seal(0, msg)) {
+		t.Fatal("IsKeyframe does not follow the flags")
+	}
+
+	h, got, err := k.Open(sealed)
+	if err != nil || h.Flags != Keyframe || !bytes.Equal(got, msg) {
+		t.Fatalf("Open = %d, %q, %v; want %d, %q, nil", h.Flags, got, err, Keyframe, msg)
+	}
+
+	if _, _, err := other.Open(sealed); err != ErrOpen {
+		t.Fatalf("Open with another key: err = %v, want ErrOpen", err)
+	}
+
+	// the flags are authenticated: a relay cannot turn a delta into a keyframe
+	forged := bytes.Clone(k.Seal(0, msg))
+	forged[0] = Keyframe
+	if _, _, err := k.Open(forged); err != ErrOpen {
+		t.Fatalf("Open of forged flags: err = %v, want ErrOpen", err)
+	}
+
+	if _, _, err := k.Open(sealed[:Overhead-1]); err != ErrOpen {
+		t.Fatalf("Open of a short payload: err = %v, want ErrOpen", err)
+	}
+
+	// so are the sequence number and epoch
+	forged = bytes.Clone(k.Seal(0, msg))
+	forged[8]++
+	if _, _, err := k.Open(forged); err != ErrOpen {
+		t.Fatalf("Open of a forged sequence number: err = %v, want ErrOpen", err)
+	}
+}
+
+func TestOrder(t *testing.T) {
+	k, err := ParseKey(NewKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sealed [][]byte
+	for _, flags := range []byte{0, Keyframe, 0, 0, Keyframe, 0} {
+		sealed = append(sealed, k.Seal(flags, []byte("frames")))
+	}
+
+	tests := []struct {
+		name  string
+		order []int // indexes into sealed
+		want  []error
+	}{
+		{"in order", []int{0, 1, 2, 3, 4, 5}, []error{nil, nil, nil, nil, nil, nil}},
+		{"gaps", []int{1, 3, 5}, []error{nil, nil, nil}},
+		{"replayed", []int{1, 2, 2}, []error{nil, nil, ErrReplay}},
+		{"replayed keyframe", []int{1, 2, 1, 3}, []error{nil, nil, ErrReplay, nil}},
+		{"reordered", []int{1, 3, 2, 4}, []error{nil, nil, ErrReplay, nil}},
+		{"old epoch", []int{4, 3}, []error{nil, ErrReplay}},
+	}
+	for _, tt := range tests {
+		var o Order
+		for i, n := range tt.order {
+			h, _, err := k.Open(sealed[n])
+			if err != nil {
+				t.Fatalf("%s: open %d: %v", tt.name, n, err)
+			}
+			if err := o.Check(h); err != tt.want[i] {
+				t.Errorf("%s: payload %d: Check = %v, want %v", tt.name, n, err, tt.want[i])
+			}
+		}
+	}
+}
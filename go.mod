@@ -6,7 +6,9 @@ require (
 	github.com/coder/websocket v1.8.15
 	github.com/creack/pty v1.1.24
 	github.com/crgimenes/filo v0.0.10
+	golang.org/x/image v0.25.0
 	golang.org/x/term v0.44.0
+	golang.org/x/text v0.23.0
 )
 
 require golang.org/x/sys v0.46.0 // indirect
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/crgimenes/compterm/config"
+)
+
+// hotkeyPrefix introduces the host's own hotkeys, tmux style: Ctrl-] then a
+// key. Ctrl-] twice sends one Ctrl-] to the program, and Ctrl-] followed by
+// a key that is not a hotkey sends both.
+const hotkeyPrefix = 0x1d
+
+// hotkeys filters the host's keyboard on its way to the shared program,
+// taking out the hotkeys and running their actions.
+type hotkeys struct {
+	r       io.Reader
+	actions map[byte]func()
+	buf     []byte
+	prefix  bool // the last key read was the prefix
+}
+
+func (h *hotkeys) Read(p []byte) (int, error) {
+	if len(p) < 2 {
+		return 0, io.ErrShortBuffer
+	}
+	for {
+		// one byte short: a prefix held from the last read may come back
+		if cap(h.buf) < len(p)-1 {
+			h.buf = make([]byte, len(p)-1)
+		}
+		n, err := h.r.Read(h.buf[:len(p)-1])
+
+		out := p[:0]
+		for _, b := range h.buf[:n] {
+			switch {
+			case h.prefix:
+				h.prefix = false
+				if action, ok := h.actions[b]; ok {
+					action()
+					continue
+				}
+				if b != hotkeyPrefix {
+					out = append(out, hotkeyPrefix)
+				}
+				out = append(out, b)
+			case b == hotkeyPrefix:
+				h.prefix = true
+			default:
+				out = append(out, b)
+			}
+		}
+		if len(out) > 0 || err != nil {
+			return len(out), err
+		}
+	}
+}
+
+// saveScreenshot saves the shared screen as a PNG in the screenshots folder of
+// the configuration directory, and rings the host's bell when done.
+func saveScreenshot() {
+	dir := filepath.Join(config.CFG.Path, "screenshots")
+	name := filepath.Join(dir, time.Now().Format("compterm-20060102-150405.png"))
+	err := os.MkdirAll(dir, 0o700)
+	if err == nil {
+		var f *os.File
+		f, err = os.Create(filepath.Clean(name))
+		if err == nil {
+			err = srv.Screenshot(f, 20)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	if err != nil {
+		log.Printf("error saving screenshot: %s\n", err)
+		return
+	}
+	log.Printf("screenshot saved to %s\n", name)
+	_, _ = os.Stdout.Write([]byte("\a"))
+}
@@ -1,10 +1,9 @@
 package main
 
 import (
-	"crypto/subtle"
+	"context"
 	"errors"
 	"fmt"
-	"html"
 	"io"
 	"log"
 	"net/http"
@@ -18,26 +17,48 @@ import (
 	"time"
 	"unicode"
 
-	"github.com/crgimenes/compterm/assets"
 	"github.com/crgimenes/compterm/config"
-	"github.com/crgimenes/compterm/screen"
-	"github.com/crgimenes/compterm/session"
+	"github.com/crgimenes/compterm/e2e"
+	"github.com/crgimenes/compterm/relay"
+	"github.com/crgimenes/compterm/server"
 
-	"github.com/coder/websocket"
 	"github.com/creack/pty"
 	"golang.org/x/term"
 )
 
-const cookieName = "compterm"
-
 var (
-	defaultScreen = screen.New(25, 80) // rows, columns
-	ptmx          *os.File
-	GitTag        string           = "0.0.0v"
-	sc            *session.Control = session.New(cookieName)
-	mx            sync.Mutex
+	srv    *server.Server
+	ptmx   *os.File
+	GitTag string = "0.0.0v"
+	mx     sync.Mutex
 )
 
+// serverOptions maps the loaded configuration onto the server's options.
+func serverOptions(cfg *config.Config) server.Options {
+	var key *e2e.Key
+	if cfg.E2EKey != "" {
+		var err error
+		key, err = e2e.ParseKey(cfg.E2EKey)
+		if err != nil {
+			log.Fatalf("error parsing e2e key: %s\n", err)
+		}
+	}
+	return server.Options{
+		Key:            key,
+		Rows:           25,
+		Columns:        80,
+		AuthToken:      cfg.AuthToken,
+		EmbedToken:     cfg.EmbedToken,
+		AllowedOrigins: cfg.OriginPatterns(),
+		ThemeFile:      filepath.Join(cfg.Path, "theme.json"),
+	}
+}
+
+// newServer builds the shared-terminal server from the loaded configuration.
+func newServer(cfg *config.Config) *server.Server {
+	return server.New(serverOptions(cfg))
+}
+
 // ptyEnv builds the environment for the shared command. When a TERM is
 // configured it overrides any inherited TERM and drops COLORTERM, so the shared
 // session presents a consistent terminal type and programs emit colors the
@@ -158,23 +179,17 @@ func runCmd() {
 
 	_ = pty.InheritSize(os.Stdin, ptmx)
 
-	// Copy stdin to the pty, and the pty to both stdout and the broadcast.
-	go func() { _, _ = io.Copy(ptmx, os.Stdin) }()
+	// Copy stdin to the pty, less the host's hotkeys, and the pty to both
+	// stdout (and the recording) and the broadcast.
+	keys := &hotkeys{r: os.Stdin, actions: map[byte]func(){
+		's': func() { go saveScreenshot() },
+	}}
+	go func() { _, _ = io.Copy(ptmx, keys) }()
 
 	go func() {
-		buf := make([]byte, 1024)
-		for {
-			n, err := ptmx.Read(buf)
-			if err != nil {
-				if err == io.EOF {
-					return
-				}
-				log.Fatalf("error reading from pty: %s\r\n", err)
-			}
-			if n > 0 {
-				_, _ = defaultScreen.Write(buf[:n])
-				_, _ = os.Stdout.Write(buf[:n])
-			}
+		err := srv.Attach(io.TeeReader(ptmx, terminalOutput()))
+		if err != nil {
+			log.Fatalf("error reading from pty: %s\r\n", err)
 		}
 	}()
 
@@ -185,192 +200,65 @@ func runCmd() {
 	}
 }
 
-// authorize reports whether a connection is allowed. An empty requiredToken
-// disables authentication entirely.
-func authorize(requiredToken, providedToken string, sessionAuthed bool) bool {
-	if requiredToken == "" {
-		return true
-	}
-	if sessionAuthed {
-		return true
-	}
-	return providedToken != "" &&
-		subtle.ConstantTimeCompare([]byte(providedToken), []byte(requiredToken)) == 1
-}
-
-// tokenFromRequest extracts an access token from the query string or header,
-// supporting shared links and non-browser clients.
-func tokenFromRequest(r *http.Request) string {
-	if t := r.URL.Query().Get("token"); t != "" {
-		return t
-	}
-	return r.Header.Get("X-Auth-Token")
-}
-
-func isAuthorized(r *http.Request, sd *session.SessionData) bool {
-	return authorize(config.CFG.AuthToken, tokenFromRequest(r), sd != nil && sd.Authenticated)
-}
-
-// loginPageFmt is a self-contained login page; %s is an optional error block.
-const loginPageFmt = `<!DOCTYPE html>
-<html lang="en">
-<head>
-<meta charset="utf-8">
-<meta name="viewport" content="width=device-width, initial-scale=1">
-<title>compterm — login</title>
-<style>
-  html,body{height:100%%;margin:0;background:#000;color:#d4d4d4;font-family:monospace}
-  form{position:absolute;top:50%%;left:50%%;transform:translate(-50%%,-50%%);
-    display:flex;flex-direction:column;gap:.75rem;min-width:16rem}
-  h1{margin:0 0 .5rem;font-size:1.25rem;text-align:center}
-  input,button{padding:.6rem;font:inherit;border:1px solid #444;background:#111;
-    color:#d4d4d4;border-radius:4px}
-  button{cursor:pointer;background:#1b3a1b;border-color:#2d5a2d}
-  .error{margin:0;color:#ff6d67;text-align:center}
-</style>
-</head>
-<body>
-<form method="post" action="login">
-<h1>compterm</h1>
-%s<input type="password" name="token" placeholder="Access token" autofocus
-  autocomplete="current-password">
-<button type="submit">Enter</button>
-</form>
-</body>
-</html>
-`
-
-func serveLogin(w http.ResponseWriter, status int, errMsg string) {
-	errBlock := ""
-	if errMsg != "" {
-		errBlock = `<p class="error">` + html.EscapeString(errMsg) + "</p>\n"
-	}
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.WriteHeader(status)
-	_, _ = fmt.Fprintf(w, loginPageFmt, errBlock)
-}
-
-// redirectToBase sends a relative ("./") redirect set manually, so it resolves
-// in the browser against the current URL — working whether compterm is served
-// at the root or under a reverse-proxy subpath (e.g. /compterm/). net/http's
-// Redirect would absolutize it against the proxy-stripped path and send "/".
-func redirectToBase(w http.ResponseWriter) {
-	w.Header().Set("Location", "./")
-	w.WriteHeader(http.StatusSeeOther)
-}
-
-func loginHandler(w http.ResponseWriter, r *http.Request) {
-	// nothing to log into when authentication is disabled
-	if config.CFG.AuthToken == "" {
-		redirectToBase(w)
-		return
-	}
-
-	sid, sd, ok := sc.Get(r)
-	if !ok {
-		sid, sd = sc.Create()
-	}
-
-	if r.Method != http.MethodPost {
-		sc.Save(w, r, sid, sd)
-		serveLogin(w, http.StatusOK, "")
-		return
-	}
-
-	if authorize(config.CFG.AuthToken, r.PostFormValue("token"), false) {
-		sd.Authenticated = true
-		sc.Save(w, r, sid, sd)
-		redirectToBase(w)
-		return
-	}
-
-	sc.Save(w, r, sid, sd)
-	serveLogin(w, http.StatusUnauthorized, "Invalid token.")
-}
-
-func mainHandler(w http.ResponseWriter, r *http.Request) {
-	sid, sd, ok := sc.Get(r)
-	if !ok {
-		sid, sd = sc.Create()
-	}
-
-	// a valid token in the URL (a shared link) authenticates the session
-	if config.CFG.AuthToken != "" && !sd.Authenticated && isAuthorized(r, sd) {
-		sd.Authenticated = true
-	}
-
-	sc.Save(w, r, sid, sd)
-
-	if config.CFG.AuthToken != "" && !sd.Authenticated {
-		serveLogin(w, http.StatusOK, "")
-		return
+func serveHTTP(h http.Handler) {
+	s := &http.Server{
+		Handler:        h,
+		Addr:           config.CFG.Listen,
+		ReadTimeout:    5 * time.Second,
+		WriteTimeout:   5 * time.Second,
+		MaxHeaderBytes: 1 << 20,
 	}
 
-	// Assets are embedded and have no cache validators, so tell the browser to
-	// revalidate — otherwise an old term.min.js lingers after an upgrade.
-	w.Header().Set("Cache-Control", "no-cache")
-	http.FileServer(assets.FS).ServeHTTP(w, r)
+	log.Printf("Listening on %v\n", config.CFG.Listen)
+	log.Fatal(s.ListenAndServe())
 }
 
-func wsHandler(w http.ResponseWriter, r *http.Request) {
-	sid, sd, ok := sc.Get(r)
-	if !ok {
-		sid, sd = sc.Create()
+// runRelay re-serves an upstream session instead of sharing a local command.
+// A relay has no terminal of its own, so it keeps logging to stderr. Unless it
+// is given its own AuthToken, viewers authenticate with the token it uses
+// upstream, so a shared link works the same at the origin and at every relay.
+func runRelay() {
+	cfg := config.CFG
+	if cfg.AuthToken == "" {
+		cfg.AuthToken = cfg.UpstreamToken
 	}
 
-	sc.Save(w, r, sid, sd)
-
-	if !isAuthorized(r, sd) {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
-		return
-	}
+	srv = newServer(cfg)
+	go serveHTTP(srv.Handler())
 
-	c, err := websocket.Accept(w, r, nil)
+	log.Printf("relaying %s\n", cfg.Upstream)
+	err := relay.Run(context.Background(), srv, cfg.Upstream, cfg.UpstreamToken)
 	if err != nil {
-		log.Println(err)
-		return
+		log.Fatalf("error connecting to upstream: %s\n", err)
 	}
-
-	client := screen.NewClient(c)
-	client.SessionID = sid
-	defaultScreen.AttachClient(client)
 }
 
-// themeHandler serves an optional xterm.js theme from <Path>/theme.json so the
-// viewer's palette can match the operator's terminal. Absent file -> defaults.
-func themeHandler(w http.ResponseWriter, _ *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Cache-Control", "no-cache")
+// runHub serves sessions pushed by hosts that cannot accept connections
+// themselves (compterm -push wss://hub/push/<name>), each under /s/<name>/.
+func runHub() {
+	cfg := config.CFG
+	hub := relay.NewHub(relay.HubOptions{
+		PushToken: cfg.PushToken,
+		Server:    serverOptions(cfg),
+	})
+	defer hub.Close()
 
-	path := filepath.Join(config.CFG.Path, "theme.json")
-	data, err := os.ReadFile(filepath.Clean(path)) // #nosec G304 -- operator-controlled config dir
-	if err != nil {
-		_, _ = w.Write([]byte("{}"))
-		return
+	if cfg.PushToken == "" {
+		log.Println("warning: no -push_token; any host may push a session")
 	}
-	_, _ = w.Write(data)
-}
-
-func newMux() *http.ServeMux {
-	mux := http.NewServeMux()
-	mux.HandleFunc("/ws", wsHandler)
-	mux.HandleFunc("/login", loginHandler)
-	mux.HandleFunc("/theme.json", themeHandler)
-	mux.HandleFunc("/", mainHandler)
-	return mux
+	serveHTTP(hub)
 }
 
-func serveHTTP() {
-	s := &http.Server{
-		Handler:        newMux(),
-		Addr:           config.CFG.Listen,
-		ReadTimeout:    5 * time.Second,
-		WriteTimeout:   5 * time.Second,
-		MaxHeaderBytes: 1 << 20,
-	}
-
-	log.Printf("Listening on %v\n", config.CFG.Listen)
-	log.Fatal(s.ListenAndServe())
+// pushSession streams the local session to a hub in the background.
+func pushSession() {
+	cfg := config.CFG
+	go func() {
+		log.Printf("pushing to %s\n", cfg.Push)
+		err := relay.Push(context.Background(), srv, cfg.Push, cfg.PushToken)
+		if err != nil {
+			log.Printf("error connecting to hub: %s\n", err)
+		}
+	}()
 }
 
 func updateTerminalSize() {
@@ -378,14 +266,22 @@ func updateTerminalSize() {
 	_ = pty.InheritSize(os.Stdin, ptmx)
 	mx.Unlock()
 
+	rows, columns := terminalSize()
+	srv.Resize(rows, columns)
+	if recorder != nil {
+		_ = recorder.Resize(rows, columns)
+	}
+}
+
+// terminalSize returns the size of the host's terminal.
+func terminalSize() (rows, columns int) {
 	columns, rows, err := term.GetSize(int(os.Stdin.Fd()))
 	if err != nil || rows <= 0 || columns <= 0 {
 		// No usable window size (e.g. stdin is not a sized tty): fall back to
 		// a sane default instead of dying or broadcasting a 0x0 screen.
-		rows, columns = 24, 80
+		return 24, 80
 	}
-
-	defaultScreen.Resize(rows, columns)
+	return rows, columns
 }
 
 func main() {
@@ -396,6 +292,24 @@ func main() {
 		log.Fatalf("error loading config: %s\n", err)
 	}
 
+	switch config.CFG.Mode {
+	case config.ModeRelay:
+		runRelay()
+		return
+	case config.ModeHub:
+		runHub()
+		return
+	case config.ModeKeygen:
+		fmt.Println(e2e.NewKey())
+		return
+	case config.ModeRender:
+		runRender()
+		return
+	case config.ModeReplay:
+		runReplay()
+		return
+	}
+
 	// refuse to nest inside another compterm session
 	if !config.CFG.IgnorePID {
 		if pid := os.Getenv("COMPTERM"); pid != "" {
@@ -414,6 +328,11 @@ func main() {
 	log.Printf("compterm version %s\n", GitTag)
 	log.Printf("pid: %d\n", os.Getpid())
 
+	srv = newServer(config.CFG)
+	if config.CFG.Record != "" {
+		startRecording()
+	}
+
 	// Handle terminal resize.
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, syscall.SIGWINCH)
@@ -426,16 +345,10 @@ func main() {
 
 	updateTerminalSize()
 
-	// expire idle sessions periodically
-	go func() {
-		ticker := time.NewTicker(10 * time.Minute)
-		defer ticker.Stop()
-		for range ticker.C {
-			sc.RemoveExpired()
-		}
-	}()
-
-	go serveHTTP()
+	go serveHTTP(srv.Handler())
+	if config.CFG.Push != "" {
+		pushSession()
+	}
 
 	runCmd()
 }
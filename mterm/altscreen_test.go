@@ -0,0 +1,78 @@
+package mterm
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestAltScreen(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+		cursor         [2]int
+	}{
+		{"1049", "ab\033[?1049hxy\033[?1049l", "ab", [2]int{0, 2}},
+		{"1049 clears", "ab\033[?1049hxy\033[?1049l\033[?1049h", "", [2]int{0, 2}},
+		{"47 keeps", "ab\033[?47hxy\033[?47l\033[2;1H\033[?47h", "  xy", [2]int{1, 0}},
+		{"47 cursor", "ab\033[?47h\r\nxy\033[?47l", "ab", [2]int{1, 2}},
+		{"1047 clears on exit", "ab\033[?1047hxy\033[?1047l\033[?47h", "", [2]int{0, 4}},
+		{"1048", "ab\033[?1048h\r\nx\033[?1048ly", "aby\nx", [2]int{0, 3}},
+		{"saved per screen", "a\0337\033[?1049h\033[2;2H\0337\033[H\0338x\033[?1049l\0338y", "ay", [2]int{0, 2}},
+	}
+	for _, tt := range tests {
+		term := New(3, 6)
+		_, _ = term.Write([]byte(tt.in))
+		if got := screenText(term); got != tt.want {
+			t.Errorf("%s: screen = %q, want %q", tt.name, got, tt.want)
+		}
+		if r, c := term.CursorPos(); [2]int{r, c} != tt.cursor {
+			t.Errorf("%s: cursor = %d,%d, want %v", tt.name, r, c, tt.cursor)
+		}
+	}
+}
+
+func TestAltScreenSnapshot(t *testing.T) {
+	// a viewer joins while vim runs, then vim exits
+	term := New(3, 6)
+	_, _ = term.Write([]byte("$ ls\r\na b c\r\n$ cat\r\n$ vim\033[?1049h\033[Hfile\033[2;3H\0337\033[3;1H~"))
+
+	mirror := New(3, 6)
+	_, _ = mirror.Write(term.GetScreenAsAnsi())
+	_, _ = mirror.Write(term.CursorAsAnsi())
+
+	for _, step := range []string{"", "\0338!", "\033[?1049l\r\n$ "} {
+		_, _ = term.Write([]byte(step))
+		_, _ = mirror.Write([]byte(step))
+		if got, want := screenText(mirror), screenText(term); got != want {
+			t.Errorf("after %q: mirror = %q, host = %q", step, got, want)
+		}
+		if got, want := mirror.Scrollback(), term.Scrollback(); !slices.EqualFunc(got, want, sameChar) {
+			t.Errorf("after %q: mirror scrollback = %d cells, host %d", step, len(got), len(want))
+		}
+		if got, want := mirror.GetScreenAsAnsi(), term.GetScreenAsAnsi(); string(got) != string(want) {
+			t.Errorf("after %q: mirror snapshot = %q, host %q", step, got, want)
+		}
+	}
+	if got, want := screenText(term), "$ cat\n$ vim\n$"; got != want {
+		t.Errorf("host = %q, want %q", got, want)
+	}
+}
+
+// sameChar tells whether a and b show the same character, blanks never
+// written to being spaces.
+func sameChar(a, b Cell) bool {
+	return max(a.Char, ' ') == max(b.Char, ' ')
+}
+
+func TestAltScreenResize(t *testing.T) {
+	// the window is resized inside vim, then vim exits
+	term := New(4, 10)
+	_, _ = term.Write([]byte("$ vim\033[2;9H\033[1m\033[?1049h\033[0mfile"))
+	term.Resize(3, 6)
+	_, _ = term.Write([]byte("\033[?1049lx"))
+	if r, c := term.CursorPos(); r != 1 || c != 6 {
+		t.Errorf("cursor = %d,%d, want 1,6 (the saved one, clamped, past x)", r, c)
+	}
+	if got := term.Snapshot().Cells[1*6+5]; got.Char != 'x' || got.Flags&FlagBold == 0 {
+		t.Errorf("cell 1,5 = %q, flags %#b; want a bold x", got.Char, got.Flags)
+	}
+}
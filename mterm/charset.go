@@ -0,0 +1,142 @@
+package mterm
+
+// A charset maps the printable ASCII a program sends while it is designated
+// and shifted in, as VT100-era programs drew boxes with the DEC line drawing
+// set (ESC ( 0 ... ESC ( B). Runes it does not list pass through; nil is
+// ASCII.
+type charset map[rune]rune
+
+// charsets94 are the 94-character sets, by the final byte (and intermediate,
+// for Portuguese) that designates them. The national replacement sets are
+// xterm's.
+var charsets94 = map[string]charset{
+	"0": { // DEC Special Graphics
+		'`': '◆', 'a': '▒', 'b': '␉', 'c': '␌', 'd': '␍', 'e': '␊', 'f': '°', 'g': '±',
+		'h': '␤', 'i': '␋', 'j': '┘', 'k': '┐', 'l': '┌', 'm': '└', 'n': '┼', 'o': '⎺',
+		'p': '⎻', 'q': '─', 'r': '⎼', 's': '⎽', 't': '├', 'u': '┤', 'v': '┴', 'w': '┬',
+		'x': '│', 'y': '≤', 'z': '≥', '{': 'π', '|': '≠', '}': '£', '~': '·',
+	},
+	"A": {'#': '£'}, // United Kingdom
+	"B": nil,        // United States (ASCII)
+	"4": { // Dutch
+		'#': '£', '@': '¾', '[': 'ĳ', '\\': '½', ']': '|', '{': '¨', '|': 'f', '}': '¼', '~': '´',
+	},
+	"C": { // Finnish
+		'[': 'Ä', '\\': 'Ö', ']': 'Å', '^': 'Ü', '`': 'é', '{': 'ä', '|': 'ö', '}': 'å', '~': 'ü',
+	},
+	"R": { // French
+		'#': '£', '@': 'à', '[': '°', '\\': 'ç', ']': '§', '{': 'é', '|': 'ù', '}': 'è', '~': '¨',
+	},
+	"Q": { // French Canadian
+		'@': 'à', '[': 'â', '\\': 'ç', ']': 'ê', '^': 'î', '`': 'ô', '{': 'é', '|': 'ù', '}': 'è', '~': 'û',
+	},
+	"K": { // German
+		'@': '§', '[': 'Ä', '\\': 'Ö', ']': 'Ü', '{': 'ä', '|': 'ö', '}': 'ü', '~': 'ß',
+	},
+	"Y": { // Italian
+		'#': '£', '@': '§', '[': '°', '\\': 'ç', ']': 'é', '`': 'ù', '{': 'à', '|': 'ò', '}': 'è', '~': 'ì',
+	},
+	"E": { // Norwegian and Danish
+		'@': 'Ä', '[': 'Æ', '\\': 'Ø', ']': 'Å', '^': 'Ü', '`': 'ä', '{': 'æ', '|': 'ø', '}': 'å', '~': 'ü',
+	},
+	"%6": { // Portuguese
+		'[': 'Ã', '\\': 'Ç', ']': 'Õ', '{': 'ã', '|': 'ç', '}': 'õ',
+	},
+	"Z": { // Spanish
+		'#': '£', '@': '§', '[': '¡', '\\': 'Ñ', ']': '¿', '{': '°', '|': 'ñ', '}': 'ç',
+	},
+	"H": { // Swedish
+		'@': 'É', '[': 'Ä', '\\': 'Ö', ']': 'Å', '^': 'Ü', '`': 'é', '{': 'ä', '|': 'ö', '}': 'å', '~': 'ü',
+	},
+	"=": { // Swiss
+		'#': 'ù', '@': 'à', '[': 'é', '\\': 'ç', ']': 'ê', '^': 'î', '_': 'è', '`': 'ô',
+		'{': 'ä', '|': 'ö', '}': 'ü', '~': 'û',
+	},
+}
+
+// latin1 is the 96-character ISO Latin-1 supplemental set: the upper half of
+// ISO 8859-1 in GL.
+var latin1 = func() charset {
+	cs := charset{}
+	for r := rune(0x20); r < 0x80; r++ {
+		cs[r] = r + 0x80
+	}
+	return cs
+}()
+
+func init() {
+	// alternative final bytes
+	for alt, final := range map[string]string{"5": "C", "f": "R", "9": "Q", "6": "E", "`": "E", "7": "H"} {
+		charsets94[alt] = charsets94[final]
+	}
+}
+
+// designation is a charset designated to one of G0 to G3, with the bytes
+// after ESC that designated it: snapshots send them again.
+type designation struct {
+	seq string
+	cs  charset
+}
+
+// appendCharsets appends what restores the designated and shifted charsets
+// on a terminal fresh from a reset.
+func (t *Terminal) appendCharsets(b []byte) []byte {
+	return appendDesignations(b, t.charsets, t.gl)
+}
+
+// appendDesignations appends what designates charsets to G0 to G3 and shifts
+// gl into GL.
+func appendDesignations(b []byte, charsets [4]designation, gl int) []byte {
+	for _, d := range charsets {
+		if d.seq != "" {
+			b = append(append(b, '\033'), d.seq...)
+		}
+	}
+	switch gl {
+	case 1:
+		b = append(b, 0x0e)
+	case 2:
+		b = append(b, "\033n"...)
+	case 3:
+		b = append(b, "\033o"...)
+	}
+	return b
+}
+
+// designate performs ESC with the intermediates in inter and final when it
+// designates a charset to one of G0 to G3. Unknown sets leave the designation
+// as it was.
+func (t *Terminal) designate(inter []byte, final rune) {
+	var (
+		g  int
+		cs charset
+		ok bool
+	)
+	switch inter[0] {
+	case '(', ')', '*', '+': // 94-character sets to G0, G1, G2, G3
+		g = int(inter[0] - '(')
+		cs, ok = charsets94[string(inter[1:])+string(final)]
+	case '-', '.', '/': // 96-character sets to G1, G2, G3
+		g = int(inter[0]-'-') + 1
+		cs, ok = latin1, len(inter) == 1 && final == 'A'
+	}
+	if ok {
+		t.charsets[g] = designation{seq: string(inter) + string(final), cs: cs}
+	}
+}
+
+// translate maps r through the charset shifted into GL, or the one a single
+// shift selected for this rune alone.
+func (t *Terminal) translate(r rune) rune {
+	g := t.gl
+	if t.single != 0 {
+		g, t.single = t.single, 0
+	}
+	if r < 0x20 || r >= 0x7f {
+		return r
+	}
+	if m, ok := t.charsets[g].cs[r]; ok {
+		return m
+	}
+	return r
+}
@@ -0,0 +1,40 @@
+package mterm
+
+import "testing"
+
+func TestCharsets(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"line drawing", "\033(0lqqk\r\nx  x\r\nmqqj\033(B ok", "┌──┐\n│  │\n└──┘ ok"},
+		{"shift out and in", "\033)0a\x0eqx\x0fq", "a─│q"},
+		{"single shift", "\033*0\033/Aa\033Nqq\033O!", "a─q¡"},
+		{"locking shift", "\033+0\033oqq\x0fq", "──q"},
+		{"96-character set", "\033-A\x0e!1\x0f!", "¡±!"},
+		{"national", "\033(K[\\]{|}~\033(%6[\\]", "ÄÖÜäöüßÃÇÕ"},
+		{"unknown set kept", "\033(0\033(Wq", "─"},
+		{"only printable ASCII", "\033(0é\033[1mq", "é─"},
+	}
+	for _, tt := range tests {
+		term := New(4, 12)
+		_, _ = term.Write([]byte(tt.in))
+		if got := screenText(term); got != tt.want {
+			t.Errorf("%s: screen = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCharsetsSnapshot(t *testing.T) {
+	term := New(2, 8)
+	_, _ = term.Write([]byte("\033)0\033(Kq\x0eq"))
+
+	// a viewer joining now draws lines with what follows, as the host does
+	mirror := New(2, 8)
+	_, _ = mirror.Write(term.GetScreenAsAnsi())
+	_, _ = mirror.Write(term.CursorAsAnsi())
+	_, _ = mirror.Write([]byte("\r\n\x0fq{\x0eq"))
+	_, _ = term.Write([]byte("\r\n\x0fq{\x0eq"))
+	if got, want := screenText(mirror), screenText(term); got != want || want != "q─\nqä─" {
+		t.Errorf("mirror = %q, host = %q, want %q", got, want, "q─\nqä─")
+	}
+}
@@ -39,3 +39,61 @@ func TestSnapshotColorEncoding(t *testing.T) {
 		})
 	}
 }
+
+func TestSGRExtended(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		flags uint8
+		extra uint8
+	}{
+		{"curly is not italic", "\033[4:3m", FlagUnderline, UnderlineCurly},
+		{"4:1 single", "\033[4:3;4:1m", FlagUnderline, 0},
+		{"4:0 off", "\033[4:5;4:0m", 0, 0},
+		{"21 double", "\033[21m", FlagUnderline, UnderlineDouble},
+		{"24 off", "\033[4:4;24m", 0, 0},
+		{"overline", "\033[53m", 0, ExtraOverline},
+		{"55 no overline", "\033[53;55m", 0, 0},
+		{"rapid blink", "\033[6m", 0, ExtraRapidBlink},
+		{"25 both blinks", "\033[5;6;25m", 0, 0},
+		{"0 resets", "\033[4:2;53;6;0m", 0, 0},
+	}
+	for _, tt := range tests {
+		term := New(1, 3)
+		_, _ = term.Write([]byte(tt.in + "x"))
+		c := term.Snapshot().Cells[0]
+		if c.Flags != tt.flags || c.Extra != tt.extra {
+			t.Errorf("%s: flags, extra = %#b, %#b, want %#b, %#b", tt.name, c.Flags, c.Extra, tt.flags, tt.extra)
+		}
+	}
+}
+
+func TestSGRColorForms(t *testing.T) {
+	want := SGRState{}
+	want.Set([]int{38, 2, 1, 2, 3}, []int{48, 5, 9}, []int{58, 2, 0, 4, 5, 6})
+	for _, in := range []string{
+		"\033[38;2;1;2;3;48;5;9;58;2;4;5;6m",
+		"\033[38:2:1:2:3;48:5:9;58:2::4:5:6m",
+		"\033[38:2:0:1:2:3;48;5;9;58:2:0:4:5:6m",
+	} {
+		term := New(1, 3)
+		_, _ = term.Write([]byte(in + "x"))
+		if got := term.Snapshot().Cells[0].SGRState; got != want {
+			t.Errorf("%q: state = %+v, want %+v", in, got, want)
+		}
+	}
+}
+
+func TestSGRExtendedSnapshot(t *testing.T) {
+	term := New(2, 8)
+	_, _ = term.Write([]byte("\033[4:3;58;5;1ma\033[21mb\033[24;53mc\033[6md\033[0;4me"))
+
+	mirror := New(2, 8)
+	_, _ = mirror.Write(term.GetScreenAsAnsi())
+	got, want := mirror.Snapshot().Cells, term.Snapshot().Cells
+	for i := range 5 {
+		if got[i].Char != want[i].Char || got[i].SGRState != want[i].SGRState {
+			t.Errorf("cell %d: mirror = %+v, host = %+v", i, got[i], want[i])
+		}
+	}
+}
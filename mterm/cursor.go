@@ -0,0 +1,144 @@
+package mterm
+
+// savedCursor is what DECSC saves and DECRC restores: the cursor position,
+// the graphic rendition, the character sets, and origin mode.
+type savedCursor struct {
+	pos      [2]int
+	sgr      SGRState
+	charsets [4]designation
+	gl       int
+	origin   bool
+}
+
+// isZero reports whether sc is the state of a terminal fresh from a reset,
+// which is what DECRC restores when nothing was saved.
+func (sc savedCursor) isZero() bool {
+	for _, d := range sc.charsets {
+		if d.seq != "" {
+			return false
+		}
+	}
+	return sc.pos == [2]int{} && sc.sgr == SGRState{} && sc.gl == 0 && !sc.origin
+}
+
+// saveCursor performs DECSC, for the screen in use.
+func (t *Terminal) saveCursor() {
+	t.saved[t.screenTarget] = savedCursor{
+		pos:      t.screens[t.screenTarget].cursor,
+		sgr:      t.cstate,
+		charsets: t.charsets,
+		gl:       t.gl,
+		origin:   t.modes&modeOrigin != 0,
+	}
+}
+
+// restoreCursor performs DECRC, for the screen in use. With nothing saved it
+// homes the cursor and resets what DECSC would have saved.
+func (t *Terminal) restoreCursor() {
+	sc := t.saved[t.screenTarget]
+	s := t.screens[t.screenTarget]
+	s.cursor = [2]int{
+		clamp(sc.pos[0], 0, s.size[0]-1),
+		clamp(sc.pos[1], 0, s.size[1]),
+	}
+	t.cstate = sc.sgr
+	t.charsets, t.gl, t.single = sc.charsets, sc.gl, 0
+	t.setMode(modeOrigin, sc.origin)
+}
+
+// appendSaved appends to b the sequences that set up sc on a terminal at the
+// default state, then save it, and go back to that state. The cursor moves.
+func appendSaved(b []byte, sc savedCursor, save string) []byte {
+	b = sc.sgr.AppendANSI(b)
+	b = appendDesignations(b, sc.charsets, sc.gl)
+	if sc.origin {
+		b = append(b, "\033[?6h"...)
+	}
+	b = appendCursorPos(b, sc.pos)
+	b = append(b, save...)
+	b = append(b, "\033[0m"...)
+	if sc.origin {
+		b = append(b, "\033[?6l"...)
+	}
+	for g, d := range sc.charsets {
+		if d.seq != "" {
+			b = append(b, "\033"...)
+			b = append(b, "()*+"[g], 'B')
+		}
+	}
+	if sc.gl != 0 {
+		b = append(b, 0x0f)
+	}
+	return b
+}
+
+// tabStop reports whether col has a tab stop: the ones HTS set, or else one
+// every TabSize columns.
+func (t *Terminal) tabStop(col int) bool {
+	if t.tabs == nil {
+		return col > 0 && col%t.TabSize == 0
+	}
+	return col < len(t.tabs) && t.tabs[col]
+}
+
+// setTabStop sets the tab stop at col, or clears it: HTS, or TBC 0.
+func (t *Terminal) setTabStop(col int, set bool) {
+	cols := t.screens[t.screenTarget].size[1]
+	if col < 0 || col >= cols {
+		return
+	}
+	if t.tabs == nil {
+		t.tabs = make([]bool, cols)
+		for i := range t.tabs {
+			t.tabs[i] = i > 0 && i%t.TabSize == 0
+		}
+	}
+	t.tabs[col] = set
+}
+
+// resizeTabs keeps the tab stops set for the columns that remain; new
+// columns get the default ones.
+func (t *Terminal) resizeTabs(cols int) {
+	if t.tabs == nil {
+		return
+	}
+	tabs := make([]bool, cols)
+	n := copy(tabs, t.tabs)
+	for i := n; i < cols; i++ {
+		tabs[i] = i > 0 && i%t.TabSize == 0
+	}
+	t.tabs = tabs
+}
+
+// tab moves the cursor n tab stops forward, or back for a negative n,
+// stopping at the edges of the line.
+func (t *Terminal) tab(n int) {
+	s := t.screens[t.screenTarget]
+	cols := s.size[1]
+	col := min(s.cursor[1], cols-1)
+	for ; n > 0 && col < cols-1; n-- {
+		for col++; col < cols-1 && !t.tabStop(col); col++ {
+		}
+	}
+	for ; n < 0 && col > 0; n++ {
+		for col--; col > 0 && !t.tabStop(col); col-- {
+		}
+	}
+	s.cursor[1] = col
+}
+
+// appendTabs appends to b the sequences that set the tab stops of t on a
+// terminal with the default ones. The cursor moves.
+func (t *Terminal) appendTabs(b []byte) []byte {
+	if t.tabs == nil {
+		return b
+	}
+	b = append(b, "\033[3g"...)
+	for col, set := range t.tabs {
+		if set {
+			b = appendCursorPos(b, [2]int{0, col})
+			b = append(b, "\033H"...)
+		}
+	}
+	return b
+}
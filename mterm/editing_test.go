@@ -0,0 +1,123 @@
+package mterm
+
+import (
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestEditing(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+		cursor         [2]int
+	}{
+		{"ICH", "abcdef\r\033[2@", "  abcdef", [2]int{0, 0}},
+		{"ICH pushes out", "abcdefghijklmnopqrst\033[18G\033[5@", "abcdefghijklmnopq", [2]int{0, 17}},
+		{"ICH splits wide", "a世b\033[2D\033[@", "a   b", [2]int{0, 2}},
+		{"REP", "x\033[3b", "xxxx", [2]int{0, 4}},
+		{"REP translated", "\033(0q\033(B\033[2bq", "───q", [2]int{0, 4}},
+		{"REP nothing printed", "\033[3b", "", [2]int{0, 0}},
+		{"ECH at line end", strings.Repeat("a", 20) + "\r\nbc\033[1;18H\033[9X", strings.Repeat("a", 17) + "\nbc", [2]int{0, 17}},
+		{"CHT CBT", "a\033[2Ix\033[2Zy", "a       y       x", [2]int{0, 9}},
+		{"HTS TBC all", "\033[3g\033[5G\033H\r\tx\033[3g\r\ty", "    x              y", [2]int{0, 20}},
+		{"TBC", "\033[9G\033[g\r\tx", "                x", [2]int{0, 17}},
+		{"RI scrolls", "a\r\nb\033[H\033Mc", "c\na\nb", [2]int{0, 1}},
+		{"RI scrolls region", "a\r\nb\r\nc\033[2;3r\033[2H\033Mx", "a\nx\nb", [2]int{1, 1}},
+		{"RI above region", "a\033[2;3r\033[H\033Mx", "x", [2]int{0, 1}},
+		{"NEL", "a\r\nb\r\nc\r\nd\033Ee", "b\nc\nd\ne", [2]int{3, 1}},
+		{"DECSC DECRC", "\033[1;31m\033(0\033[2;3H\0337\033[m\033(B\033[Hq\0338q", "q\n  ─", [2]int{1, 3}},
+		{"DECRC unsaved", "\033(0\033[3;5H\0338q", "q", [2]int{0, 1}},
+		{"CSI s u", "\033(0\033[2;2H\033[s\033(B\033[Hq\033[uq", "q\n ─", [2]int{1, 2}},
+		{"DECSTR", "\033[?7l\033[4h\033(0\033[2;3r\033[?6h\033[!pq\033[4Hq", "\nq\n\nq", [2]int{3, 1}},
+		{"RIS", "ab\r\n\033[?1049h\033[?7l\033(0xy\033cq", "q", [2]int{0, 1}},
+	}
+	for _, tt := range tests {
+		term := New(4, 20)
+		_, _ = term.Write([]byte(tt.in))
+		if got := screenText(term); got != tt.want {
+			t.Errorf("%s: screen = %q, want %q", tt.name, got, tt.want)
+		}
+		if r, c := term.CursorPos(); [2]int{r, c} != tt.cursor {
+			t.Errorf("%s: cursor = %d,%d, want %v", tt.name, r, c, tt.cursor)
+		}
+	}
+
+	// DECRC brings back the rendition along with the position
+	term := New(4, 20)
+	_, _ = term.Write([]byte("\033[1;31m\0337\033[m\033[2Ha\0338b"))
+	if c := term.Snapshot().Cells[0]; c.Char != 'b' || c.Flags&FlagBold == 0 || c.FG[0] != 31 {
+		t.Errorf("restored cell = %+v, want a bold red b", c)
+	}
+
+	// RIS leaves no scrollback and default modes behind
+	term = New(2, 20)
+	_, _ = term.Write([]byte("a\r\nb\r\nc\033[?25l\033[3g\033c"))
+	if sb := term.Scrollback(); len(sb) != 0 {
+		t.Errorf("scrollback after RIS = %d cells", len(sb))
+	}
+	if got := term.GetScreenAsAnsi(); strings.ContainsAny(string(got), "\033") {
+		t.Errorf("snapshot after RIS = %q, want no state to restore", got)
+	}
+}
+
+var sgrSeq = regexp.MustCompile("\033\\[[0-9;]*m")
+
+func TestEditingFixture(t *testing.T) {
+	art, err := os.ReadFile("../fixtures/debian.ansi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimRight(sgrSeq.ReplaceAllString(string(art), ""), "\r\n"), "\r\n")
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], " ")
+	}
+
+	// the logo scrolls a few lines off the top
+	term := New(24, 47)
+	_, _ = term.Write(art)
+	if got, want := screenText(term), strings.TrimRight(strings.Join(lines[len(lines)-23:], "\n"), "\n"); got != want {
+		t.Fatalf("screen = %q, want %q", got, want)
+	}
+
+	// a viewer joins, then both get edits all over the logo
+	mirror := New(24, 47)
+	_, _ = mirror.Write(term.GetScreenAsAnsi())
+	_, _ = mirror.Write(term.CursorAsAnsi())
+
+	steps := []string{
+		"\033[3;10H\0337\033[1;31m\033[4@\033(0q\033[3b\033[40X",
+		"\033[H\033M\033M\0338\033[2I*\033[Z+\033[!p",
+		"\033[5;20H\033H\033[10;1H\033[6@\t#\033[12;12r\033[12H\033M\033[r",
+		"\033[24H\033E\033D\033[m\033[3g\tend",
+	}
+	for _, step := range steps {
+		_, _ = term.Write([]byte(step))
+		_, _ = mirror.Write([]byte(step))
+		if got, want := screenText(mirror), screenText(term); got != want {
+			t.Errorf("after %q: mirror = %q, host = %q", step, got, want)
+		}
+		if got, want := mirror.Snapshot().Cells, term.Snapshot().Cells; !slices.EqualFunc(got, want, func(a, b Cell) bool {
+			return sameChar(a, b) && a.SGRState == b.SGRState
+		}) {
+			t.Errorf("after %q: mirror and host differ in color", step)
+		}
+		if got, want := mirror.Scrollback(), term.Scrollback(); !slices.EqualFunc(got, want, sameChar) {
+			t.Errorf("after %q: mirror scrollback = %d cells, host %d", step, len(got), len(want))
+		}
+	}
+
+	// the line edited first, after two RIs and three scrolls; "end" wraps
+	// from the last column, with no tab stops left
+	got := strings.Split(screenText(term), "\n")
+	for r, want := range map[int]string{
+		1:  "  ▀▀▀▀▀  ────",
+		22: strings.Repeat(" ", 46) + "e",
+		23: "nd",
+	} {
+		if got[r] != want {
+			t.Errorf("row %d = %q, want %q", r, got[r], want)
+		}
+	}
+}
@@ -2,11 +2,97 @@ package mterm
 
 // Cell is a single cell in the terminal
 type Cell struct {
-	Char rune
-	nl   bool // new: 2023-12-13 is new line
+	Char  rune
+	marks string // zero width runes after Char: combining marks, joiners
+	nl    bool   // new: 2023-12-13 is new line
+	wide  cellWidth
+	link  *Hyperlink // the OSC 8 link open when it was printed
 	SGRState
 }
 
+// maxMarks bounds the zero width runes a cell keeps, against stacks of
+// combining marks.
+const maxMarks = 32
+
+// Grapheme returns the cell's character with the zero width runes that
+// follow it: combining accents, zero width joiners, variation selectors. It is
+// empty for a cell never written to.
+func (c Cell) Grapheme() string {
+	if c.Char == 0 {
+		return ""
+	}
+	return string(c.Char) + c.marks
+}
+
+// Link returns the hyperlink over the cell; its URI is empty when there is
+// none.
+func (c Cell) Link() Hyperlink {
+	if c.link == nil {
+		return Hyperlink{}
+	}
+	return *c.link
+}
+
+// cellWidth tells the two cells of a wide character apart from the others.
+type cellWidth uint8
+
+const (
+	narrow cellWidth = iota
+	wideHead
+	wideTail // the continuation placeholder: its Char is 0
+)
+
+// Width returns how many columns the cell's character spans: 2 for a wide
+// character, 0 for the placeholder in the column it extends over, 1 for the
+// rest.
+func (c Cell) Width() int {
+	switch c.wide {
+	case wideHead:
+		return 2
+	case wideTail:
+		return 0
+	}
+	return 1
+}
+
+// repairWide blanks the halves of wide characters that lost the other half
+// to an edit of line.
+func repairWide(line []Cell) {
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i].wide == wideHead && i+1 < len(line) && line[i+1].wide == wideTail:
+			i++
+		case line[i].wide != narrow:
+			line[i].Char, line[i].marks, line[i].wide = 0, "", narrow
+		}
+	}
+}
+
+// padWide returns line laid out for rows of cols: a wide character that
+// would straddle two rows is pushed to the next one after a blank.
+func padWide(line []Cell, cols int) []Cell {
+	var out []Cell
+	for i, c := range line {
+		pos := i
+		if out != nil {
+			pos = len(out)
+		}
+		if c.wide == wideHead && cols > 1 && pos%cols == cols-1 {
+			if out == nil {
+				out = append(make([]Cell, 0, len(line)+len(line)/cols+1), line[:i]...)
+			}
+			out = append(out, Cell{})
+		}
+		if out != nil {
+			out = append(out, c)
+		}
+	}
+	if out == nil {
+		return line
+	}
+	return out
+}
+
 type Grid struct {
 	cells       []Cell
 	backlogSize int
@@ -18,6 +104,60 @@ func (g *Grid) Size() (rows, cols int) {
 	return g.size[0], g.size[1]
 }
 
+// view returns the visible rows, below the scrollback.
+func (g *Grid) view() []Cell {
+	return g.cells[max(len(g.cells)-g.size[0]*g.size[1], 0):]
+}
+
+// scrollUp moves rows top to bottom-1 of the screen up by n, blanking the
+// rows it uncovers at the bottom with blank. If feed is set and the rows are
+// the whole screen, the ones scrolled off go to the scrollback instead of
+// being lost, as long as the grid keeps any.
+func (g *Grid) scrollUp(top, bottom, n int, blank Cell, feed bool) {
+	rows, cols := g.size[0], g.size[1]
+	n = min(n, bottom-top)
+	if n <= 0 {
+		return
+	}
+	if feed && top == 0 && bottom == rows && g.backlogSize > rows {
+		for range n * cols {
+			g.cells = append(g.cells, blank)
+		}
+		g.trimBacklog()
+		return
+	}
+	region := g.view()[top*cols : bottom*cols]
+	copy(region, region[n*cols:])
+	fill(region[len(region)-n*cols:], blank)
+}
+
+// scrollDown moves rows top to bottom-1 of the screen down by n, blanking the
+// rows it uncovers at the top with blank.
+func (g *Grid) scrollDown(top, bottom, n int, blank Cell) {
+	cols := g.size[1]
+	n = min(n, bottom-top)
+	if n <= 0 {
+		return
+	}
+	region := g.view()[top*cols : bottom*cols]
+	copy(region[n*cols:], region)
+	fill(region[:n*cols], blank)
+}
+
+// trimBacklog drops the oldest rows past backlogSize. It lets a few more
+// pile up first, so that a flood of output does not move the whole backlog
+// on every line.
+func (g *Grid) trimBacklog() {
+	cols := g.size[1]
+	over := len(g.cells)/cols - g.backlogSize
+	if over <= g.backlogSize/16 {
+		return
+	}
+	n := copy(g.cells, g.cells[over*cols:])
+	clear(g.cells[n:])
+	g.cells = g.cells[:n]
+}
+
 // Resize regular resize without reflow, it will chomp any extra lines/columns
 func (g *Grid) Resize(rows, cols int) {
 	maxRows, maxCols := g.size[0], g.size[1]
@@ -31,6 +171,7 @@ func (g *Grid) Resize(rows, cols int) {
 		targetLine := newCells[tOff : tOff+cols]
 		sourceLine := g.cells[sOff : sOff+(maxCols-1)]
 		copy(targetLine, sourceLine)
+		repairWide(targetLine)
 	}
 	g.size = [2]int{rows, cols}
 	g.cursor = [2]int{
@@ -53,6 +194,7 @@ func (g *Grid) ResizeAndReflow(rows, cols int) {
 	shrink := 0
 	addLine := func(line []Cell) {
 		orows := 1 + (len(line)-1)/maxCols
+		line = padWide(line, cols)
 		nrows := 1 + (len(line)-1)/cols
 		rowsDiff := nrows - orows
 
@@ -81,13 +223,17 @@ func (g *Grid) ResizeAndReflow(rows, cols int) {
 			i++
 			continue
 		}
+		if c.wide == wideHead && i+1 < len(g.cells) && g.cells[i+1].wide == wideTail {
+			// a mark left on the first cell of a wide character
+			i++
+		}
 		// add logical text line
 		addLine(g.cells[start : i+1])
 		// next line
 		start = i + maxCols - (i % maxCols)
 		i = start
 	}
-	copy(newCells[ni:], g.cells[start:])
+	copy(newCells[ni:], padWide(g.cells[start:], cols))
 
 	cursorLine := g.cursor[0]
 	// 'scroll' up and move cursor up if needed
@@ -114,6 +260,9 @@ func (g *Grid) ResizeAndReflow(rows, cols int) {
 		c := min(backRows, grown)
 		cursorLine += c
 	}
+	for r := 0; r+cols <= len(newCells); r += cols {
+		repairWide(newCells[r : r+cols])
+	}
 	g.cells = newCells
 	g.size = [2]int{rows, cols}
 	g.cursor = [2]int{
@@ -0,0 +1,56 @@
+package mterm
+
+import "bytes"
+
+// Hyperlink is the link OSC 8 opened over the cells printed until it is
+// closed: its URI, and the id that joins cells of one link split by other
+// text, if any.
+type Hyperlink struct {
+	ID, URI string
+}
+
+// maxLinks bounds the link table. When it is full it starts over; cells keep
+// the links they point to.
+const maxLinks = 1024
+
+// setLink performs OSC 8: parameters separated by colons, then the URI after a
+// semicolon. An empty URI closes the open link.
+func (t *Terminal) setLink(data []byte) {
+	params, uri, _ := bytes.Cut(data, []byte(";"))
+	if len(uri) == 0 {
+		t.link = nil
+		return
+	}
+	l := Hyperlink{URI: string(uri)}
+	for _, p := range bytes.Split(params, []byte(":")) {
+		if id, ok := bytes.CutPrefix(p, []byte("id=")); ok {
+			l.ID = string(id)
+		}
+	}
+	// cells of the same link share it, so appendCells tells runs apart by
+	// pointer
+	if t.links[l] == nil {
+		if t.links == nil || len(t.links) >= maxLinks {
+			t.links = make(map[Hyperlink]*Hyperlink)
+		}
+		t.links[l] = &l
+	}
+	t.link = t.links[l]
+}
+
+// appendLink appends the OSC 8 that opens l, or that closes the open link
+// when l is nil.
+func appendLink(b []byte, l *Hyperlink) []byte {
+	b = append(b, "\033]8;"...)
+	if l != nil {
+		if l.ID != "" {
+			b = append(b, "id="...)
+			b = append(b, l.ID...)
+		}
+		b = append(b, ';')
+		b = append(b, l.URI...)
+	} else {
+		b = append(b, ';')
+	}
+	return append(b, "\033\\"...)
+}
@@ -0,0 +1,49 @@
+package mterm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHyperlink(t *testing.T) {
+	term := New(2, 10)
+	_, _ = term.Write([]byte("a\033]8;;http://x\033\\bc\033]8;;\033\\d\033]8;id=1:k=v;http://y;z\ae\033]0;title\a"))
+
+	want := []Hyperlink{{}, {URI: "http://x"}, {URI: "http://x"}, {}, {ID: "1", URI: "http://y;z"}, {}}
+	for i, w := range want {
+		if got := term.Snapshot().Cells[i].Link(); got != w {
+			t.Errorf("cell %d: link = %+v, want %+v", i, got, w)
+		}
+	}
+	if term.Title != "title" {
+		t.Errorf("title = %q, want %q", term.Title, "title")
+	}
+}
+
+func TestHyperlinkSnapshot(t *testing.T) {
+	// a viewer joins while a link is open
+	term := New(2, 10)
+	_, _ = term.Write([]byte("\033]8;;http://x\033\\ab\033]8;;\033\\ c\033]8;id=2;file:///tmp\033\\d"))
+
+	out := string(term.GetScreenAsAnsi())
+	for _, want := range []string{"\033]8;;http://x\033\\ab\033]8;;\033\\", "\033]8;id=2;file:///tmp\033\\d"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("snapshot = %q, want to contain %q", out, want)
+		}
+	}
+
+	mirror := New(2, 10)
+	_, _ = mirror.Write([]byte(out))
+	_, _ = mirror.Write(term.CursorAsAnsi())
+	_, _ = term.Write([]byte("e"))
+	_, _ = mirror.Write([]byte("e"))
+	got, want := mirror.Snapshot().Cells, term.Snapshot().Cells
+	for i := range want {
+		if got[i].Link() != want[i].Link() {
+			t.Errorf("cell %d: mirror link = %+v, host %+v", i, got[i].Link(), want[i].Link())
+		}
+	}
+	if got[0].link != got[1].link {
+		t.Error("cells of one link do not share it")
+	}
+}
@@ -0,0 +1,129 @@
+package mterm
+
+import "strconv"
+
+// mode is a set of terminal modes, one bit each.
+type mode uint32
+
+const (
+	modeInsert       mode = 1 << iota // IRM: printing shifts the line right
+	modeAppCursor                     // DECCKM: cursor keys send SS3 sequences
+	modeOrigin                        // DECOM: rows count from the scroll region
+	modeAutowrap                      // DECAWM: printing past the margin wraps
+	modeCursorShown                   // DECTCEM
+	modeFocus                         // focus in and out reports
+	modeBracketPaste                  // pastes are bracketed
+
+	// mouse tracking, at most one at a time
+	modeMouseX10    // press only
+	modeMouseVT200  // press and release
+	modeMouseButton // and motion while a button is down
+	modeMouseAny    // and all motion
+
+	// mouse report encodings, at most one at a time
+	modeMouseUTF8
+	modeMouseSGR
+	modeMouseURXVT
+	modeMouseSGRPixels
+)
+
+// defaultModes are the modes a terminal starts with.
+const defaultModes = modeAutowrap | modeCursorShown
+
+const (
+	mouseTracking = modeMouseX10 | modeMouseVT200 | modeMouseButton | modeMouseAny
+	mouseEncoding = modeMouseUTF8 | modeMouseSGR | modeMouseURXVT | modeMouseSGRPixels
+)
+
+// privateModes maps the DEC private mode numbers set with CSI ? Pm h to the
+// modes they stand for.
+var privateModes = map[int]mode{
+	1:    modeAppCursor,
+	6:    modeOrigin,
+	7:    modeAutowrap,
+	9:    modeMouseX10,
+	25:   modeCursorShown,
+	1000: modeMouseVT200,
+	1002: modeMouseButton,
+	1003: modeMouseAny,
+	1004: modeFocus,
+	1005: modeMouseUTF8,
+	1006: modeMouseSGR,
+	1015: modeMouseURXVT,
+	1016: modeMouseSGRPixels,
+	2004: modeBracketPaste,
+}
+
+// privateOrder is the order appendModes restores private modes in: the
+// mouse encoding after the tracking it applies to.
+var privateOrder = []int{1, 7, 25, 1004, 2004, 9, 1000, 1002, 1003, 1005, 1006, 1015, 1016, 6}
+
+// setMode sets or resets m. Mouse tracking modes replace one another, and so
+// do mouse encodings.
+func (t *Terminal) setMode(m mode, set bool) {
+	if !set {
+		t.modes &^= m
+		return
+	}
+	switch {
+	case m&mouseTracking != 0:
+		t.modes &^= mouseTracking
+	case m&mouseEncoding != 0:
+		t.modes &^= mouseEncoding
+	}
+	t.modes |= m
+}
+
+// setPrivateMode sets or resets the DEC private mode n, if it is one tracked.
+func (t *Terminal) setPrivateMode(n int, set bool) {
+	m, ok := privateModes[n]
+	if !ok {
+		return
+	}
+	t.setMode(m, set)
+	if m == modeOrigin {
+		// DECOM homes the cursor, to the top of the region when set
+		s := t.screens[t.screenTarget]
+		s.cursor = [2]int{t.originRow(), 0}
+	}
+}
+
+// originRow returns the row cursor positions count from: the top of the
+// scroll region in origin mode, the top of the screen otherwise.
+func (t *Terminal) originRow() int {
+	if t.modes&modeOrigin != 0 {
+		return t.scrollRegion[0]
+	}
+	return 0
+}
+
+// row returns the screen row for row n, from 1, of a cursor position. In
+// origin mode it counts from the top of the scroll region and stays in it.
+func (t *Terminal) row(n int) int {
+	if t.modes&modeOrigin != 0 {
+		return clamp(t.scrollRegion[0]+n-1, t.scrollRegion[0], t.scrollRegion[1]-1)
+	}
+	return clamp(n-1, 0, t.screens[t.screenTarget].size[0]-1)
+}
+
+// appendModes appends to b the sequences that bring a terminal in the
+// default modes to the modes of t.
+func (t *Terminal) appendModes(b []byte) []byte {
+	if t.modes&modeInsert != 0 {
+		b = append(b, "\033[4h"...)
+	}
+	for _, n := range privateOrder {
+		m := privateModes[n]
+		if t.modes&m == defaultModes&m {
+			continue
+		}
+		b = append(b, "\033[?"...)
+		b = strconv.AppendInt(b, int64(n), 10)
+		if t.modes&m != 0 {
+			b = append(b, 'h')
+		} else {
+			b = append(b, 'l')
+		}
+	}
+	return b
+}
@@ -0,0 +1,73 @@
+package mterm
+
+import "testing"
+
+func TestModes(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+		cursor         [2]int
+	}{
+		{"autowrap", "abcdefg", "abcdef\ng", [2]int{1, 1}},
+		{"no autowrap", "\033[?7labcdefgh", "abcdeh", [2]int{0, 5}},
+		{"no autowrap wide", "\033[?7labcde世", "abcd世", [2]int{0, 5}},
+		{"insert", "abcd\r\033[4hxy\033[4lz", "xyzbcd", [2]int{0, 3}},
+		{"insert pushes out", "abcdef\r\033[4hx", "xabcde", [2]int{0, 1}},
+		{"insert splits wide", "ab世cd\r\033[4hxyz", "xyzab", [2]int{0, 3}},
+		{"origin", "\033[2;3r\033[?6h\033[Hx\033[9;2Hy", "\nx\n y", [2]int{2, 2}},
+		{"origin reset homes", "\033[2;3r\033[?6h\033[2Hx\033[?6lz", "z\n\nx", [2]int{0, 1}},
+	}
+	for _, tt := range tests {
+		term := New(4, 6)
+		_, _ = term.Write([]byte(tt.in))
+		if got := screenText(term); got != tt.want {
+			t.Errorf("%s: screen = %q, want %q", tt.name, got, tt.want)
+		}
+		if r, c := term.CursorPos(); [2]int{r, c} != tt.cursor {
+			t.Errorf("%s: cursor = %d,%d, want %v", tt.name, r, c, tt.cursor)
+		}
+	}
+}
+
+func TestModesSnapshot(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"defaults", "\033[?25h\033[?7h", ""},
+		{"vim", "\033[?1h\033[?25l\033[?2004h\033[?1004h", "\033[?1h\033[?25l\033[?1004h\033[?2004h"},
+		{"mouse replaces", "\033[?1000h\033[?1002h\033[?1005h\033[?1006h", "\033[?1002h\033[?1006h"},
+		{"mouse reset", "\033[?1000h\033[?1000l", ""},
+		{"insert no wrap", "\033[4h\033[?7l", "\033[4h\033[?7l"},
+		{"unknown", "\033[?12345h\033[20h", ""},
+	}
+	for _, tt := range tests {
+		term := New(2, 4)
+		_, _ = term.Write([]byte(tt.in))
+		if got := string(term.appendModes(nil)); got != tt.want {
+			t.Errorf("%s: modes = %q, want %q", tt.name, got, tt.want)
+		}
+		mirror := New(2, 4)
+		_, _ = mirror.Write(term.GetScreenAsAnsi())
+		if mirror.modes != term.modes {
+			t.Errorf("%s: mirror modes = %b, want %b", tt.name, mirror.modes, term.modes)
+		}
+	}
+
+	// a viewer joining a full-screen program sees its hidden cursor
+	term := New(2, 4)
+	_, _ = term.Write([]byte("\033[?25lab"))
+	if !term.Snapshot().CursorHidden {
+		t.Error("Snapshot shows the cursor the application hid")
+	}
+
+	// and output past the margin does not wrap for them either
+	term = New(2, 4)
+	_, _ = term.Write([]byte("\033[?7lab"))
+	mirror := New(2, 4)
+	_, _ = mirror.Write(term.GetScreenAsAnsi())
+	_, _ = mirror.Write(term.CursorAsAnsi())
+	_, _ = mirror.Write([]byte("cdef"))
+	_, _ = term.Write([]byte("cdef"))
+	if got, want := screenText(mirror), screenText(term); got != want || want != "abcf" {
+		t.Errorf("mirror = %q, host = %q, want %q", got, want, "abcf")
+	}
+}
@@ -4,14 +4,10 @@ import (
 	"bytes"
 	"fmt"
 	"slices"
-	"strings"
 	"sync"
-	"unicode"
 	"unicode/utf8"
 )
 
-type stateFn func(t *Terminal, r rune) (stateFn, error)
-
 // Terminal is an in memory terminal emulator
 type Terminal struct {
 	mux          sync.Mutex
@@ -19,14 +15,33 @@ type Terminal struct {
 	screenTarget int
 
 	cstate SGRState
+	modes  mode
+
+	// the OSC 8 link printed characters get, and the links cells share
+	link  *Hyperlink
+	links map[Hyperlink]*Hyperlink
+
+	// character sets designated to G0 to G3, the one shifted into GL, and
+	// the one a single shift selected for the next character (2, 3, or 0)
+	charsets [4]designation
+	gl       int
+	single   int
 
-	stateProc stateFn
+	// parser state (see parser.go)
+	state       parseState
+	seq         sequence
+	osc         []byte
+	oscOverflow bool
 
 	Title   string
 	TabSize int
+	tabs    []bool // tab stops by column once HTS or TBC changed them
 
-	// to handle CSIs CSIu
-	saveCursor   [2]int
+	// last graphic character printed, for REP
+	last rune
+
+	// state saved by DECSC or CSI s, one per screen
+	saved        [2]savedCursor
 	scrollRegion [2]int // startRow, endRow
 
 	// holds partial input runes until is able to fully read
@@ -51,23 +66,15 @@ func New(rows, cols int) *Terminal {
 		},
 
 		TabSize: 8,
+		modes:   defaultModes,
 		// BacklogSize: 1000,
 
-		stateProc:    (*Terminal).normal,
 		scrollRegion: [2]int{0, rows},
 	}
 }
 
-type EscapeError struct {
-	Err    error
-	Offset int
-}
-
-func (e EscapeError) Error() string {
-	return fmt.Sprintf("error parsing escape sequence at %d: %v", e.Offset, e.Err)
-}
-
-// Write implements io.Writer and writes the given bytes to the terminal
+// Write implements io.Writer and writes the given bytes to the terminal.
+// Sequences it does not implement are ignored; it never fails.
 func (t *Terminal) Write(p []byte) (int, error) {
 	t.mux.Lock()
 	defer t.mux.Unlock()
@@ -79,12 +86,7 @@ func (t *Terminal) Write(p []byte) (int, error) {
 		}
 		r, _ := utf8.DecodeRune(t.part)
 		t.part = t.part[:0]
-		if err := t.put(r); err != nil {
-			return i, EscapeError{
-				Err:    err,
-				Offset: i,
-			}
-		}
+		t.put(r)
 	}
 	return len(p), nil
 }
@@ -97,13 +99,16 @@ func (t *Terminal) Resize(rows, cols int) {
 		return
 	}
 
-	switch t.screenTarget {
-	case 0:
-		t.screens[0].ResizeAndReflow(rows, cols)
-	case 1:
+	t.screens[0].ResizeAndReflow(rows, cols)
+	if t.screens[1] != nil {
 		t.screens[1].Resize(rows, cols)
 	}
-	t.saveCursor = [2]int{0, 0}
+	for i := range t.saved {
+		// what DECRC restores stays on the screen
+		pos := &t.saved[i].pos
+		*pos = [2]int{min(pos[0], rows-1), min(pos[1], cols-1)}
+	}
+	t.resizeTabs(cols)
 	t.scrollRegion = [2]int{0, rows} // reset?! or resize
 }
 
@@ -111,7 +116,10 @@ func (t *Terminal) Resize(rows, cols int) {
 func (t *Terminal) Clear() {
 	t.mux.Lock()
 	defer t.mux.Unlock()
+	t.clear()
+}
 
+func (t *Terminal) clear() {
 	s := t.screens[t.screenTarget]
 	s.cursor = [2]int{}
 	sz := s.size
@@ -119,86 +127,146 @@ func (t *Terminal) Clear() {
 	t.screens[0].cells = make([]Cell, sz[0]*sz[1])
 }
 
-func (t *Terminal) GetScreenAsAnsi() []byte {
+// reset performs RIS: the terminal goes back to how New left it, at its
+// current size, without scrollback.
+func (t *Terminal) reset() {
+	rows, cols := t.screens[t.screenTarget].Size()
+	t.screens = [2]*Grid{{
+		cells:       make([]Cell, rows*cols),
+		size:        [2]int{rows, cols},
+		backlogSize: t.screens[0].backlogSize,
+	}}
+	t.screenTarget = 0
+	t.softReset()
+	t.saved = [2]savedCursor{}
+	t.tabs = nil
+	t.last = 0
+	t.link = nil
+	t.modes = defaultModes
+}
+
+// softReset performs DECSTR, as xterm.js does: the modes but mouse reporting
+// go back to their defaults, and so do the graphic rendition, the character
+// sets, the scroll region and the saved cursor. The screen is left alone.
+func (t *Terminal) softReset() {
+	t.modes = defaultModes | t.modes&(mouseTracking|mouseEncoding)
+	t.cstate = SGRState{}
+	t.charsets, t.gl, t.single = [4]designation{}, 0, 0
+	t.saved[t.screenTarget] = savedCursor{}
+	t.scrollRegion = [2]int{0, t.screens[t.screenTarget].size[0]}
+}
+
+// Snapshot is a copy of the visible screen: its cells row by row, its size,
+// and the cursor position (row, column) unless the application hid it.
+type Snapshot struct {
+	Rows, Cols   int
+	Cells        []Cell
+	Cursor       [2]int
+	CursorHidden bool
+}
+
+// Snapshot returns a copy of the visible screen.
+func (t *Terminal) Snapshot() *Snapshot {
 	t.mux.Lock()
 	defer t.mux.Unlock()
 
-	return t.getScreenAsAnsi()
+	return t.snapshot()
 }
 
-// GetCursorPos returns the current cursor position in lines, cols
-func (t *Terminal) CursorPos() (int, int) {
+// SnapshotWithScrollback returns a copy of the visible screen and of the
+// lines that scrolled off the top of the primary screen, taken together so
+// that they join up and are as wide.
+func (t *Terminal) SnapshotWithScrollback() (*Snapshot, []Cell) {
 	t.mux.Lock()
 	defer t.mux.Unlock()
 
+	return t.snapshot(), t.scrollback()
+}
+
+func (t *Terminal) snapshot() *Snapshot {
 	s := t.screens[t.screenTarget]
-	return s.cursor[0], s.cursor[1]
+	return &Snapshot{
+		Rows:         s.size[0],
+		Cols:         s.size[1],
+		Cells:        slices.Clone(t.screenView()),
+		Cursor:       s.cursor,
+		CursorHidden: t.modes&modeCursorShown == 0,
+	}
 }
 
-func (t *Terminal) put(r rune) error {
-	// Default to normal stateFn
+// Scrollback returns a copy of the lines that scrolled off the top of the
+// primary screen, oldest first, row by row.
+func (t *Terminal) Scrollback() []Cell {
+	t.mux.Lock()
+	defer t.mux.Unlock()
 
-	// TODO: Figure this out, vim/compterm client ocasionally sends this in any state!?
-	// probably querying something
-	if r == '\x01' {
-		return nil
-	}
-	sfn := t.stateProc
-	if sfn == nil {
-		sfn = (*Terminal).normal
-	}
+	return t.scrollback()
+}
 
-	ns, err := sfn(t, r)
-	if ns != nil {
-		t.stateProc = ns
-	}
-	return err
+func (t *Terminal) scrollback() []Cell {
+	s := t.screens[0]
+	n := max(len(s.cells)-s.size[0]*s.size[1], 0)
+	return slices.Clone(s.cells[:n])
+}
+
+func (t *Terminal) GetScreenAsAnsi() []byte {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	return t.getScreenAsAnsi(len(t.screens[0].cells))
+}
+
+// GetRecentScreenAsAnsi is GetScreenAsAnsi with at most scrollback lines of
+// the scrollback, the most recent.
+func (t *Terminal) GetRecentScreenAsAnsi(scrollback int) []byte {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	return t.getScreenAsAnsi(max(scrollback, 0))
+}
+
+// CursorAsAnsi returns the CUP that moves the cursor where it is, on a
+// terminal GetScreenAsAnsi brought to this state: in origin mode it counts
+// from the top of the scroll region.
+func (t *Terminal) CursorAsAnsi() []byte {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	s := t.screens[t.screenTarget]
+	return appendCursorPos(nil, [2]int{s.cursor[0] - t.originRow(), s.cursor[1]})
 }
 
-// maybe this move to grid.go
+// GetCursorPos returns the current cursor position in lines, cols
+func (t *Terminal) CursorPos() (int, int) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	s := t.screens[t.screenTarget]
+	return s.cursor[0], s.cursor[1]
+}
+
+// nextLine moves the cursor a line down, scrolling the scroll region up
+// when the cursor is at its bottom. Only scrolls of the whole primary screen
+// feed the scrollback.
 func (t *Terminal) nextLine() {
 	s := t.screens[t.screenTarget]
-	rows, cols := s.Size()
+	top, bottom := t.scrollRegion[0], t.scrollRegion[1]
 
-	s.cursor[0]++
 	switch {
-	case s.cursor[0] < t.scrollRegion[1]-1:
-		return
-	case s.cursor[0] == t.scrollRegion[1] && t.scrollRegion[0] == 0:
-		s.cursor[0]--
-		if t.screenTarget == 0 && len(s.cells)/cols < s.backlogSize {
-			t.screens[0].cells = append(t.screens[0].cells, make([]Cell, cols)...)
-			// TODO: should be:
-			// - insert a new line in offseted scrollRegion[1]
-			// - copy the rest
-			return
-		} // else copy on region?
-		region := t.screenScrollRegion()
-		copy(region, region[cols:])
-		fill(region[len(region)-cols:], Cell{})
-	case s.cursor[0] == t.scrollRegion[1]:
-		s.cursor[0]--
-		region := t.screenScrollRegion()
-		if len(region)/cols < s.backlogSize {
-			region = append(region, make([]Cell, cols)...)
-		}
-		copy(region, region[cols:])
-		fill(region[len(region)-cols:], Cell{})
-
-	// Replicate odd xterm behaviour of when the cursor is outside of the region
-	// it will not print neither scroll
-	case s.cursor[0] == rows:
-		s.cursor[0]--
+	case s.cursor[0] == bottom-1:
+		s.scrollUp(top, bottom, 1, Cell{SGRState: t.cstate}, true)
+	// Replicate xterm: below the region the cursor stops at the last row,
+	// and nothing scrolls
+	case s.cursor[0] < s.size[0]-1:
+		s.cursor[0]++
 	}
 }
 
-func (t *Terminal) normal(r rune) (stateFn, error) {
+// execute performs a C0 or C1 control function.
+func (t *Terminal) execute(r rune) {
 	s := t.screens[t.screenTarget]
-	_, cols := s.size[0], s.size[1]
-	switch {
-	case r == '\033':
-		return (*Terminal).esc, nil
-	case r == '\n':
+	switch r {
+	case '\n', '\v', '\f':
 		t.nextLine()
 		s.cursor[1] = 0
 
@@ -214,491 +282,503 @@ func (t *Terminal) normal(r rune) (stateFn, error) {
 				mark = i
 			}
 		}
+		if line[mark].wide == wideHead && mark+1 < len(line) {
+			// the line ends after the wide character's second cell
+			mark++
+		}
 		line[mark].nl = true
-	case r == '\r':
+	case '\r':
 		s.cursor[1] = 0
-	case r == '\b':
+	case '\b':
 		s.cursor[1] = max(0, s.cursor[1]-1)
-	case r == '\t':
-		s.cursor[1] = (s.cursor[1] + t.TabSize) / t.TabSize * t.TabSize
-		s.cursor[1] = min(s.cursor[1], cols-1)
-	case r < ' ': // least printable char, we ignore it
-	// case !unicode.IsPrint(r):
-	default:
-		if s.cursor[1] >= cols {
-			t.nextLine()
-			s.cursor[1] = 0
-		}
-		cl := Cell{
-			Char:     r,
-			SGRState: t.cstate,
-		}
-		screen := t.screenView()
-		offs := s.cursor[1] + s.cursor[0]*cols
-		if offs < 0 || offs >= len(screen) {
-			// Rare, but to be safe..
-			return nil, fmt.Errorf("offset out of bounds: %d", offs)
+	case '\t':
+		t.tab(1)
+	case 0x0e: // SO: G1 into GL
+		t.gl = 1
+	case 0x0f: // SI: G0 into GL
+		t.gl = 0
+	case 0x8e: // SS2
+		t.single = 2
+	case 0x8f: // SS3
+		t.single = 3
+	case 0x84: // IND
+		t.nextLine()
+	case 0x85: // NEL
+		t.nextLine()
+		s.cursor[1] = 0
+	case 0x88: // HTS
+		t.setTabStop(s.cursor[1], true)
+	case 0x8d: // RI
+		switch {
+		case s.cursor[0] == t.scrollRegion[0]:
+			s.scrollDown(t.scrollRegion[0], t.scrollRegion[1], 1, Cell{SGRState: t.cstate})
+		case s.cursor[0] > 0:
+			s.cursor[0]--
 		}
-		screen[offs] = cl
-		s.cursor[1]++
 	}
-	return nil, nil
 }
 
-func (t *Terminal) esc(r rune) (stateFn, error) {
+// print puts r on the screen at the cursor, over two cells if it is wide. A
+// character that does not fit at the end of the line wraps, or overwrites the
+// last column when autowrap is off; a zero width one joins the character
+// before the cursor. In insert mode the rest of the line moves right.
+func (t *Terminal) print(r rune) {
+	r = t.translate(r)
+	if runeWidth(r) > 0 {
+		t.last = r
+	}
+	t.place(r)
+}
+
+// place puts r, already translated, on the screen at the cursor.
+func (t *Terminal) place(r rune) {
+	w := runeWidth(r)
+	if w == 0 {
+		t.mark(r)
+		return
+	}
 	s := t.screens[t.screenTarget]
-	switch r {
-	case '[':
-		return t.csi(), nil
-	case ']':
-		return t.osc(), nil
-	case '>':
-		// TODO: {lpf} (completed by copilot: DEC private mode reset)
-	case '=':
-		// TODO: {lpf} (completed by copilot: DEC private mode set)
-	case '(':
-		return t.ignore(1, (*Terminal).normal), nil // set G0 charset (ignore next rune and go to normal state)
-	case 'c':
-		// TODO: should be t.Reset() and reset state
-		t.Clear()
-	case 'M': // Reverse Index (move cursor up, scrolling if needed)
-		s.cursor[0] = max(0, s.cursor[0]-1)
-	case 'k':
-		return t.captureString(func(s string) stateFn {
-			// ignore string
-			return (*Terminal).normal
-		}), nil
-	case '\\':
-		// TODO: {lpf} (completed by copilot: String Terminator (ST))
-	default:
-		return (*Terminal).normal, fmt.Errorf("unknown escape sequence: %d %[1]c", r)
-	}
-	return (*Terminal).normal, nil
-}
-
-// State dummy state to accept any n runes
-func (t *Terminal) ignore(n int, next stateFn) stateFn {
-	return func(*Terminal, rune) (stateFn, error) {
-		n--
-		if n <= 0 {
-			return next, nil
+	cols := s.size[1]
+	if w > cols {
+		return
+	}
+	switch {
+	case s.cursor[1]+w > cols && t.modes&modeAutowrap == 0:
+		// without autowrap the last column is overwritten
+		s.cursor[1] = cols - w
+	case s.cursor[1]+w > cols:
+		if s.cursor[1] < cols {
+			t.screenLine(s.cursor[0])[s.cursor[1]] = Cell{SGRState: t.cstate}
 		}
-		return nil, nil
+		t.nextLine()
+		s.cursor[1] = 0
+	}
+	if s.cursor[0] < 0 || s.cursor[0] >= s.size[0] {
+		// Rare, but to be safe..
+		return
+	}
+	line := t.screenLine(s.cursor[0])
+	col := s.cursor[1]
+	// overwriting half of a wide character blanks the other half
+	if line[col].wide == wideTail && col > 0 {
+		line[col-1] = Cell{SGRState: line[col-1].SGRState}
+	}
+	if t.modes&modeInsert != 0 {
+		copy(line[col+w:], line[col:])
+	} else if end := col + w - 1; line[end].wide == wideHead && end+1 < cols {
+		line[end+1] = Cell{SGRState: line[end+1].SGRState}
+	}
+
+	line[col] = Cell{
+		Char:     r,
+		link:     t.link,
+		SGRState: t.cstate,
+	}
+	if w == 2 {
+		line[col].wide = wideHead
+		line[col+1] = Cell{wide: wideTail, link: t.link, SGRState: t.cstate}
+	}
+	if t.modes&modeInsert != 0 {
+		repairWide(line)
+	}
+	s.cursor[1] += w
+	if t.modes&modeAutowrap == 0 {
+		s.cursor[1] = min(s.cursor[1], cols-1)
 	}
 }
 
-// State to capture a string until String terminator ESC\\
-// once done it will call the given function with the captured string
-func (t *Terminal) captureString(fn func(s string) stateFn) stateFn {
-	sb := strings.Builder{}
-	esc := false
-	return func(_ *Terminal, r rune) (stateFn, error) {
-		switch {
-		case r == '\033':
-			esc = true
-		case r == '\\' && esc:
-			return fn(sb.String()), nil
-		default:
-			esc = false
-			sb.WriteRune(r)
-		}
-		return nil, nil
+// mark adds a zero width rune to the character before the cursor, if it is
+// on the same line.
+func (t *Terminal) mark(r rune) {
+	s := t.screens[t.screenTarget]
+	col := min(s.cursor[1], s.size[1]) - 1
+	if col < 0 || s.cursor[0] < 0 || s.cursor[0] >= s.size[0] {
+		return
+	}
+	line := t.screenLine(s.cursor[0])
+	if line[col].wide == wideTail && col > 0 {
+		col--
+	}
+	c := &line[col]
+	if c.Char != 0 && len(c.marks)+utf8.RuneLen(r) <= maxMarks {
+		c.marks += string(r)
 	}
 }
 
-// State Operating System Command
-func (t *Terminal) osc() stateFn {
-	attrbuf := bytes.NewBuffer(nil)
-	title := &strings.Builder{}
-	esc := false
-	return func(_ *Terminal, r rune) (stateFn, error) {
-		if r == ';' || unicode.IsNumber(r) {
-			esc = false
-			attrbuf.WriteRune(r)
-			return nil, nil
-		}
-		switch {
-		case r == '\a' || (r == '\\' && esc):
-			t.Title = title.String()
-			return (*Terminal).normal, nil
-		// Handle string terminator "\033\\"
-		case r == '\033': // string terminator
-			esc = true
-			return nil, nil
-		default:
-			title.WriteRune(r)
-		}
-		esc = false
-		return nil, nil
+// escDispatch performs the escape sequence ending in final.
+func (t *Terminal) escDispatch(final rune) {
+	switch {
+	case t.seq.ignore:
+		return
+	case len(t.seq.inter) > 0:
+		t.designate(t.seq.inter, final)
+		return
+	}
+	switch final {
+	case 'N': // SS2
+		t.execute(0x8e)
+	case 'O': // SS3
+		t.execute(0x8f)
+	case 'n': // LS2: G2 into GL
+		t.gl = 2
+	case 'o': // LS3: G3 into GL
+		t.gl = 3
+	case '7': // DECSC
+		t.saveCursor()
+	case '8': // DECRC
+		t.restoreCursor()
+	case 'D': // IND
+		t.execute(0x84)
+	case 'E': // NEL
+		t.execute(0x85)
+	case 'H': // HTS
+		t.execute(0x88)
+	case 'M': // RI
+		t.execute(0x8d)
+	case 'c': // RIS
+		t.reset()
 	}
 }
 
-// to handle cases like "\033[>P;N..." (cursor keys to application mode)
-func (t *Terminal) csiGT() stateFn {
-	// attrbuf := bytes.NewBuffer(nil)
-	return func(_ *Terminal, r rune) (stateFn, error) {
-		if r == ';' || unicode.IsNumber(r) {
-			// TODO: implement as params
-			// attrbuf.WriteRune(r){
-			return nil, nil
-		}
-		// TODO: no clue, implement if needed (tmux)
-		switch r {
-		case 'm':
-			return (*Terminal).normal, nil
-		case 'c':
-			return (*Terminal).normal, nil
-		case 'q':
-			return (*Terminal).normal, nil
-		default:
-			return (*Terminal).normal, fmt.Errorf("unknown CSI>: %d %[1]c", r)
-		}
+// oscDispatch performs an operating system command: a number, then its
+// argument after a semicolon.
+func (t *Terminal) oscDispatch(data []byte) {
+	ps, pt, _ := bytes.Cut(data, []byte(";"))
+	switch string(ps) {
+	case "0", "2": // icon name and window title, window title
+		t.Title = string(pt)
+	case "8": // hyperlink
+		t.setLink(pt)
 	}
 }
 
-// State Control Sequence Introducer
-func (t *Terminal) csi() stateFn {
+// csiDispatch performs the control sequence ending in final.
+func (t *Terminal) csiDispatch(final rune) {
 	s := t.screens[t.screenTarget]
 	rows, cols := s.size[0], s.size[1]
-	var p []int
-	nextParam := true
-	return func(t *Terminal, r rune) (stateFn, error) {
-		switch {
-		case r == ':' || r == ';':
-			nextParam = true
-			return nil, nil
-		case unicode.IsNumber(r):
-			if nextParam {
-				nextParam = false
-				p = append(p, 0)
+	p := &t.seq
+
+	switch {
+	case p.is('?', ""):
+		t.decPrivate(final)
+		return
+	case p.is(0, "!") && final == 'p': // DECSTR
+		t.softReset()
+		return
+	case !p.is(0, ""):
+		// e.g. CSI > c (secondary device attributes)
+		return
+	}
+
+	switch final {
+	// Cursor movement
+	case 'A': // Cursor UP
+		t.up(p.count(0))
+	case 'B': // Cursor DOWN
+		t.down(p.count(0))
+	case 'C': // Cursor FORWARD
+		s.cursor[1] = min(cols-1, s.cursor[1]+p.count(0))
+	case 'D': // Cursor BACK
+		s.cursor[1] = max(0, s.cursor[1]-p.count(0))
+	case 'E': // Moves cursor to beginning of the line n (default 1) lines down.
+		s.cursor[1] = 0
+		t.down(p.count(0))
+	case 'F': // Moves cursor to beginning of the line n (default 1) lines up.
+		s.cursor[1] = 0
+		t.up(p.count(0))
+	case 'G': // Cursor HORIZONTAL ABSOLUTE
+		s.cursor[1] = clamp(p.get(0, 1)-1, 0, cols-1)
+	case 'H', 'f': // Cursor POSITION (line, col)
+		s.cursor[0] = t.row(p.get(0, 1))
+		s.cursor[1] = clamp(p.get(1, 1)-1, 0, cols-1)
+	case 'd': // Line POSITION ABSOLUTE
+		s.cursor[0] = t.row(p.get(0, 1))
+	// Display erase
+	case 'J': // Erase in Display
+		screen := t.screenView()
+
+		switch p.get(0, 0) {
+		case 0: // clear from cursor to end
+			off := clamp(s.cursor[1]+s.cursor[0]*cols, 0, len(screen))
+			fill(screen[off:], Cell{SGRState: t.cstate})
+			repairWide(t.screenLine(s.cursor[0]))
+		case 1: // clear from beginning to cursor
+			off := clamp(s.cursor[1]+s.cursor[0]*cols, 0, len(screen))
+			fill(screen[:off], Cell{SGRState: t.cstate})
+			repairWide(t.screenLine(s.cursor[0]))
+		case 2: // clear everything
+			fill(screen, Cell{SGRState: t.cstate})
+		case 3: // clear scrollback
+			if t.screenTarget == 1 {
+				break
+			}
+			if len(t.screens[0].cells) <= rows*cols {
+				break
 			}
-			last := len(p) - 1
-			p[last] = p[last]*10 + int(r-'0')
-			return nil, nil
+			copy(t.screens[0].cells, screen)
+			t.screens[0].cells = t.screens[0].cells[:rows*cols]
 		}
-
-		switch r {
-		// for sequences like ESC[?25l (hide cursor)
-		case '?':
-			// maybe set a flag somewhere or use a new state
-			return nil, nil
-		case '>':
-			return t.csiGT(), nil
-		// Cursor movement
-		case 'A': // Cursor UP
-			n := 1
-			getParams(p, &n)
-			s.cursor[0] = max(0, s.cursor[0]-n)
-		case 'B': // Cursor DOWN
-			n := 1
-			getParams(p, &n)
-			s.cursor[0] = min(rows-1, s.cursor[0]+n)
-		case 'C': // Cursor FORWARD
-			n := 1
-			getParams(p, &n)
-			s.cursor[1] = min(cols-1, s.cursor[1]+n)
-		case 'D': // Cursor BACK
-			n := 1
-			getParams(p, &n)
-			s.cursor[1] = max(0, s.cursor[1]-n)
-		case 'E': // (copilot) Moves cursor to beginning of the line n (default 1) lines down.
-			n := 1
-			getParams(p, &n)
+	case 'K': // Erase in Line
+		line := t.screenLine(s.cursor[0])
+		col := min(s.cursor[1], cols)
+		switch p.get(0, 0) {
+		case 0: // clear from cursor to end
+			fill(line[col:], Cell{SGRState: t.cstate})
+		case 1: // clear from beginning to cursor
+			fill(line[:col], Cell{SGRState: t.cstate})
+		case 2: // clear everything
+			fill(line, Cell{SGRState: t.cstate})
+		}
+		repairWide(line)
+	case 'M': // Delete lines, it will move the rest of the region up
+		if t.inRegion(s.cursor[0]) {
+			s.scrollUp(s.cursor[0], t.scrollRegion[1], p.count(0), Cell{SGRState: t.cstate}, false)
 			s.cursor[1] = 0
-			s.cursor[0] = min(rows-1, s.cursor[0]+n)
-		case 'F': // (copilot)  Moves cursor to beginning of the line n (default 1) lines up.
-			n := 1
-			getParams(p, &n)
+		}
+	case 'P': // Delete chars in line it will move the rest of the line to the left
+		line := t.screenLine(s.cursor[0])
+		col := min(s.cursor[1], cols)
+		n := min(p.count(0), cols-col)
+
+		copy(line[col:], line[col+n:])
+		fill(line[len(line)-n:], Cell{})
+		repairWide(line)
+	case 'X': // Erase chars, up to the end of the line
+		line := t.screenLine(s.cursor[0])
+		col := min(s.cursor[1], cols-1)
+		n := min(p.count(0), cols-col)
+
+		fill(line[col:col+n], Cell{SGRState: t.cstate})
+		repairWide(line)
+	case 'L': // Insert lines, it will push the rest of the region down
+		if t.inRegion(s.cursor[0]) {
+			s.scrollDown(s.cursor[0], t.scrollRegion[1], p.count(0), Cell{SGRState: t.cstate})
 			s.cursor[1] = 0
-			s.cursor[0] = max(0, s.cursor[0]-n)
-		case 'G': // (copilot) Cursor HORIZONTAL ABSOLUTE
-			n := 1
-			getParams(p, &n)
-			s.cursor[1] = clamp(n-1, 0, cols-1)
-		case 'H': // Cursor POSITION (col, line)
-			line, col := 1, 1
-			getParams(p, &line, &col)
-			s.cursor[1] = clamp(col-1, 0, cols-1)
-			s.cursor[0] = clamp(line-1, 0, rows-1)
-		case 'd':
-			n := 0
-			getParams(p, &n)
-			s.cursor[0] = clamp(n-1, 0, rows-1)
-		// Display erase
-		case 'J': // Erase in Display
-			n := 0
-			getParams(p, &n)
-
-			screen := t.screenView()
-
-			switch n {
-			case 0: // clear from cursor to end
-				off := clamp(s.cursor[1]+s.cursor[0]*cols, 0, len(screen))
-				fill(screen[off:], Cell{SGRState: t.cstate})
-			case 1: // clear from beginning to cursor
-				off := clamp(s.cursor[1]+s.cursor[0]*cols, 0, len(screen))
-				fill(screen[:off], Cell{SGRState: t.cstate})
-			case 2: // clear everything
-				fill(screen, Cell{SGRState: t.cstate})
-			case 3: // clear scrollback
-				if t.screenTarget == 1 {
-					break
-				}
-				if len(t.screens[0].cells) <= rows*cols {
-					break
-				}
-				copy(t.screens[0].cells, screen)
-				t.screens[0].cells = t.screens[0].cells[:rows*cols]
-			}
-		case 'K': // Erase in Line
-			n := 0
-			getParams(p, &n)
-
-			// screen := t.screenView()
-
-			// l := clamp(s.cursor[0], 0, rows) * cols
-			line := t.screenLine(s.cursor[0]) // screen[l : l+cols]
-			//line := screen[l : l+cols]
-			switch n {
-			case 0: // clear from cursor to end
-				fill(line[s.cursor[1]:], Cell{SGRState: t.cstate})
-			case 1: // clear from beginning to cursor
-				fill(line[:s.cursor[1]], Cell{SGRState: t.cstate})
-			case 2: // clear everything
-				fill(line, Cell{SGRState: t.cstate})
-			}
-		case 'M': // Delete lines, it will move the rest of the lines up
-			n := 1
-			getParams(p, &n)
-
-			region := t.screenScrollRegion()
-
-			lr := max(s.cursor[0], 0)
-			loff := clamp(lr*cols, 0, len(region))
-			eoff := clamp(loff+n*cols, 0, len(region))
-			copy(region[loff:], region[eoff:])
-			fill(region[len(region)-n*cols:], Cell{})
-		case 'P': // Delete chars in line it will move the rest of the line to the left
-			n := 1
-			getParams(p, &n)
-
-			line := t.screenLine(s.cursor[0])
-
-			copy(line[s.cursor[1]:], line[s.cursor[1]+n:])
-			fill(line[len(line)-n:], Cell{})
-		case 'X': // Erase chars
-			n := 0
-			getParams(p, &n)
-
-			screen := t.screenView()
-
-			off := s.cursor[1] + s.cursor[0]*cols
-			end := min(off+n, len(screen))
-			fill(screen[off:end], Cell{SGRState: t.cstate})
-		case 'L': // Insert lines, it will push lines forward
-			n := 1
-			getParams(p, &n)
-
-			region := t.screenScrollRegion()
-
-			lr := max(s.cursor[0], 0)
-			loff := clamp(lr*cols, 0, len(region))
-			eoff := clamp(loff+n*cols, 0, len(region))
-			dup := slices.Clone(region)
-			copy(region[eoff:], dup[loff:])
-			fill(region[loff:eoff], Cell{SGRState: t.cstate})
-		case '@':
-			// TODO: {lpf} (comment by copilot: Insert blank characters (SP) (default = 1))
-		// SGR
-		case 'm':
-			err := t.cstate.Set(p...)
-			return (*Terminal).normal, err
-		case 'u':
-			s.cursor[0] = t.saveCursor[0]
-			s.cursor[1] = t.saveCursor[1]
-		case 's':
-			t.saveCursor = [2]int{s.cursor[0], s.cursor[1]}
-		case 'c':
-			// TODO: {lpf} (comment by copilot: Send device attributes)
-		case 'h':
-			switch p[0] {
-			// enter private mode
-			case 1049:
-				t.screens[1] = &Grid{
-					cells:  make([]Cell, rows*cols),
-					size:   [2]int{rows, cols},
-					cursor: t.screens[0].cursor,
-				}
-				t.screenTarget = 1
-
-			case 1004:
-				// TODO: Turn focus report ON
-			}
-		case 'l':
-			switch p[0] {
-			// restore private mode
-			case 1049:
-				t.screens[0].ResizeAndReflow(rows, cols)
-				t.screenTarget = 0
-			case 25: // hide cursor if first rune is '?'
-			case 1:
-				// TODO: Turn cursor keys to application mode OFF
-			}
-		case 't':
-			// TODO: {lpf} (comment by copilot: Window manipulation)
-		case 'r':
-			top, bottom := 1, rows
-			getParams(p, &top, &bottom)
-
-			switch {
-			// Invert order if top is bigger (alacritty)
-			case top > bottom:
-				top, bottom = bottom, top
-			// Disable scrollRegion if equal (alacritty, xterm)
-			case top == bottom:
-				top, bottom = 1, rows
+		}
+	case 'h', 'l': // SM, RM
+		for i := range p.n() {
+			if p.get(i, 0) == 4 { // IRM
+				t.setMode(modeInsert, final == 'h')
 			}
-
-			t.scrollRegion[0] = clamp(top-1, 0, rows)
-			t.scrollRegion[1] = clamp(bottom, 0, rows)
-
-			// TODO: this needs some love, it's not working as expected
-			// some cases it resets cursor, others resets the whole screen
-			switch {
-			case len(p) == 0:
-				// fill(t.screen, Cell{})
-				// Reset backScroll too
-				s.cursor = [2]int{}
-			case len(p) == 1:
-				s.cursor = [2]int{}
-			default:
-				// fill(t.screen, Cell{})
-				// s.cursor[0] = 0
-				// s.cursor[1] = 0
+		}
+	case '@': // Insert blank chars, pushing the rest of the line right
+		line := t.screenLine(s.cursor[0])
+		col := min(s.cursor[1], cols-1)
+		n := min(p.count(0), cols-col)
+
+		copy(line[col+n:], line[col:])
+		fill(line[col:col+n], Cell{SGRState: t.cstate})
+		repairWide(line)
+		s.cursor[1] = col
+	case 'b': // Repeat the last character
+		if t.last != 0 {
+			for range min(p.count(0), rows*cols) {
+				t.place(t.last)
 			}
-		case 'S': // Scrollup
-			n := 1
-			getParams(p, &n)
+		}
+	case 'I': // Cursor forward tabulation
+		t.tab(p.count(0))
+	case 'Z': // Cursor backward tabulation
+		t.tab(-p.count(0))
+	case 'g': // Tab clear
+		switch p.get(0, 0) {
+		case 0:
+			t.setTabStop(s.cursor[1], false)
+		case 3:
+			t.tabs = make([]bool, cols)
+		}
+	// SGR
+	case 'm':
+		_ = t.cstate.Set(p.params()...)
+	case 'u':
+		t.restoreCursor()
+	case 's':
+		t.saveCursor()
+	case 'r': // Set the scroll region, ignored unless it spans two rows
+		// a parameter of 0 is the default, as in xterm
+		top, bottom := max(p.get(0, 1), 1), p.get(1, rows)
+		if bottom == 0 {
+			bottom = rows
+		}
+		top, bottom = clamp(top-1, 0, rows), clamp(bottom, 0, rows)
+		if top < bottom-1 {
+			t.scrollRegion = [2]int{top, bottom}
+			s.cursor = [2]int{t.originRow(), 0}
+		}
+	case 'S': // Scroll up, without feeding the scrollback
+		s.scrollUp(t.scrollRegion[0], t.scrollRegion[1], p.count(0), Cell{SGRState: t.cstate}, false)
+	case 'T': // Scroll down
+		s.scrollDown(t.scrollRegion[0], t.scrollRegion[1], p.count(0), Cell{SGRState: t.cstate})
+	}
+}
 
-			region := t.screenScrollRegion()
+// inRegion reports whether row is in the scroll region.
+func (t *Terminal) inRegion(row int) bool {
+	return row >= t.scrollRegion[0] && row < t.scrollRegion[1]
+}
 
-			copy(region, region[n*cols:])
-			fill(region[len(region)-n*cols:], Cell{})
-		case 'T': // Scrolldown
-			n := 1
-			getParams(p, &n)
+// up moves the cursor n rows up: to the top of the scroll region at most if
+// it starts in it, to the top of the screen otherwise.
+func (t *Terminal) up(n int) {
+	s := t.screens[t.screenTarget]
+	top := 0
+	if s.cursor[0] >= t.scrollRegion[0] {
+		top = t.scrollRegion[0]
+	}
+	s.cursor[0] = max(top, s.cursor[0]-n)
+}
 
-			region := t.screenScrollRegion()
+// down moves the cursor n rows down: to the bottom of the scroll region at
+// most if it starts above it, to the bottom of the screen otherwise.
+func (t *Terminal) down(n int) {
+	s := t.screens[t.screenTarget]
+	bottom := s.size[0] - 1
+	if s.cursor[0] < t.scrollRegion[1] {
+		bottom = t.scrollRegion[1] - 1
+	}
+	s.cursor[0] = min(bottom, s.cursor[0]+n)
+}
 
-			copy(region[n*cols:], region)
-			fill(region[:n*cols], Cell{})
+// decPrivate performs a control sequence with the ? marker: DEC private
+// modes.
+func (t *Terminal) decPrivate(final rune) {
+	p := &t.seq
+	if final != 'h' && final != 'l' {
+		return
+	}
+	set := final == 'h'
+
+	for i := range p.n() {
+		switch mode := p.get(i, 0); mode {
+		case 47: // alternate screen
+			t.useScreen(set, false)
+		case 1047: // alternate screen, cleared on the way out
+			t.useScreen(set, !set)
+		case 1048: // save or restore the cursor
+			if set {
+				t.saveCursor()
+			} else {
+				t.restoreCursor()
+			}
+		case 1049: // save the cursor, then a cleared alternate screen
+			if set && t.screenTarget == 0 {
+				t.saveCursor()
+				t.useScreen(true, true)
+			} else if !set && t.screenTarget == 1 {
+				t.useScreen(false, false)
+				t.restoreCursor()
+			}
 		default:
-			return (*Terminal).normal, fmt.Errorf("unknown CSI: %d %[1]c", r)
+			t.setPrivateMode(mode, set)
+		}
+	}
+}
 
+// useScreen switches output to the alternate screen, or back to the primary
+// one, clearing the alternate screen if clear is set. The cursor stays where
+// it is, as in xterm.
+func (t *Terminal) useScreen(alt, clear bool) {
+	from := t.screens[t.screenTarget]
+	if alt {
+		rows, cols := from.Size()
+		if t.screens[1] == nil || clear {
+			t.screens[1] = &Grid{
+				cells: make([]Cell, rows*cols),
+				size:  [2]int{rows, cols},
+			}
+		}
+		t.screenTarget = 1
+	} else {
+		if clear && t.screens[1] != nil {
+			fill(t.screens[1].cells, Cell{})
 		}
-		return (*Terminal).normal, nil
+		t.screenTarget = 0
 	}
+	t.screens[t.screenTarget].cursor = from.cursor
 }
 
+// screenLine returns row n of the visible screen.
 func (t *Terminal) screenLine(n int) []Cell {
 	s := t.screens[t.screenTarget]
 	n = clamp(n, 0, s.size[0]-1)
 
-	return s.cells[n*s.size[1] : n*s.size[1]+s.size[1]]
-}
-
-func (t *Terminal) screenScrollRegion() []Cell {
-	s := t.screens[t.screenTarget]
-	cols := s.size[1]
-	screen := t.screenView()
-	start := clamp(t.scrollRegion[0]*cols, 0, len(screen))
-	end := clamp(t.scrollRegion[1]*cols, 0, len(screen))
-
-	return screen[start:end]
+	return t.screenView()[n*s.size[1] : n*s.size[1]+s.size[1]]
 }
 
 func (t *Terminal) screenView() []Cell {
-	s := t.screens[t.screenTarget]
-	rows, cols := s.size[0], s.size[1]
-
-	start := max(len(s.cells)-rows*cols, 0)
-	end := len(s.cells)
-
-	return s.cells[start:end]
+	return t.screens[t.screenTarget].view()
 }
 
-func (t *Terminal) getScreenAsAnsi() []byte {
-	s := t.screens[t.screenTarget]
-	cols := s.size[1]
+// getScreenAsAnsi returns what redraws the terminal on a blank one of its
+// size, from the top left corner: the primary screen below its scrollback,
+// then the alternate screen if it is in use, the saved cursor, tab stops,
+// charsets, scroll region, modes and the open hyperlink. CursorAsAnsi places
+// the cursor after it. Only the last scrollback lines of the scrollback are
+// drawn.
+func (t *Terminal) getScreenAsAnsi(scrollback int) []byte {
+	primary := t.screens[0]
+	rows, cols := primary.size[0], primary.size[1]
+	back := max(len(primary.cells)/cols-rows, 0)
+	skip := (back - min(scrollback, back)) * cols
+	b := appendCells(nil, primary.cells[skip:], cols)
+	if t.screenTarget == 1 {
+		// 1049 saves the primary screen's cursor on the way
+		alt := t.screens[1]
+		b = appendSaved(b, t.saved[0], "\033[?1049h\033[H")
+		b = appendCells(b, alt.cells, alt.size[1])
+	}
+	if sc := t.saved[t.screenTarget]; !sc.isZero() {
+		b = appendSaved(b, sc, "\0337")
+	}
+	b = t.appendCharsets(t.appendTabs(b))
+	if r := t.scrollRegion; r != [2]int{0, t.screens[t.screenTarget].size[0]} {
+		b = fmt.Appendf(b, "\033[%d;%dr", r[0]+1, r[1])
+	}
+	b = t.appendModes(b)
+	if t.link != nil {
+		b = appendLink(b, t.link)
+	}
+	return b
+}
 
-	buf := bytes.NewBuffer(nil)
-	x, y := 0, 0
+// appendCells appends cells as rows of cols, separated by CR LF, with their
+// hyperlinks closed at the end.
+func appendCells(b []byte, cells []Cell, cols int) []byte {
+	x := 0
 	lastState := SGRState{}
-	screen := t.screenView()
-	for i := range screen {
+	var lastLink *Hyperlink
+	for _, c := range cells {
 		if x >= cols {
-			y++
 			x = 0
-			buf.WriteString("\r\n")
-			lastState = SGRState{}
+			b = append(b, "\r\n"...)
+		}
+		x++
+		if c.wide == wideTail {
+			// the wide character before it covers it
+			continue
 		}
-		c := screen[i]
 		if c.SGRState != lastState {
 			lastState = c.SGRState
 			// different state, we shall reset and set the new state
-			buf.WriteString("\033[0")
-
-			switch c.ColorType & 0b11 {
-			case Color16:
-				fmt.Fprintf(buf, ";%d", c.FG[0])
-			case Color256:
-				fmt.Fprintf(buf, ";38;5;%d", c.FG[0])
-			case Color16M:
-				fmt.Fprintf(buf, ";38;2;%d;%d;%d", c.FG[0], c.FG[1], c.FG[2])
-			}
-			switch (c.ColorType >> 2) & 0b11 {
-			case Color16:
-				fmt.Fprintf(buf, ";%d", c.BG[0])
-			case Color256:
-				fmt.Fprintf(buf, ";48;5;%d", c.BG[0])
-			case Color16M:
-				fmt.Fprintf(buf, ";48;2;%d;%d;%d", c.BG[0], c.BG[1], c.BG[2])
-			}
-			// underline
-			switch (c.ColorType >> 4) & 0b11 {
-			case Color256:
-				fmt.Fprintf(buf, ";58;5;%d", c.UL[0])
-			case Color16M:
-				fmt.Fprintf(buf, ";58;2;%d;%d;%d", c.UL[0], c.UL[1], c.UL[2])
-			}
-			if c.Flags&FlagBold != 0 {
-				buf.WriteString(";1")
-			}
-			if c.Flags&FlagDim != 0 {
-				buf.WriteString(";2")
-			}
-			if c.Flags&FlagItalic != 0 {
-				buf.WriteString(";3")
-			}
-			if c.Flags&FlagUnderline != 0 {
-				buf.WriteString(";4")
-			}
-			if c.Flags&FlagBlink != 0 {
-				buf.WriteString(";5")
-			}
-			if c.Flags&FlagInverse != 0 {
-				buf.WriteString(";7")
-			}
-			if c.Flags&FlagInvisible != 0 {
-				buf.WriteString(";8")
-			}
-			if c.Flags&FlagStrike != 0 {
-				buf.WriteString(";9")
-			}
-			fmt.Fprintf(buf, "m")
+			b = c.AppendANSI(b)
+		}
+		if c.link != lastLink {
+			lastLink = c.link
+			b = appendLink(b, c.link)
+		}
+		if c.Char < ' ' {
+			b = append(b, ' ')
+		} else {
+			b = append(b, c.Grapheme()...)
 		}
-		buf.WriteRune(max(c.Char, ' '))
-		x += 1
 	}
-	return buf.Bytes()
+	if lastLink != nil {
+		b = appendLink(b, nil)
+	}
+	return b
+}
+
+// appendCursorPos appends the CUP that moves the cursor to pos.
+func appendCursorPos(b []byte, pos [2]int) []byte {
+	return fmt.Appendf(b, "\033[%d;%dH", pos[0]+1, pos[1]+1)
 }
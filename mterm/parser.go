@@ -0,0 +1,312 @@
+package mterm
+
+// The parser is Paul Williams' state machine for DEC VT500-series terminals
+// (https://vt100.net/emu/dec_ansi_parser), run on runes rather than bytes:
+// C1 controls are U+0080 to U+009F. Sub-parameters (colon-separated) are kept
+// apart from parameters, and whatever the terminal does not implement is
+// parsed to its end and ignored, so it can't leak onto the screen.
+
+type parseState uint8
+
+const (
+	stateGround parseState = iota
+	stateEscape
+	stateEscapeIntermediate
+	stateCSIEntry
+	stateCSIParam
+	stateCSIIntermediate
+	stateCSIIgnore
+	stateDCSEntry
+	stateDCSParam
+	stateDCSIntermediate
+	stateDCSPassthrough
+	stateDCSIgnore
+	stateOSCString
+	stateSOSPMAPCString
+)
+
+// Limits of what a sequence keeps: past them parameters are dropped, and a
+// sequence with more intermediates, or an OSC string longer, is ignored.
+const (
+	maxParams        = 32
+	maxValues        = 64 // parameters and sub-parameters
+	maxIntermediates = 2
+	maxOSC           = 8 << 10
+)
+
+// sequence is the escape, control, or device control sequence being parsed.
+type sequence struct {
+	private byte   // a private marker, '<' to '?', or 0
+	inter   []byte // intermediates, ' ' to '/'
+	values  []int  // parameters and their sub-parameters, -1 if omitted
+	ends    []int  // where each parameter's values end
+	full    bool   // too many parameters to keep more
+	ignore  bool   // too many intermediates
+}
+
+func (s *sequence) clear() {
+	s.private, s.full, s.ignore = 0, false, false
+	s.inter, s.values, s.ends = s.inter[:0], s.values[:0], s.ends[:0]
+}
+
+func (s *sequence) collect(r rune) {
+	if len(s.inter) == maxIntermediates {
+		s.ignore = true
+		return
+	}
+	s.inter = append(s.inter, byte(r))
+}
+
+// param adds r, a digit or separator, to the parameters. Once they are
+// full, the rest are dropped.
+func (s *sequence) param(r rune) {
+	if len(s.ends) == 0 {
+		s.next()
+	}
+	switch {
+	case s.full:
+	case r == ';':
+		s.next()
+	case r == ':':
+		if len(s.values) == maxValues {
+			s.full = true
+			return
+		}
+		s.values = append(s.values, -1)
+		s.ends[len(s.ends)-1]++
+	default:
+		v := &s.values[len(s.values)-1]
+		*v = min(max(*v, 0)*10+int(r-'0'), 1<<20)
+	}
+}
+
+// next starts a parameter.
+func (s *sequence) next() {
+	if len(s.ends) == maxParams || len(s.values) == maxValues {
+		s.full = true
+		return
+	}
+	s.values = append(s.values, -1)
+	s.ends = append(s.ends, len(s.values))
+}
+
+// n returns the number of parameters.
+func (s *sequence) n() int { return len(s.ends) }
+
+// sub returns the i-th parameter and its sub-parameters, -1 where omitted.
+func (s *sequence) sub(i int) []int {
+	if i >= s.n() {
+		return nil
+	}
+	start := 0
+	if i > 0 {
+		start = s.ends[i-1]
+	}
+	return s.values[start:s.ends[i]]
+}
+
+// get returns the i-th parameter, or def if it was omitted.
+func (s *sequence) get(i, def int) int {
+	if p := s.sub(i); len(p) > 0 && p[0] >= 0 {
+		return p[0]
+	}
+	return def
+}
+
+// params returns the parameters, each with its sub-parameters.
+func (s *sequence) params() [][]int {
+	out := make([][]int, s.n())
+	for i := range out {
+		out[i] = s.sub(i)
+	}
+	return out
+}
+
+// count returns the i-th parameter as a repeat count: at least 1.
+func (s *sequence) count(i int) int {
+	return max(s.get(i, 1), 1)
+}
+
+// is reports whether the sequence has the given private marker and
+// intermediates, and should be acted on.
+func (s *sequence) is(private byte, inter string) bool {
+	return !s.ignore && s.private == private && string(s.inter) == inter
+}
+
+// put advances the parser by one rune.
+func (t *Terminal) put(r rune) {
+	// transitions from anywhere
+	switch {
+	case r == 0x18 || r == 0x1a: // CAN, SUB: abort the sequence
+		t.transition(stateGround)
+		return
+	case r == 0x1b:
+		t.transition(stateEscape)
+		return
+	case r == 0x90:
+		t.transition(stateDCSEntry)
+		return
+	case r == 0x98 || r == 0x9e || r == 0x9f: // SOS, PM, APC
+		t.transition(stateSOSPMAPCString)
+		return
+	case r == 0x9b:
+		t.transition(stateCSIEntry)
+		return
+	case r == 0x9c: // ST
+		t.transition(stateGround)
+		return
+	case r == 0x9d:
+		t.transition(stateOSCString)
+		return
+	case r >= 0x80 && r < 0xa0:
+		t.transition(stateGround)
+		t.execute(r)
+		return
+	}
+
+	c0 := r < 0x20
+	switch t.state {
+	case stateGround:
+		switch {
+		case c0:
+			t.execute(r)
+		case r != 0x7f:
+			t.print(r)
+		}
+
+	case stateEscape:
+		switch {
+		case c0:
+			t.execute(r)
+		case r < 0x30:
+			t.seq.collect(r)
+			t.state = stateEscapeIntermediate
+		case r == '[':
+			t.transition(stateCSIEntry)
+		case r == ']':
+			t.transition(stateOSCString)
+		case r == 'P':
+			t.transition(stateDCSEntry)
+		case r == 'X' || r == '^' || r == '_' || r == 'k': // k: GNU screen's title
+			t.transition(stateSOSPMAPCString)
+		case r < 0x7f:
+			t.escDispatch(r)
+			t.state = stateGround
+		}
+
+	case stateEscapeIntermediate:
+		switch {
+		case c0:
+			t.execute(r)
+		case r < 0x30:
+			t.seq.collect(r)
+		case r < 0x7f:
+			t.escDispatch(r)
+			t.state = stateGround
+		}
+
+	case stateCSIEntry, stateCSIParam:
+		switch {
+		case c0:
+			t.execute(r)
+		case r < 0x30:
+			t.seq.collect(r)
+			t.state = stateCSIIntermediate
+		case r <= ';':
+			t.seq.param(r)
+			t.state = stateCSIParam
+		case r < 0x40:
+			if t.state == stateCSIParam {
+				t.state = stateCSIIgnore
+				break
+			}
+			t.seq.private = byte(r)
+			t.state = stateCSIParam
+		case r < 0x7f:
+			t.csiDispatch(r)
+			t.state = stateGround
+		}
+
+	case stateCSIIntermediate:
+		switch {
+		case c0:
+			t.execute(r)
+		case r < 0x30:
+			t.seq.collect(r)
+		case r < 0x40:
+			t.state = stateCSIIgnore
+		case r < 0x7f:
+			t.csiDispatch(r)
+			t.state = stateGround
+		}
+
+	case stateCSIIgnore:
+		switch {
+		case c0:
+			t.execute(r)
+		case r >= 0x40 && r < 0x7f:
+			t.state = stateGround
+		}
+
+	case stateDCSEntry, stateDCSParam:
+		switch {
+		case c0:
+		case r < 0x30:
+			t.seq.collect(r)
+			t.state = stateDCSIntermediate
+		case r <= ';':
+			t.seq.param(r)
+			t.state = stateDCSParam
+		case r < 0x40:
+			if t.state == stateDCSParam {
+				t.state = stateDCSIgnore
+				break
+			}
+			t.seq.private = byte(r)
+			t.state = stateDCSParam
+		case r < 0x7f:
+			t.state = stateDCSPassthrough
+		}
+
+	case stateDCSIntermediate:
+		switch {
+		case c0:
+		case r < 0x30:
+			t.seq.collect(r)
+		case r < 0x40:
+			t.state = stateDCSIgnore
+		case r < 0x7f:
+			t.state = stateDCSPassthrough
+		}
+
+	case stateDCSPassthrough, stateDCSIgnore, stateSOSPMAPCString:
+		// no device control string is implemented: the data is dropped
+
+	case stateOSCString:
+		switch {
+		case r == '\a': // xterm's terminator
+			t.transition(stateGround)
+		case c0:
+		case len(t.osc) < maxOSC:
+			t.osc = append(t.osc, string(r)...)
+		default:
+			t.oscOverflow = true
+		}
+	}
+}
+
+// transition leaves the current state for next, with the exit action of one
+// and the entry action of the other.
+func (t *Terminal) transition(next parseState) {
+	if t.state == stateOSCString {
+		if !t.oscOverflow {
+			t.oscDispatch(t.osc)
+		}
+		t.osc, t.oscOverflow = t.osc[:0], false
+	}
+	switch next {
+	case stateEscape, stateCSIEntry, stateDCSEntry:
+		t.seq.clear()
+	}
+	t.state = next
+}
@@ -0,0 +1,83 @@
+package mterm
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// screenText returns the visible screen as text, without trailing blanks.
+func screenText(t *Terminal) string {
+	s := t.Snapshot()
+	lines := make([]string, s.Rows)
+	for r := range s.Rows {
+		var b strings.Builder
+		for _, c := range s.Cells[r*s.Cols : (r+1)*s.Cols] {
+			if c.Width() > 0 {
+				b.WriteRune(max(c.Char, ' '))
+			}
+		}
+		lines[r] = strings.TrimRight(b.String(), " ")
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n")
+}
+
+func TestParserIgnores(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"DEC private modes", "a\033[?2004h\033[?1h\033=b", "ab"},
+		{"mode without parameters", "a\033[hb\033[lc\033[?hd", "abcd"},
+		{"DCS", "a\033P1$r0m\033\\b\033P+q544e\033\\c", "abc"},
+		{"APC, PM, SOS", "a\033_Gf=24;AAAA\033\\b\033^pm\033\\c\033Xsos\033\\d", "abcd"},
+		{"screen title", "a\033kvim\033\\b", "ab"},
+		{"OSC with BEL and ST", "a\033]0;title\ab\033]10;?\033\\c", "abc"},
+		{"xterm key modifiers", "\033[>4;1ma\033[>c", "a"},
+		{"unknown finals", "a\033[5~b\033[2 qc\033#8d", "abcd"},
+		{"cancel", "a\033[3\x18b\033]0;x\x1ac", "abc"},
+		{"C1 controls", "a\u009b2Cb\u009d0;t\u009cc", "a  bc"},
+		{"charset designation", "a\033(Bb\033)0c", "abc"},
+		{"too many parameters", "a\033[" + strings.Repeat("1;", 100) + "mb", "ab"},
+		{"too many intermediates", "a\033[1 !\"#pb", "ab"},
+		{"huge parameter", "\033[99999999999999999999Ca", strings.Repeat(" ", 9) + "a"},
+	}
+	for _, tt := range tests {
+		term := New(2, 10)
+		if _, err := term.Write([]byte(tt.in)); err != nil {
+			t.Errorf("%s: Write: %v", tt.name, err)
+		}
+		if got := screenText(term); got != tt.want {
+			t.Errorf("%s: screen = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParserSequence(t *testing.T) {
+	term := New(2, 10)
+	_, _ = term.Write([]byte("\033[38:2::1:2:3;;4:3m"))
+	subs := term.seq.params()
+
+	want := [][]int{{38, 2, -1, 1, 2, 3}, {-1}, {4, 3}}
+	if len(subs) != len(want) {
+		t.Fatalf("parameters = %v, want %v", subs, want)
+	}
+	for i := range want {
+		if !slices.Equal(subs[i], want[i]) {
+			t.Errorf("parameter %d = %v, want %v", i, subs[i], want[i])
+		}
+	}
+	if term.seq.get(1, 7) != 7 || term.seq.count(5) != 1 {
+		t.Errorf("defaults: get = %d, count = %d", term.seq.get(1, 7), term.seq.count(5))
+	}
+}
+
+func TestParserEscapes(t *testing.T) {
+	term := New(3, 10)
+	_, _ = term.Write([]byte("ab\0337\033[3;5Hx\0338c\033Dd\033Ee\033]2;vim\033\\"))
+	if got, want := screenText(term), "abc\n   d\ne   x"; got != want {
+		t.Errorf("screen = %q, want %q", got, want)
+	}
+	if term.Title != "vim" {
+		t.Errorf("Title = %q, want vim", term.Title)
+	}
+}
@@ -0,0 +1,143 @@
+package mterm
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestScroll(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+		scrollback     int // rows
+	}{
+		{"full screen feeds scrollback", "a\r\nb\r\nc\r\nd\r\ne", "b\nc\nd\ne", 1},
+		{"region at the top", "\033[4;1Hs\033[1;3r1\r\n2\r\n3\r\n4", "2\n3\n4\ns", 0},
+		{"region in the middle", "a\033[4Hz\033[2;3r\033[2H1\r\n2\r\n3", "a\n2\n3\nz", 0},
+		{"below the region", "\033[1;2r\033[4Ha\r\nb", "\n\n\nb", 0},
+		{"SU", "a\r\nb\r\nc\r\nd\033[2S", "c\nd", 0},
+		{"SU in region", "a\r\nb\r\nc\r\nd\033[2;3r\033[S", "a\nc\n\nd", 0},
+		{"SD in region", "a\r\nb\r\nc\r\nd\033[2;3r\033[T", "a\n\nb\nd", 0},
+		{"RI at the top of the region", "a\r\nb\r\nc\r\nd\033[2;3r\033[2H\033M", "a\n\nb\nd", 0},
+		{"IL", "a\r\nb\r\nc\r\nd\033[2;3r\033[2;2H\033[Lx", "a\nx\nb\nd", 0},
+		{"IL outside the region", "a\r\nb\r\nc\r\nd\033[2;3r\033[4;2H\033[L", "a\nb\nc\nd", 0},
+		{"DL", "a\r\nb\r\nc\r\nd\033[2;3r\033[2H\033[5M", "a\n\n\nd", 0},
+		{"DL at the top", "a\r\nb\r\nc\r\nd\033[H\033[2M", "c\nd", 0},
+		{"alternate screen", "a\033[?1049h1\r\n2\r\n3\r\n4\r\n5\r\n6", "3\n4\n5\n6", 0},
+		{"invalid region ignored", "\033[3;3r\033[4;1Ha\r\nb\r\nc", "\na\nb\nc", 2},
+		{"0 is the top", "a\033[0;3r\n\n\n\nb", "\n\nb", 0},
+		{"0;0 is the full screen", "\033[0;0ra\r\nb\r\nc\r\nd\r\ne", "b\nc\nd\ne", 1},
+		{"out of the screen ignored", "\033[9;20ra\r\nb\r\nc\r\nd\r\ne", "b\nc\nd\ne", 1},
+	}
+	for _, tt := range tests {
+		term := New(4, 6)
+		_, _ = term.Write([]byte(tt.in))
+		if got := screenText(term); got != tt.want {
+			t.Errorf("%s: screen = %q, want %q", tt.name, got, tt.want)
+		}
+		if got := len(term.Scrollback()) / 6; got != tt.scrollback {
+			t.Errorf("%s: scrollback = %d rows, want %d", tt.name, got, tt.scrollback)
+		}
+	}
+}
+
+func TestScrollCursor(t *testing.T) {
+	tests := []struct {
+		name, in string
+		cursor   [2]int
+	}{
+		{"CUU stops at the top margin", "\033[2;4r\033[3H\033[9A", [2]int{1, 0}},
+		{"CUU above the region", "\033[3;4r\033[2H\033[9A", [2]int{0, 0}},
+		{"CUD stops at the bottom margin", "\033[2;3r\033[2H\033[9B", [2]int{2, 0}},
+		{"CUD below the region", "\033[1;2r\033[3H\033[9B", [2]int{3, 0}},
+		{"CPL", "\033[2;4r\033[4;3H\033[9F", [2]int{1, 0}},
+		{"DECSTBM homes", "\033[3;4H\033[2;3r", [2]int{0, 0}},
+		{"DECSTBM homes in origin mode", "\033[?6h\033[2;3r", [2]int{1, 0}},
+		{"IL to the left margin", "\033[2;3H\033[L", [2]int{1, 0}},
+	}
+	for _, tt := range tests {
+		term := New(4, 6)
+		_, _ = term.Write([]byte(tt.in))
+		if r, c := term.CursorPos(); [2]int{r, c} != tt.cursor {
+			t.Errorf("%s: cursor = %d,%d, want %v", tt.name, r, c, tt.cursor)
+		}
+	}
+}
+
+func TestScrollBacklog(t *testing.T) {
+	term := New(4, 6)
+	for i := range 3000 {
+		_, _ = fmt.Fprintf(term, "%d\r\n", i)
+	}
+	sb := term.Scrollback()
+	if rows := len(sb)/6 + 4; rows < 1000 || rows > 1000+1000/16 {
+		t.Errorf("backlog = %d rows, want about 1000", rows)
+	}
+	// the newest lines are kept
+	var last strings.Builder
+	for _, c := range sb[len(sb)-6:] {
+		last.WriteString(c.Grapheme())
+	}
+	if got := last.String(); got != "2996" {
+		t.Errorf("last scrollback row = %q, want 2996", got)
+	}
+}
+
+func TestScrollSnapshot(t *testing.T) {
+	// a viewer joins a pager that scrolls its body under a status line
+	term := New(5, 10)
+	for i := range 8 {
+		_, _ = fmt.Fprintf(term, "shell %d\r\n", i)
+	}
+	_, _ = term.Write([]byte("\033[?1049h\033[1;4r\033[5Hstatus\033[H"))
+	for i := range 6 {
+		_, _ = fmt.Fprintf(term, "\033[4Hline %d\r\n", i)
+	}
+
+	mirror := New(5, 10)
+	_, _ = mirror.Write(term.GetScreenAsAnsi())
+	_, _ = mirror.Write(term.CursorAsAnsi())
+
+	for _, step := range []string{"", "\033[Hline up\033[T", "\033[4Hmore\r\n\033[r\033[?1049l"} {
+		_, _ = term.Write([]byte(step))
+		_, _ = mirror.Write([]byte(step))
+		if got, want := screenText(mirror), screenText(term); got != want {
+			t.Errorf("after %q: mirror = %q, host = %q", step, got, want)
+		}
+		if got, want := mirror.Scrollback(), term.Scrollback(); !slices.EqualFunc(got, want, sameChar) {
+			t.Errorf("after %q: mirror scrollback = %d cells, host %d", step, len(got), len(want))
+		}
+	}
+	if got := len(term.Scrollback()) / 10; got != 4 {
+		t.Errorf("scrollback = %d rows, want the shell's 4", got)
+	}
+
+	// in origin mode, the cursor lands where it was in the region
+	term = New(5, 10)
+	_, _ = term.Write([]byte("\033[2;4r\033[?6h\033[2;3Hx"))
+	mirror = New(5, 10)
+	_, _ = mirror.Write(term.GetScreenAsAnsi())
+	_, _ = mirror.Write(term.CursorAsAnsi())
+	_, _ = term.Write([]byte("y\033[Hz"))
+	_, _ = mirror.Write([]byte("y\033[Hz"))
+	if got, want := screenText(mirror), screenText(term); got != want || want != "\nz\n  xy" {
+		t.Errorf("mirror = %q, host = %q, want %q", got, want, "\nz\n  xy")
+	}
+}
+
+func TestSnapshotWithScrollback(t *testing.T) {
+	term := New(2, 3)
+	_, _ = term.Write([]byte("a\r\nb\r\nc\r\nd"))
+	snap, history := term.SnapshotWithScrollback()
+	if got, want := len(history), 2*3; got != want {
+		t.Fatalf("scrollback = %d cells, want %d", got, want)
+	}
+	var text []rune
+	for _, c := range append(history, snap.Cells...) {
+		text = append(text, max(c.Char, '.'))
+	}
+	if got, want := string(text), "a..b..c..d.."; got != want {
+		t.Errorf("scrollback and screen = %q, want %q", got, want)
+	}
+}
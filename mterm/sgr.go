@@ -19,6 +19,22 @@ const (
 	FlagStrike
 )
 
+// Attributes in SGRState.Extra: the style of the underline when FlagUnderline
+// is set, overline and rapid blink.
+const (
+	ExtraUnderlineStyle uint8 = 0b111
+	ExtraOverline       uint8 = 1 << 3
+	ExtraRapidBlink     uint8 = 1 << 4
+)
+
+// Underline styles, as in SGR 4:n; a single underline is 0.
+const (
+	UnderlineDouble uint8 = 2 + iota
+	UnderlineCurly
+	UnderlineDotted
+	UnderlineDashed
+)
+
 type Color [3]byte
 
 type SGRState struct {
@@ -27,23 +43,88 @@ type SGRState struct {
 	UL        Color // underline color
 	ColorType uint8 // 0b00uubbff (u underline, b BG, f FG color types)
 	Flags     uint8
+	Extra     uint8 // 0b000rosss (r rapid blink, o overline, s underline style)
+}
+
+// AppendANSI appends the SGR sequence that resets the attributes and sets s,
+// always in the semicolon form.
+func (s SGRState) AppendANSI(b []byte) []byte {
+	b = append(b, "\033[0"...)
+
+	switch s.ColorType & 0b11 {
+	case Color16:
+		b = fmt.Appendf(b, ";%d", s.FG[0])
+	case Color256:
+		b = fmt.Appendf(b, ";38;5;%d", s.FG[0])
+	case Color16M:
+		b = fmt.Appendf(b, ";38;2;%d;%d;%d", s.FG[0], s.FG[1], s.FG[2])
+	}
+	switch (s.ColorType >> 2) & 0b11 {
+	case Color16:
+		b = fmt.Appendf(b, ";%d", s.BG[0])
+	case Color256:
+		b = fmt.Appendf(b, ";48;5;%d", s.BG[0])
+	case Color16M:
+		b = fmt.Appendf(b, ";48;2;%d;%d;%d", s.BG[0], s.BG[1], s.BG[2])
+	}
+	// underline
+	switch (s.ColorType >> 4) & 0b11 {
+	case Color256:
+		b = fmt.Appendf(b, ";58;5;%d", s.UL[0])
+	case Color16M:
+		b = fmt.Appendf(b, ";58;2;%d;%d;%d", s.UL[0], s.UL[1], s.UL[2])
+	}
+
+	flags := [...]struct {
+		flag uint8
+		code string
+	}{
+		{FlagBold, ";1"},
+		{FlagDim, ";2"},
+		{FlagItalic, ";3"},
+		{FlagUnderline, ";4"},
+		{FlagBlink, ";5"},
+		{FlagInverse, ";7"},
+		{FlagInvisible, ";8"},
+		{FlagStrike, ";9"},
+	}
+	for _, f := range flags {
+		if s.Flags&f.flag == 0 {
+			continue
+		}
+		b = append(b, f.code...)
+		if style := s.Extra & ExtraUnderlineStyle; f.flag == FlagUnderline && style != 0 {
+			b = fmt.Appendf(b, ":%d", style)
+		}
+	}
+	if s.Extra&ExtraRapidBlink != 0 {
+		b = append(b, ";6"...)
+	}
+	if s.Extra&ExtraOverline != 0 {
+		b = append(b, ";53"...)
+	}
+	return append(b, 'm')
 }
 
-// Set the Set based on CSI parameters
-func (s *SGRState) Set(p ...int) error {
-	if len(p) == 0 {
+// Set applies the parameters of an SGR sequence, each with its colon
+// separated sub-parameters, -1 where omitted. Extended colors take their
+// components as sub-parameters (38:2::R:G:B, 38:2:R:G:B, 38:5:N) or as the
+// parameters that follow (38;2;R;G;B, 38;5;N).
+func (s *SGRState) Set(params ...[]int) error {
+	if len(params) == 0 {
 		*s = SGRState{}
 	}
-	for i := 0; i < len(p); i++ {
-		c := p[i]
-		sub := p[i:]
+	for i := 0; i < len(params); i++ {
+		p := params[i]
+		c := 0
+		if len(p) > 0 {
+			c = max(p[0], 0)
+		}
 		switch {
 		case c == 0:
 			*s = SGRState{}
 		case c == 1:
 			s.Flags |= FlagBold
-		case c == 21: // double underline?!
-		//	s.Flags &= ^FlagBold
 		case c == 2:
 			s.Flags |= FlagDim
 		case c == 22:
@@ -52,14 +133,34 @@ func (s *SGRState) Set(p ...int) error {
 			s.Flags |= FlagItalic
 		case c == 23:
 			s.Flags &= ^FlagItalic
+		case c == 4 && len(p) > 1: // 4:n underline style
+			switch style := max(p[1], 0); {
+			case style == 0:
+				s.Flags &= ^FlagUnderline
+				s.Extra &= ^ExtraUnderlineStyle
+			case style <= int(UnderlineDashed):
+				if style == 1 { // single
+					style = 0
+				}
+				s.Flags |= FlagUnderline
+				s.Extra = s.Extra&^ExtraUnderlineStyle | uint8(style)
+			}
 		case c == 4:
 			s.Flags |= FlagUnderline
+			s.Extra &= ^ExtraUnderlineStyle
+		case c == 21:
+			s.Flags |= FlagUnderline
+			s.Extra = s.Extra&^ExtraUnderlineStyle | UnderlineDouble
 		case c == 24:
 			s.Flags &= ^FlagUnderline
+			s.Extra &= ^ExtraUnderlineStyle
 		case c == 5:
 			s.Flags |= FlagBlink
+		case c == 6:
+			s.Extra |= ExtraRapidBlink
 		case c == 25:
 			s.Flags &= ^FlagBlink
+			s.Extra &= ^ExtraRapidBlink
 		case c == 7:
 			s.Flags |= FlagInverse
 		case c == 27:
@@ -72,6 +173,10 @@ func (s *SGRState) Set(p ...int) error {
 			s.Flags |= FlagStrike
 		case c == 29:
 			s.Flags &= ^FlagStrike
+		case c == 53:
+			s.Extra |= ExtraOverline
+		case c == 55:
+			s.Extra &= ^ExtraOverline
 		case c >= 90 && c <= 97: // FG bright (not bold)
 			s.ColorType = s.ColorType&0b11111100 | Color16
 			s.FG[0] = byte(c)
@@ -88,46 +193,63 @@ func (s *SGRState) Set(p ...int) error {
 			s.BG[0] = byte(c)
 		case c == 49: // BG default background
 			s.ColorType &= 0b11110011
-		// FG 256 Colors
-		case c == 38 && len(sub) >= 3 && sub[1] == 5:
-			s.ColorType = s.ColorType&0b11111100 | Color256
-			s.FG[0] = byte(sub[2])
-			i += 2
-		// BG 256 Colors
-		case c == 48 && len(sub) >= 3 && sub[1] == 5:
-			s.ColorType = s.ColorType&0b11110011 | (Color256 << 2)
-			s.BG[0] = byte(sub[2])
-			i += 2
-		// FG 16M Colors
-		case c == 38 && len(sub) >= 5 && sub[1] == 2:
-			s.ColorType = s.ColorType&0b11111100 | Color16M
-			s.FG = Color{byte(sub[2]), byte(sub[3]), byte(sub[4])}
-			i += 4
-		// BG 16M Colors
-		case c == 48 && len(sub) >= 5 && sub[1] == 2:
-			s.ColorType = s.ColorType&0b11110011 | (Color16M << 2)
-			s.BG = Color{byte(sub[2]), byte(sub[3]), byte(sub[4])}
-			i += 4
-		// XXX: Experimental
-		// underline sample
-		// \x1b[58:2::173:216:230m
-		case c == 58 && len(sub) >= 3 && sub[1] == 5:
-			s.ColorType = s.ColorType&0b11001111 | (Color256 << 4)
-			s.UL[0] = byte(sub[2])
-			i += 2
-		case c == 58 && len(sub) >= 5 && sub[1] == 2:
-			s.ColorType = s.ColorType&0b11001111 | (Color16M << 4)
-			s.UL = Color{byte(sub[2]), byte(sub[3]), byte(sub[4])}
-			i += 4
+		case c == 38 || c == 48 || c == 58: // FG, BG, underline extended colors
+			typ, col, n := extendedColor(params[i:])
+			i += n
+			if typ == 0 {
+				break
+			}
+			switch c {
+			case 38:
+				s.ColorType = s.ColorType&0b11111100 | typ
+				s.FG = col
+			case 48:
+				s.ColorType = s.ColorType&0b11110011 | typ<<2
+				s.BG = col
+			case 58:
+				s.ColorType = s.ColorType&0b11001111 | typ<<4
+				s.UL = col
+			}
 		case c == 59: // Default underline color
 			s.ColorType &= 0b11001111
-		case c == 53:
-			// TODO overline
-		case c == 55:
-			// TODO turn off overline
 		default:
 			return fmt.Errorf("unknown SGR: %v", c)
 		}
 	}
 	return nil
 }
+
+// extendedColor parses the color that params[0], 38, 48 or 58, introduces:
+// its type, Color256 or Color16M, or 0 if malformed, its value, and how many
+// of the parameters after params[0] it took.
+func extendedColor(params [][]int) (typ uint8, c Color, n int) {
+	var v []int
+	switch sub := params[0][1:]; {
+	case len(sub) == 0:
+		// the semicolon form: the components are the next parameters
+		for _, p := range params[1:min(len(params), 5)] {
+			v = append(v, p[0])
+		}
+		switch {
+		case len(v) >= 2 && v[0] == 5:
+			v, n = v[:2], 2
+		case len(v) >= 4 && v[0] == 2:
+			v, n = v[:4], 4
+		default:
+			return 0, c, 0
+		}
+	case len(sub) == 5 && sub[0] == 2:
+		// 38:2:colorspace:R:G:B
+		v = []int{sub[0], sub[2], sub[3], sub[4]}
+	default:
+		v = sub
+	}
+
+	switch {
+	case len(v) == 2 && v[0] == 5:
+		return Color256, Color{byte(max(v[1], 0))}, n
+	case len(v) == 4 && v[0] == 2:
+		return Color16M, Color{byte(max(v[1], 0)), byte(max(v[2], 0)), byte(max(v[3], 0))}, n
+	}
+	return 0, c, n
+}
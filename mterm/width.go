@@ -0,0 +1,26 @@
+package mterm
+
+import (
+	"unicode"
+
+	"golang.org/x/text/width"
+)
+
+// runeWidth returns how many columns r takes, as xterm.js counts them:
+// 2 for East Asian wide and fullwidth characters (CJK, most emoji), 0 for
+// combining marks and format characters (zero width joiner, variation
+// selectors), 1 for the rest. Ambiguous characters, and the private use
+// area of Nerd Font icons, are narrow.
+func runeWidth(r rune) int {
+	switch {
+	case r >= 0x1160 && r <= 0x11ff: // Hangul medial vowels and final consonants
+		return 0
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf):
+		return 0
+	}
+	switch width.LookupRune(r).Kind() {
+	case width.EastAsianWide, width.EastAsianFullwidth:
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,167 @@
+package mterm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRuneWidth(t *testing.T) {
+	tests := []struct {
+		r    rune
+		want int
+	}{
+		{'a', 1},
+		{'é', 1},
+		{'中', 2},
+		{'ア', 2},
+		{'Ａ', 2}, // fullwidth
+		{'😀', 2}, // emoji presentation
+		{'', 1}, // Nerd Font powerline glyph, private use
+		{'́', 0}, // combining acute accent
+		{'‍', 0}, // zero width joiner
+		{'️', 0}, // variation selector 16
+	}
+	for _, tt := range tests {
+		if got := runeWidth(tt.r); got != tt.want {
+			t.Errorf("runeWidth(%U) = %d, want %d", tt.r, got, tt.want)
+		}
+	}
+}
+
+func TestWide(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+		cursor         int // column
+	}{
+		{"two cells", "a中b", "a中b", 4},
+		{"wraps whole", "abcd中", "abcd\n中", 2},
+		{"overwrite head", "中\033[1Gx", "x", 1},
+		{"overwrite tail", "中\033[2Gx", " x", 2},
+		{"erase tail", "中文\033[2G\033[K", "", 1},
+		{"delete head", "中文\033[1G\033[P", " 文", 0},
+		{"erase chars", "中文\033[2G\033[2X", "", 1},
+	}
+	for _, tt := range tests {
+		term := New(2, 5)
+		_, _ = term.Write([]byte(tt.in))
+		if got := screenText(term); got != tt.want {
+			t.Errorf("%s: screen = %q, want %q", tt.name, got, tt.want)
+		}
+		if _, c := term.CursorPos(); c != tt.cursor {
+			t.Errorf("%s: cursor column = %d, want %d", tt.name, c, tt.cursor)
+		}
+		if i := halfWide(term.Snapshot()); i >= 0 {
+			t.Errorf("%s: cell %d is half a wide character", tt.name, i)
+		}
+	}
+}
+
+// halfWide returns the first cell of s that is half a wide character left
+// alone, or -1.
+func halfWide(s *Snapshot) int {
+	for i, c := range s.Cells {
+		col := i % s.Cols
+		if c.Width() == 2 && (col == s.Cols-1 || s.Cells[i+1].Width() != 0) ||
+			c.Width() == 0 && (col == 0 || s.Cells[i-1].Width() != 2) {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestWideAnsi(t *testing.T) {
+	term := New(2, 4)
+	_, _ = term.Write([]byte("a中b"))
+	got := string(term.GetScreenAsAnsi())
+	if !strings.Contains(got, "a中b\r\n") {
+		t.Errorf("GetScreenAsAnsi = %q, want the wide character once", got)
+	}
+
+	// replayed, it makes the same screen
+	mirror := New(2, 4)
+	_, _ = mirror.Write([]byte(got))
+	if screenText(mirror) != screenText(term) {
+		t.Errorf("replayed screen = %q, want %q", screenText(mirror), screenText(term))
+	}
+}
+
+func TestWideReflow(t *testing.T) {
+	tests := []struct {
+		name, in string
+		cols, to int
+		want     string // rows of the snapshot, scrollback included
+	}{
+		// "ab" and the first wide character don't fit in three columns
+		{"splits", "ab中文", 6, 3, "ab \r\n中 \r\n文"},
+		{"line ends wide", "7中\r\n", 4, 5, "7中  \r\n"},
+		{"line ends wide, narrower", "7中\r\nx", 4, 3, "7中\r\nx"},
+		{"wide at column 0", "中\r\n文x\r\n", 3, 2, "中\r\n文\r\nx \r\n"},
+		{"wide at the margin", "abc中\r\n", 5, 4, "abc \r\n中  \r\n"},
+	}
+	for _, tt := range tests {
+		term := New(4, tt.cols)
+		_, _ = term.Write([]byte(tt.in))
+		term.Resize(4, tt.to)
+		if got := string(term.GetScreenAsAnsi()); !strings.Contains(got, tt.want) {
+			t.Errorf("%s: snapshot = %q, want %q", tt.name, got, tt.want)
+		}
+		if i := halfWide(term.Snapshot()); i >= 0 {
+			t.Errorf("%s: cell %d is half a wide character", tt.name, i)
+		}
+		// printing over the reflowed screen is safe
+		_, _ = term.Write([]byte("\033[Hxy\033[2;1Hz"))
+	}
+}
+
+func TestWideReflowResizes(t *testing.T) {
+	term := New(5, 7)
+	for i := range 200 {
+		_, _ = term.Write([]byte("ab中\r\n文x字\r\n\033[1;1H中\033[3;2Hz中"[i%7:]))
+		term.Resize(5, 1+i%9)
+		if c := halfWide(term.Snapshot()); c >= 0 {
+			t.Fatalf("resize %d: cell %d is half a wide character", i, c)
+		}
+	}
+}
+
+func TestGraphemes(t *testing.T) {
+	tests := []struct {
+		name, in string
+		want     []string // the cells
+	}{
+		{"combining accent", "naõ é", []string{"n", "a", "õ", " ", "é"}},
+		{"precomposed", "não", []string{"n", "ã", "o"}},
+		{"variation selector", "❤️!", []string{"❤️", "!"}},
+		{"zwj sequence", "👨‍👩", []string{"👨‍", "", "👩", ""}},
+		{"mark on a wide character", "中́x", []string{"中́", "", "x"}},
+		{"mark at line start", "́a", []string{"a"}},
+	}
+	for _, tt := range tests {
+		term := New(2, 8)
+		_, _ = term.Write([]byte(tt.in))
+		s := term.Snapshot()
+		var got []string
+		for _, c := range s.Cells[:len(tt.want)] {
+			got = append(got, c.Grapheme())
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%s: cells = %q, want %q", tt.name, got, tt.want)
+		}
+
+		// the snapshot reproduces them exactly
+		mirror := New(2, 8)
+		_, _ = mirror.Write(term.GetScreenAsAnsi())
+		for i, c := range mirror.Snapshot().Cells {
+			if c.Grapheme() != s.Cells[i].Grapheme() && s.Cells[i].Char != 0 {
+				t.Errorf("%s: replayed cell %d = %q, want %q", tt.name, i, c.Grapheme(), s.Cells[i].Grapheme())
+			}
+		}
+	}
+
+	// a stack of marks is bounded
+	term := New(1, 4)
+	_, _ = term.Write([]byte("a" + strings.Repeat("́", 100)))
+	if g := term.Snapshot().Cells[0].Grapheme(); len(g) > 1+maxMarks {
+		t.Errorf("cell holds %d bytes", len(g))
+	}
+}
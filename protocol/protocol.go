@@ -61,6 +61,15 @@ func Encode(dest, src []byte, cmd byte) (int, error) {
 	return lenData + Overhead, nil
 }
 
+// Append frames src with the given command and appends the frame to dest.
+func Append(dest, src []byte, cmd byte) []byte {
+	start := len(dest)
+	dest = append(dest, cmd, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(dest[start+1:], uint32(len(src))) // #nosec G115 -- payloads are bounded by BufferSize
+	dest = append(dest, src...)
+	return binary.BigEndian.AppendUint32(dest, checksum(dest[start:]))
+}
+
 // Decode reads one frame from src into dest, returning the command byte and the
 // payload length.
 func Decode(dest, src []byte) (cmd byte, n int, err error) {
@@ -81,3 +90,64 @@ func Decode(dest, src []byte) (cmd byte, n int, err error) {
 	copy(dest, src[5:5+lenData])
 	return src[0], lenData, nil
 }
+
+// DecodeFrames decodes every frame in src (a single websocket message may
+// carry several concatenated frames) and calls fn with each command and
+// payload. The payload aliases buf and is only valid during fn. Decoding stops
+// at the first malformed frame, whose error is returned.
+func DecodeFrames(buf, src []byte, fn func(cmd byte, payload []byte)) error {
+	for len(src) > 0 {
+		cmd, n, err := Decode(buf, src)
+		if err != nil {
+			return err
+		}
+		fn(cmd, buf[:n])
+		src = src[n+Overhead:]
+	}
+	return nil
+}
+
+// Stream reassembles frames from a byte stream read in chunks that need not
+// end at frame boundaries, such as websocket messages a writer filled up to
+// BufferSize. The zero value is ready to use.
+type Stream struct {
+	buf     []byte
+	pending []byte // the start of a frame the last chunk cut off
+}
+
+// Decode decodes the frames completed by src, the next chunk of the stream,
+// and calls fn with each command and payload as DecodeFrames does. A frame
+// cut off at the end of src is kept for the next call; only a corrupt frame,
+// too long or failing its checksum, is an error.
+func (s *Stream) Decode(src []byte, fn func(cmd byte, payload []byte)) error {
+	if s.buf == nil {
+		s.buf = make([]byte, constants.BufferSize)
+	}
+	if len(s.pending) > 0 {
+		src = append(s.pending, src...)
+	}
+	s.pending = nil
+	for len(src) > 0 {
+		if incomplete(src) {
+			s.pending = append([]byte(nil), src...)
+			return nil
+		}
+		cmd, n, err := Decode(s.buf, src)
+		if err != nil {
+			return err
+		}
+		fn(cmd, s.buf[:n])
+		src = src[n+Overhead:]
+	}
+	return nil
+}
+
+// incomplete reports whether src is the start of a frame of a valid length
+// that goes on past its end.
+func incomplete(src []byte) bool {
+	if len(src) < Overhead {
+		return true
+	}
+	lenData := int(binary.BigEndian.Uint32(src[1:]))
+	return lenData <= constants.BufferSize && len(src) < lenData+Overhead
+}
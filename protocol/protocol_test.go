@@ -1,8 +1,10 @@
 package protocol
 
 import (
+	"bytes"
 	"fmt"
 	"math/rand"
+	"slices"
 	"testing"
 
 	"github.com/crgimenes/compterm/constants"
@@ -160,3 +162,97 @@ func ExampleDecode() {
 	// cmd: 01
 	// data: hello
 }
+
+func TestDecodeFrames(t *testing.T) {
+	enc := make([]byte, MaxPackageSize)
+	var msg []byte
+	for _, f := range []struct {
+		cmd     byte
+		payload string
+	}{{constants.MSG, "hello "}, {constants.RESIZE, "25:80"}, {constants.MSG, "world"}} {
+		n, err := Encode(enc, []byte(f.payload), f.cmd)
+		if err != nil {
+			t.Fatalf("encode: %v", err)
+		}
+		msg = append(msg, enc[:n]...)
+	}
+
+	var (
+		cmds []byte
+		out  []byte
+	)
+	err := DecodeFrames(make([]byte, constants.BufferSize), msg, func(cmd byte, p []byte) {
+		cmds = append(cmds, cmd)
+		if cmd == constants.MSG {
+			out = append(out, p...)
+		}
+	})
+	if err != nil {
+		t.Fatalf("DecodeFrames: %v", err)
+	}
+	if string(out) != "hello world" {
+		t.Errorf("decoded output = %q, want %q", out, "hello world")
+	}
+	if string(cmds) != string([]byte{constants.MSG, constants.RESIZE, constants.MSG}) {
+		t.Errorf("decoded commands = %v", cmds)
+	}
+
+	// a corrupt trailing frame stops decoding after the good ones
+	msg[len(msg)-1] ^= 0xff
+	cmds = cmds[:0]
+	err = DecodeFrames(make([]byte, constants.BufferSize), msg, func(cmd byte, _ []byte) {
+		cmds = append(cmds, cmd)
+	})
+	if err != ErrInvalidChecksum || len(cmds) != 2 {
+		t.Errorf("corrupt frame: err = %v after %d frames, want %v after 2", err, len(cmds), ErrInvalidChecksum)
+	}
+}
+
+func TestAppend(t *testing.T) {
+	want := make([]byte, MaxPackageSize)
+	n, err := Encode(want, []byte("hello"), 0x02)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prefix := []byte("xy")
+	got := Append(prefix, []byte("hello"), 0x02)
+	if !bytes.Equal(got[:2], prefix) || !bytes.Equal(got[2:], want[:n]) {
+		t.Fatalf("Append = %x, want %x after the prefix", got, want[:n])
+	}
+}
+
+func TestStream(t *testing.T) {
+	var msg []byte
+	msg = Append(msg, []byte("hello "), constants.MSG)
+	msg = Append(msg, []byte("25:80"), constants.RESIZE)
+	msg = Append(msg, []byte("world"), constants.MSG)
+
+	// every way of cutting the stream in two decodes the same frames
+	for cut := range len(msg) + 1 {
+		var (
+			s   Stream
+			out []byte
+		)
+		fn := func(cmd byte, p []byte) {
+			out = append(out, cmd)
+			out = append(out, p...)
+		}
+		if err := s.Decode(msg[:cut], fn); err != nil {
+			t.Fatalf("cut %d: first chunk: %v", cut, err)
+		}
+		if err := s.Decode(msg[cut:], fn); err != nil {
+			t.Fatalf("cut %d: second chunk: %v", cut, err)
+		}
+		if want := "\x01hello \x0225:80\x01world"; string(out) != want {
+			t.Errorf("cut %d: decoded %q, want %q", cut, out, want)
+		}
+	}
+
+	var s Stream
+	bad := slices.Clone(msg)
+	bad[len(bad)-1] ^= 0xff
+	if err := s.Decode(bad, func(byte, []byte) {}); err != ErrInvalidChecksum {
+		t.Errorf("corrupt frame: err = %v, want %v", err, ErrInvalidChecksum)
+	}
+}
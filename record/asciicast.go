@@ -0,0 +1,216 @@
+package record
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// asciicast is the header of an asciicast recording, newline-delimited JSON
+// in version 2: this, then one [time, code, data] line per event. Version 1
+// is a single object, with the events in Stdout as [delay, data].
+type asciicast struct {
+	Version   int                 `json:"version"`
+	Width     int                 `json:"width"`
+	Height    int                 `json:"height"`
+	Timestamp int64               `json:"timestamp,omitempty"`
+	Title     string              `json:"title,omitempty"`
+	Stdout    [][]json.RawMessage `json:"stdout,omitempty"`
+}
+
+// Read reads an asciicast recording, version 1 or 2. Event codes other than
+// output and resize (input, markers) are skipped.
+func Read(r io.Reader) (*Recording, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	first, rest, _ := bytes.Cut(data, []byte("\n"))
+	var h asciicast
+	if json.Unmarshal(first, &h) != nil || h.Version != 2 {
+		// version 1 is often indented over many lines
+		if json.Unmarshal(data, &h) != nil || h.Version != 1 {
+			return nil, ErrFormat
+		}
+	}
+	if h.Width <= 0 || h.Height <= 0 {
+		return nil, ErrFormat
+	}
+	rec := &Recording{Rows: h.Height, Cols: h.Width, Title: h.Title}
+	if h.Version == 1 {
+		return rec, readV1(rec, h.Stdout)
+	}
+	return rec, readV2(rec, rest)
+}
+
+func readV1(rec *Recording, stdout [][]json.RawMessage) error {
+	var at time.Duration
+	for i, frame := range stdout {
+		var (
+			delay float64
+			data  string
+		)
+		err := errors.New("want [delay, data]")
+		if len(frame) == 2 {
+			err = errors.Join(json.Unmarshal(frame[0], &delay), json.Unmarshal(frame[1], &data))
+		}
+		if err != nil {
+			return fmt.Errorf("record: frame %d: %w", i, err)
+		}
+		at += seconds(delay)
+		rec.Events = append(rec.Events, Event{Time: at, Code: Output, Data: []byte(data)})
+	}
+	return nil
+}
+
+func readV2(rec *Recording, lines []byte) error {
+	sc := bufio.NewScanner(bytes.NewReader(lines))
+	sc.Buffer(nil, len(lines)+1)
+	for line := 2; sc.Scan(); line++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var (
+			raw  []json.RawMessage
+			secs float64
+			code string
+			data string
+		)
+		err := json.Unmarshal(sc.Bytes(), &raw)
+		if err == nil && len(raw) != 3 {
+			err = errors.New("want [time, code, data]")
+		}
+		if err == nil {
+			err = errors.Join(json.Unmarshal(raw[0], &secs), json.Unmarshal(raw[1], &code), json.Unmarshal(raw[2], &data))
+		}
+		if err != nil {
+			return fmt.Errorf("record: line %d: %w", line, err)
+		}
+
+		ev := Event{Time: seconds(secs)}
+		switch code {
+		case "o":
+			ev.Code, ev.Data = Output, []byte(data)
+		case "r":
+			cols, rows, ok := strings.Cut(data, "x")
+			ev.Code = Resize
+			ev.Cols, _ = strconv.Atoi(cols)
+			ev.Rows, _ = strconv.Atoi(rows)
+			if !ok || ev.Rows <= 0 || ev.Cols <= 0 {
+				return fmt.Errorf("record: line %d: bad size %q", line, data)
+			}
+		default:
+			continue
+		}
+		rec.Events = append(rec.Events, ev)
+	}
+	return sc.Err()
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// Writer writes a recording in the asciicast v2 format, either as the
+// session happens (Write and Resize) or all at once (Encode). Output is only
+// written in whole UTF-8 characters, the only thing JSON strings hold: an
+// incomplete sequence at the end waits for the rest. It is safe for
+// concurrent use.
+type Writer struct {
+	mu    sync.Mutex
+	w     io.Writer
+	start time.Time
+	carry []byte
+	now   func() time.Time
+}
+
+// NewWriter starts a recording of a rows x cols terminal on w.
+func NewWriter(w io.Writer, rows, cols int) (*Writer, error) {
+	rw := &Writer{w: w, now: time.Now}
+	rw.start = rw.now()
+	err := rw.line(asciicast{Version: 2, Width: cols, Height: rows, Timestamp: rw.start.Unix()})
+	if err != nil {
+		return nil, err
+	}
+	return rw, nil
+}
+
+// Encode writes rec to w in the asciicast v2 format.
+func Encode(w io.Writer, rec *Recording) error {
+	rw := &Writer{w: w}
+	err := rw.line(asciicast{Version: 2, Width: rec.Cols, Height: rec.Rows, Title: rec.Title})
+	for _, ev := range rec.Events {
+		if err != nil {
+			return err
+		}
+		switch ev.Code {
+		case Output:
+			err = rw.output(ev.Time, ev.Data)
+		case Resize:
+			err = rw.resize(ev.Time, ev.Rows, ev.Cols)
+		}
+	}
+	return err
+}
+
+// Write records p as output.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.output(w.now().Sub(w.start), p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Resize records a change of the terminal size.
+func (w *Writer) Resize(rows, cols int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.resize(w.now().Sub(w.start), rows, cols)
+}
+
+// output writes an output event. mu must be held.
+func (w *Writer) output(at time.Duration, p []byte) error {
+	data := append(w.carry, p...)
+	n := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				n = i
+			}
+			break
+		}
+	}
+	w.carry = append([]byte(nil), data[n:]...)
+	if n == 0 {
+		return nil
+	}
+	return w.event(at, "o", string(data[:n]))
+}
+
+func (w *Writer) resize(at time.Duration, rows, cols int) error {
+	return w.event(at, "r", fmt.Sprintf("%dx%d", cols, rows))
+}
+
+func (w *Writer) event(at time.Duration, code, data string) error {
+	secs := strconv.FormatFloat(at.Seconds(), 'f', 6, 64)
+	return w.line([]any{json.Number(secs), code, data})
+}
+
+func (w *Writer) line(v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.w.Write(append(b, '\n'))
+	return err
+}
@@ -0,0 +1,111 @@
+package record
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteRead(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, 24, 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := w.start
+	w.now = func() time.Time { return clock }
+
+	clock = clock.Add(500 * time.Millisecond)
+	_, _ = w.Write([]byte("hello \xc3")) // "é" split across writes
+	clock = clock.Add(time.Second)
+	_, _ = w.Write([]byte("\xa9\r\n"))
+	_ = w.Resize(30, 100)
+
+	rec, err := Read(&buf)
+	if err != nil {
+		t.Fatalf("Read: %v\n%s", err, buf.String())
+	}
+	if rec.Rows != 24 || rec.Cols != 80 {
+		t.Fatalf("size = %dx%d, want 24x80", rec.Rows, rec.Cols)
+	}
+	want := []Event{
+		{Time: 500 * time.Millisecond, Code: Output, Data: []byte("hello ")},
+		{Time: 1500 * time.Millisecond, Code: Output, Data: []byte("é\r\n")},
+		{Time: 1500 * time.Millisecond, Code: Resize, Rows: 30, Cols: 100},
+	}
+	if len(rec.Events) != len(want) {
+		t.Fatalf("events = %+v, want %+v", rec.Events, want)
+	}
+	for i, ev := range rec.Events {
+		w := want[i]
+		if ev.Time != w.Time || ev.Code != w.Code || !bytes.Equal(ev.Data, w.Data) || ev.Rows != w.Rows || ev.Cols != w.Cols {
+			t.Errorf("event %d = %+v, want %+v", i, ev, w)
+		}
+	}
+}
+
+func TestReadAsciicast(t *testing.T) {
+	cast := `{"version": 2, "width": 40, "height": 10, "title": "demo"}
+[0.1, "o", "$ ls\r\n"]
+[0.2, "i", "ignored input"]
+
+[1.25, "m", "a marker"]
+[2, "o", "\u001b[1mdone\u001b[0m"]
+`
+	rec, err := Read(strings.NewReader(cast))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Title != "demo" || len(rec.Events) != 2 || rec.Events[1].Time != 2*time.Second ||
+		string(rec.Events[1].Data) != "\033[1mdone\033[0m" {
+		t.Fatalf("Read = %+v", rec)
+	}
+
+	v1 := `{
+  "version": 1,
+  "width": 20,
+  "height": 5,
+  "stdout": [[0.5, "a"], [0.25, "b"]]
+}`
+	rec, err = Read(strings.NewReader(v1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Cols != 20 || len(rec.Events) != 2 || rec.Events[1].Time != 750*time.Millisecond {
+		t.Fatalf("Read(v1) = %+v", rec)
+	}
+
+	for _, bad := range []string{
+		"",
+		`{"version": 3, "width": 40, "height": 10}`,
+		`{"version": 1, "width": 40, "height": 10, "stdout": [[0.5]]}`,
+		"{\"version\": 2, \"width\": 40, \"height\": 10}\n[0.1, \"o\"]\n",
+		"{\"version\": 2, \"width\": 40, \"height\": 10}\n[0.1, \"r\", \"big\"]\n",
+	} {
+		if _, err := Read(strings.NewReader(bad)); err == nil {
+			t.Errorf("Read(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	rec := &Recording{Rows: 3, Cols: 9, Events: []Event{
+		{Time: time.Second, Code: Output, Data: []byte("caf\xc3")},
+		{Time: 2 * time.Second, Code: Output, Data: []byte("\xa9")},
+		{Time: 3 * time.Second, Code: Resize, Rows: 4, Cols: 10},
+	}}
+	var buf bytes.Buffer
+	if err := Encode(&buf, rec); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the split "é" is written whole, when the rest of it comes
+	if len(got.Events) != 3 || string(got.Events[0].Data) != "caf" || string(got.Events[1].Data) != "é" ||
+		got.Events[2].Rows != 4 {
+		t.Fatalf("Encode, Read = %+v", got)
+	}
+}
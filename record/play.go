@@ -0,0 +1,45 @@
+package record
+
+import (
+	"context"
+	"time"
+)
+
+// Player is what a recording plays on, such as a compterm server.
+type Player interface {
+	Write(p []byte) (int, error)
+	Resize(rows, columns int)
+}
+
+// Play plays rec on p in real time, from its initial size, with pauses cut
+// to idleLimit (none if it is 0). It returns when the recording ends or ctx
+// is done.
+func (rec *Recording) Play(ctx context.Context, p Player, idleLimit time.Duration) error {
+	p.Resize(rec.Rows, rec.Cols)
+
+	var last time.Duration
+	for _, ev := range rec.Events {
+		wait := max(ev.Time-last, 0)
+		if idleLimit > 0 {
+			wait = min(wait, idleLimit)
+		}
+		last = ev.Time
+		if wait > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+
+		switch ev.Code {
+		case Output:
+			if _, err := p.Write(ev.Data); err != nil {
+				return err
+			}
+		case Resize:
+			p.Resize(ev.Rows, ev.Cols)
+		}
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,39 @@
+package record
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+type player struct{ log strings.Builder }
+
+func (p *player) Write(b []byte) (int, error) { p.log.Write(b); return len(b), nil }
+func (p *player) Resize(rows, cols int)       { fmt.Fprintf(&p.log, "[%dx%d]", rows, cols) }
+
+func TestPlay(t *testing.T) {
+	rec := &Recording{Rows: 2, Cols: 3, Events: []Event{
+		{Time: 0, Code: Output, Data: []byte("a")},
+		{Time: time.Hour, Code: Resize, Rows: 4, Cols: 5},
+		{Time: time.Hour, Code: Output, Data: []byte("b")},
+	}}
+	var p player
+	start := time.Now()
+	if err := rec.Play(context.Background(), &p, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.log.String(); got != "[2x3]a[4x5]b" {
+		t.Errorf("played %q", got)
+	}
+	if d := time.Since(start); d < 10*time.Millisecond || d > time.Second {
+		t.Errorf("played in %v, want the hour cut to 10ms", d)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := rec.Play(ctx, &p, 0); err == nil {
+		t.Error("Play outlived its context")
+	}
+}
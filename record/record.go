@@ -0,0 +1,71 @@
+// Package record reads and writes terminal session recordings. Sessions are
+// recorded in the asciicast v2 format; asciicast v1, ttyrec, and script(1)
+// typescript and timing files can be read too, into the same Recording.
+package record
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Event codes.
+const (
+	Output = 'o' // Data is terminal output
+	Resize = 'r' // Rows and Cols are the new size
+)
+
+// Event is one thing that happened in a session, Time after it started.
+type Event struct {
+	Time       time.Duration
+	Code       byte
+	Data       []byte
+	Rows, Cols int
+}
+
+// Recording is a recorded session: its initial size and events in time order.
+type Recording struct {
+	Rows, Cols int
+	Title      string
+	Events     []Event
+}
+
+// Size of recordings whose format does not say, such as ttyrec.
+const (
+	DefaultRows = 24
+	DefaultCols = 80
+)
+
+var ErrFormat = errors.New("record: unknown recording format")
+
+// Open reads the recording in the named file, whichever supported format it
+// is in. A script(1) typescript needs its timing file; timing is ignored for
+// the other formats.
+func Open(name, timing string) (*Recording, error) {
+	f, err := os.Open(filepath.Clean(name)) // #nosec G304 -- operator-chosen file
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	br := bufio.NewReader(f)
+	head, _ := br.Peek(len(scriptHeader))
+	switch {
+	case bytes.HasPrefix(bytes.TrimSpace(head), []byte("{")):
+		return Read(br)
+	case timing != "":
+		t, err := os.Open(filepath.Clean(timing)) // #nosec G304 -- operator-chosen file
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = t.Close() }()
+		return ReadScript(br, t)
+	case bytes.Equal(head, []byte(scriptHeader)):
+		return nil, fmt.Errorf("record: %s is a script(1) typescript: it needs its timing file", name)
+	}
+	return ReadTTYRec(br)
+}
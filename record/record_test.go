@@ -0,0 +1,135 @@
+package record
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func ttyrec(frames ...string) []byte {
+	var b []byte
+	for i, f := range frames {
+		b = binary.LittleEndian.AppendUint32(b, uint32(1700000000+i))
+		b = binary.LittleEndian.AppendUint32(b, 500000)
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(f)))
+		b = append(b, f...)
+	}
+	return b
+}
+
+func TestReadTTYRec(t *testing.T) {
+	rec, err := ReadTTYRec(bytes.NewReader(ttyrec("login: ", "guest\r\n", "Welcome")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Rows != DefaultRows || len(rec.Events) != 3 || rec.Events[2].Time != 2*time.Second ||
+		string(rec.Events[1].Data) != "guest\r\n" {
+		t.Fatalf("ReadTTYRec = %+v", rec)
+	}
+
+	// a capture cut short keeps what it has
+	b := ttyrec("one", "two")
+	rec, err = ReadTTYRec(bytes.NewReader(b[:len(b)-1]))
+	if err != nil || len(rec.Events) != 2 || string(rec.Events[1].Data) != "tw" {
+		t.Fatalf("truncated: %+v, %v", rec, err)
+	}
+	// even in the header of the next frame
+	rec, err = ReadTTYRec(bytes.NewReader(b[:len(ttyrec("one"))+5]))
+	if err != nil || len(rec.Events) != 1 || string(rec.Events[0].Data) != "one" {
+		t.Fatalf("truncated header: %+v, %v", rec, err)
+	}
+
+	for _, bad := range []string{"", "short", "not a ttyrec file, but text"} {
+		if _, err := ReadTTYRec(strings.NewReader(bad)); err == nil {
+			t.Errorf("ReadTTYRec(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestReadScript(t *testing.T) {
+	tests := []struct {
+		name, typescript, timing string
+		rows, cols               int
+		want                     []Event
+	}{
+		{
+			name:       "classic",
+			typescript: "Script started on 2024-03-01 10:00:00+00:00 [TERM=\"xterm\" TTY=\"/dev/pts/1\" COLUMNS=\"100\" LINES=\"30\"]\n$ ls\r\nfile\r\n\nScript done on 2024-03-01\n",
+			timing:     "0.5 6\n1.25 6\n",
+			rows:       30, cols: 100,
+			want: []Event{
+				{Time: 500 * time.Millisecond, Code: Output, Data: []byte("$ ls\r\n")},
+				{Time: 1750 * time.Millisecond, Code: Output, Data: []byte("file\r\n")},
+			},
+		},
+		{
+			name:       "advanced",
+			typescript: "$ ls\r\nfile\r\n",
+			timing:     "H 0.000000 COLUMNS 120\nH 0.000000 LINES 40\nO 0.5 6\nI 0.5 3\nS 0.25 SIGWINCH ROWS=20 COLS=60\nO 0.25 6\n",
+			rows:       40, cols: 120,
+			want: []Event{
+				{Time: 500 * time.Millisecond, Code: Output, Data: []byte("$ ls\r\n")},
+				{Time: 1250 * time.Millisecond, Code: Resize, Rows: 20, Cols: 60},
+				{Time: 1500 * time.Millisecond, Code: Output, Data: []byte("file\r\n")},
+			},
+		},
+	}
+	for _, tt := range tests {
+		rec, err := ReadScript(strings.NewReader(tt.typescript), strings.NewReader(tt.timing))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if rec.Rows != tt.rows || rec.Cols != tt.cols {
+			t.Errorf("%s: size = %dx%d, want %dx%d", tt.name, rec.Rows, rec.Cols, tt.rows, tt.cols)
+		}
+		if len(rec.Events) != len(tt.want) {
+			t.Fatalf("%s: events = %+v, want %+v", tt.name, rec.Events, tt.want)
+		}
+		for i, ev := range rec.Events {
+			w := tt.want[i]
+			if ev.Time != w.Time || ev.Code != w.Code || !bytes.Equal(ev.Data, w.Data) || ev.Rows != w.Rows || ev.Cols != w.Cols {
+				t.Errorf("%s: event %d = %+v, want %+v", tt.name, i, ev, w)
+			}
+		}
+	}
+
+	if _, err := ReadScript(strings.NewReader("x"), strings.NewReader("soon 1\n")); err == nil {
+		t.Error("ReadScript accepted a bad timing file")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	cast := write("a.cast", "{\"version\": 2, \"width\": 10, \"height\": 2}\n[0.1, \"o\", \"cast\"]\n")
+	tty := write("a.ttyrec", string(ttyrec("ttyrec")))
+	script := write("typescript", "Script started on today\nscript")
+	timing := write("timing", "0.1 6\n")
+
+	for _, tt := range []struct{ name, timing, want string }{
+		{cast, "", "cast"},
+		{tty, "", "ttyrec"},
+		{script, timing, "script"},
+	} {
+		rec, err := Open(tt.name, tt.timing)
+		if err != nil {
+			t.Fatalf("Open(%s): %v", tt.name, err)
+		}
+		if len(rec.Events) != 1 || string(rec.Events[0].Data) != tt.want {
+			t.Errorf("Open(%s) = %+v", tt.name, rec)
+		}
+	}
+	if _, err := Open(script, ""); err == nil {
+		t.Error("Open read a typescript without its timing")
+	}
+}
@@ -0,0 +1,120 @@
+package record
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scriptHeader starts the line script(1) writes at the top of a typescript.
+const scriptHeader = "Script started on "
+
+// ReadScript reads a session recorded by script(1) with timing: the
+// typescript, its output, and the timing file, which says when each piece of
+// it was written. Both timing formats are read: the classic lines of delay
+// and byte count, and util-linux's advanced one, with a type first (O for
+// output, I for input, H for header, S for signal), where the input is taken
+// to be in a log of its own. The size comes from the advanced header or the
+// typescript's first line, and resizes from SIGWINCH entries.
+func ReadScript(typescript, timing io.Reader) (*Recording, error) {
+	out := bufio.NewReader(typescript)
+	rec := &Recording{Rows: DefaultRows, Cols: DefaultCols}
+	if head, _ := out.Peek(len(scriptHeader)); bytes.Equal(head, []byte(scriptHeader)) {
+		line, err := out.ReadString('\n')
+		if err != nil {
+			return nil, ErrFormat
+		}
+		rec.Rows = scriptInt(line, `LINES="`, '"', rec.Rows)
+		rec.Cols = scriptInt(line, `COLUMNS="`, '"', rec.Cols)
+	}
+
+	sc := bufio.NewScanner(timing)
+	var at time.Duration
+	for line := 1; sc.Scan(); line++ {
+		f := strings.Fields(sc.Text())
+		if len(f) == 0 {
+			continue
+		}
+		typ := "O"
+		if len(f[0]) == 1 && f[0][0] >= 'A' && f[0][0] <= 'Z' {
+			typ, f = f[0], f[1:]
+		}
+		if len(f) < 2 {
+			return nil, fmt.Errorf("record: timing line %d: %q", line, sc.Text())
+		}
+		delay, err := strconv.ParseFloat(f[0], 64)
+		if err != nil || delay < 0 {
+			return nil, fmt.Errorf("record: timing line %d: bad delay %q", line, f[0])
+		}
+		at += seconds(delay)
+
+		switch typ {
+		case "O":
+			n, err := strconv.Atoi(f[1])
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("record: timing line %d: bad length %q", line, f[1])
+			}
+			data := make([]byte, n)
+			m, err := io.ReadFull(out, data)
+			if m > 0 {
+				rec.Events = append(rec.Events, Event{Time: at, Code: Output, Data: data[:m]})
+			}
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return rec, nil // a typescript cut short
+			}
+			if err != nil {
+				return nil, err
+			}
+		case "H":
+			if len(f) < 3 {
+				continue
+			}
+			if n, err := strconv.Atoi(f[2]); err == nil && n > 0 {
+				switch f[1] {
+				case "LINES":
+					rec.Rows = n
+				case "COLUMNS":
+					rec.Cols = n
+				}
+			}
+		case "S":
+			if f[1] != "SIGWINCH" || len(f) < 4 {
+				continue
+			}
+			rows := scriptInt(f[2], "ROWS=", 0, 0)
+			cols := scriptInt(f[3], "COLS=", 0, 0)
+			if rows > 0 && cols > 0 {
+				rec.Events = append(rec.Events, Event{Time: at, Code: Resize, Rows: rows, Cols: cols})
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(rec.Events) == 0 {
+		return nil, ErrFormat
+	}
+	return rec, nil
+}
+
+// scriptInt returns the number after key in s, up to end (or the end of s if
+// end is 0), or fallback if there is none.
+func scriptInt(s, key string, end byte, fallback int) int {
+	_, v, ok := strings.Cut(s, key)
+	if !ok {
+		return fallback
+	}
+	if end != 0 {
+		v, _, _ = strings.Cut(v, string(end))
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return fallback
+	}
+	return n
+}
@@ -0,0 +1,56 @@
+package record
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+// maxTTYRecFrame bounds a ttyrec frame, so that something else mistaken for
+// one fails rather than allocates.
+const maxTTYRecFrame = 16 << 20
+
+// ReadTTYRec reads a ttyrec recording: frames of output, each after a header
+// of its time (seconds and microseconds) and length, as 32-bit little-endian
+// integers. ttyrec has no terminal size, so the recording gets the default. A
+// truncated last frame keeps what is there.
+func ReadTTYRec(r io.Reader) (*Recording, error) {
+	rec := &Recording{Rows: DefaultRows, Cols: DefaultCols}
+	var (
+		h     [12]byte
+		start time.Duration
+	)
+	for {
+		if _, err := io.ReadFull(r, h[:]); err != nil {
+			switch {
+			case !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF):
+				return nil, err
+			case len(rec.Events) == 0:
+				return nil, ErrFormat
+			}
+			// the end, or a last header cut short
+			return rec, nil
+		}
+		sec := binary.LittleEndian.Uint32(h[0:])
+		usec := binary.LittleEndian.Uint32(h[4:])
+		n := binary.LittleEndian.Uint32(h[8:])
+		if usec >= 1e6 || n > maxTTYRecFrame {
+			return nil, ErrFormat
+		}
+
+		at := time.Duration(sec)*time.Second + time.Duration(usec)*time.Microsecond
+		if len(rec.Events) == 0 {
+			start = at
+		}
+		data := make([]byte, n)
+		m, err := io.ReadFull(r, data)
+		rec.Events = append(rec.Events, Event{Time: at - start, Code: Output, Data: data[:m]})
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return rec, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
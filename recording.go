@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/crgimenes/compterm/assets"
+	"github.com/crgimenes/compterm/config"
+	"github.com/crgimenes/compterm/record"
+	"github.com/crgimenes/compterm/render"
+)
+
+// recorder records the shared session when -record is set.
+var recorder *record.Writer
+
+// startRecording opens the -record file and starts recording the session at
+// the terminal's current size.
+func startRecording() {
+	name := config.CFG.Record
+	f, err := os.OpenFile(filepath.Clean(name), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		log.Fatalf("error creating recording: %s %s\n", name, err)
+	}
+	rows, columns := terminalSize()
+	recorder, err = record.NewWriter(f, rows, columns)
+	if err != nil {
+		log.Fatalf("error writing recording: %s %s\n", name, err)
+	}
+	log.Printf("recording to %s\n", name)
+}
+
+// terminalOutput is where the pty's output goes besides the broadcast: the
+// host's terminal, and the recording if there is one.
+func terminalOutput() io.Writer {
+	if recorder == nil {
+		return os.Stdout
+	}
+	return io.MultiWriter(os.Stdout, recorder)
+}
+
+// runRender animates a recording (compterm render session.cast -o out.gif),
+// drawn like screenshots: with the viewer's font, in its theme. To a .cast
+// file, it converts the recording to asciicast v2 instead.
+func runRender() {
+	cfg := config.CFG
+	if err := renderFile(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "compterm render: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func renderFile(cfg *config.Config) (err error) {
+	rec, err := record.Open(cfg.Input, cfg.Timing)
+	if err != nil {
+		return err
+	}
+	out, err := os.Create(filepath.Clean(cfg.Output))
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, out.Close()) }()
+
+	ext := strings.ToLower(filepath.Ext(cfg.Output))
+	if ext == ".cast" {
+		return record.Encode(out, rec)
+	}
+
+	data, err := assets.ReadFile(assets.FontFile)
+	if err != nil {
+		return err
+	}
+	f, err := render.ParseFont(data)
+	if err != nil {
+		return err
+	}
+	theme := &render.DefaultTheme
+	if data, err := os.ReadFile(filepath.Join(cfg.Path, "theme.json")); err == nil { // #nosec G304 -- operator-controlled config dir
+		if t, err := render.ParseTheme(data); err == nil {
+			theme = t
+		}
+	}
+	opts := &render.AnimationOptions{
+		Theme:     theme,
+		Font:      f,
+		Size:      cfg.FontSize,
+		FPS:       cfg.FPS,
+		IdleLimit: cfg.IdleLimit,
+	}
+	if ext == ".gif" {
+		return render.GIF(out, rec, opts)
+	}
+	return render.APNG(out, rec, opts)
+}
+
+// runReplay serves a recording as if it were a live session (compterm replay
+// old.ttyrec), once or, with -loop, over and over. Like a relay, it has no
+// terminal of its own and logs to stderr.
+func runReplay() {
+	cfg := config.CFG
+	rec, err := record.Open(cfg.Input, cfg.Timing)
+	if err != nil {
+		log.Fatalf("error reading recording: %s\n", err)
+	}
+
+	srv = newServer(cfg)
+	go serveHTTP(srv.Handler())
+
+	for {
+		log.Printf("replaying %s\n", cfg.Input)
+		err := rec.Play(context.Background(), srv, cfg.IdleLimit)
+		if err != nil {
+			log.Fatalf("error replaying: %s\n", err)
+		}
+		if !cfg.Loop {
+			break
+		}
+		time.Sleep(max(cfg.IdleLimit, time.Second))
+		_, _ = srv.Write([]byte(resetScreen))
+	}
+	log.Println("replay done; serving the last screen")
+	select {}
+}
+
+// resetScreen clears the screen and attributes between runs of a looped
+// replay.
+const resetScreen = "\033[0m\033[H\033[2J"
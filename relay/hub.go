@@ -0,0 +1,213 @@
+package relay
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/coder/websocket"
+
+	"github.com/crgimenes/compterm/constants"
+	"github.com/crgimenes/compterm/protocol"
+	"github.com/crgimenes/compterm/server"
+)
+
+var errPushClosed = errors.New("hub closed the connection")
+
+// validName restricts session names to what is safe in a URL path segment and
+// a cookie name.
+var validName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// HubOptions configures a Hub.
+type HubOptions struct {
+	// PushToken authenticates pushing hosts; empty accepts any host.
+	PushToken string
+	// Server is the template for every session's server: viewer tokens,
+	// allowed origins, theme, and hooks. CookieName is derived per session.
+	Server server.Options
+}
+
+// Hub accepts sessions pushed by hosts behind NAT and serves each one to
+// viewers under its name:
+//
+//	/push/<name>  websocket a host pushes its framed stream to
+//	/s/<name>/    the session's web viewer, /ws, and the rest of server.Server
+type Hub struct {
+	opts HubOptions
+	mux  *http.ServeMux
+
+	mx       sync.Mutex
+	sessions map[string]*hubSession
+}
+
+type hubSession struct {
+	srv    *server.Server
+	pushed bool // a host is connected
+}
+
+// NewHub returns a Hub configured by opts.
+func NewHub(opts HubOptions) *Hub {
+	h := &Hub{
+		opts:     opts,
+		sessions: make(map[string]*hubSession),
+	}
+	h.mux = http.NewServeMux()
+	h.mux.HandleFunc("/push/{name}", h.pushHandler)
+	h.mux.HandleFunc("/s/{name}/", h.viewHandler)
+	h.mux.HandleFunc("/s/{name}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", r.PathValue("name")+"/")
+		w.WriteHeader(http.StatusMovedPermanently)
+	})
+	return h
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// Close stops every session's server.
+func (h *Hub) Close() {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	for _, s := range h.sessions {
+		s.srv.Close()
+	}
+}
+
+// claim marks name as pushed, creating its session on first use. It fails when
+// another host is already pushing under that name.
+func (h *Hub) claim(name string) (*server.Server, bool) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	s, ok := h.sessions[name]
+	if !ok {
+		opts := h.opts.Server
+		opts.CookieName = "compterm-" + name
+		s = &hubSession{srv: server.New(opts)}
+		h.sessions[name] = s
+	}
+	if s.pushed {
+		return nil, false
+	}
+	s.pushed = true
+	return s.srv, true
+}
+
+func (h *Hub) release(name string) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	if s, ok := h.sessions[name]; ok {
+		s.pushed = false
+	}
+}
+
+func (h *Hub) lookup(name string) *server.Server {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	if s, ok := h.sessions[name]; ok {
+		return s.srv
+	}
+	return nil
+}
+
+func (h *Hub) authorizePush(r *http.Request) bool {
+	if h.opts.PushToken == "" {
+		return true
+	}
+	token := r.Header.Get("X-Auth-Token")
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	return token != "" &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(h.opts.PushToken)) == 1
+}
+
+func (h *Hub) pushHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !validName.MatchString(name) {
+		http.Error(w, "invalid session name", http.StatusBadRequest)
+		return
+	}
+	if !h.authorizePush(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	srv, ok := h.claim(name)
+	if !ok {
+		http.Error(w, "session already pushed", http.StatusConflict)
+		return
+	}
+	defer h.release(name)
+
+	ws, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer func() { _ = ws.CloseNow() }()
+	ws.SetReadLimit(-1)
+
+	log.Printf("session %q: host connected from %s\n", name, r.RemoteAddr)
+	err = receive(r.Context(), srv, ws)
+	log.Printf("session %q: host disconnected: %v\n", name, err)
+}
+
+// receive applies a pushed stream to srv until the connection fails. The host
+// writes the stream in chunks, so a frame may span messages.
+func receive(ctx context.Context, srv *server.Server, ws *websocket.Conn) error {
+	var stream protocol.Stream
+	for {
+		_, data, err := ws.Read(ctx)
+		if err != nil {
+			return err
+		}
+		err = stream.Decode(data, func(cmd byte, payload []byte) {
+			switch cmd {
+			case constants.MSG:
+				_, _ = srv.Write(payload)
+			case constants.RESIZE:
+				if rows, columns, ok := parseSize(payload); ok {
+					srv.Resize(rows, columns)
+				}
+			case constants.ENC:
+				srv.Forward(payload)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (h *Hub) viewHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	srv := h.lookup(name)
+	if srv == nil {
+		http.NotFound(w, r)
+		return
+	}
+	http.StripPrefix("/s/"+name, srv.Handler()).ServeHTTP(w, r)
+}
+
+// parseSize parses a RESIZE payload, "rows:columns".
+func parseSize(p []byte) (rows, columns int, ok bool) {
+	r, c, found := strings.Cut(string(p), ":")
+	if !found {
+		return 0, 0, false
+	}
+	rows, err1 := strconv.Atoi(r)
+	columns, err2 := strconv.Atoi(c)
+	if err1 != nil || err2 != nil || rows <= 0 || columns <= 0 {
+		return 0, 0, false
+	}
+	return rows, columns, true
+}
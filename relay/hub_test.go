@@ -0,0 +1,152 @@
+package relay
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+
+	"github.com/crgimenes/compterm/client"
+	"github.com/crgimenes/compterm/constants"
+	"github.com/crgimenes/compterm/protocol"
+	"github.com/crgimenes/compterm/server"
+)
+
+func pushURL(hub *httptest.Server, name string) string {
+	return "ws" + strings.TrimPrefix(hub.URL, "http") + "/push/" + name
+}
+
+// TestHub pushes a session from a host to a hub on localhost and watches it
+// through the hub under its name.
+func TestHub(t *testing.T) {
+	hub := NewHub(HubOptions{
+		PushToken: "push-s3cr3t",
+		Server:    server.Options{AuthToken: "view-s3cr3t"},
+	})
+	defer hub.Close()
+	hubHTTP := httptest.NewServer(hub)
+	defer hubHTTP.Close()
+
+	host := server.New(server.Options{Rows: 10, Columns: 40})
+	defer host.Close()
+	_, _ = host.Write([]byte("before the push"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := Push(ctx, host, pushURL(hubHTTP, "demo"), "wrong"); err == nil {
+		t.Fatal("Push with a wrong token succeeded")
+	}
+	if err := Push(ctx, host, pushURL(hubHTTP, "bad.name"), "push-s3cr3t"); err == nil {
+		t.Fatal("Push with an invalid name succeeded")
+	}
+
+	go func() { _ = Push(ctx, host, pushURL(hubHTTP, "demo"), "push-s3cr3t") }()
+
+	viewURL := "ws" + strings.TrimPrefix(hubHTTP.URL, "http") + "/s/demo/ws"
+	var viewer *client.Conn
+	for viewer == nil {
+		var err error
+		viewer, err = client.Dial(ctx, viewURL, &client.Options{Token: "view-s3cr3t"})
+		if err != nil {
+			if ctx.Err() != nil {
+				t.Fatalf("dial hub session: %v", err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	defer viewer.Close()
+
+	// a second host may not take over a name that is being pushed
+	if err := Push(ctx, host, pushURL(hubHTTP, "demo"), "push-s3cr3t"); err == nil {
+		t.Fatal("second Push to an active name succeeded")
+	}
+
+	_, _ = host.Write([]byte("after the push"))
+
+	var out strings.Builder
+	for !strings.Contains(out.String(), "after the push") {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("hub viewer timed out: output=%q", out.String())
+		case ev := <-viewer.Events():
+			if ev.Type == client.Message {
+				out.Write(ev.Data)
+			}
+		}
+	}
+	if !strings.Contains(out.String(), "before the push") {
+		t.Fatalf("hub viewer output = %q, want the host's snapshot", out.String())
+	}
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/s/nobody/", http.StatusNotFound},
+		{"/s/demo", http.StatusMovedPermanently},
+		{"/s/demo/ws", http.StatusUnauthorized},
+	}
+	noRedirect := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	for _, tt := range tests {
+		resp, err := noRedirect.Get(hubHTTP.URL + tt.path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", tt.path, err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("GET %s = %d, want %d", tt.path, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+// TestReceiveSplitFrame feeds the hub a frame split across two websocket
+// messages, as the host's chunked writes may.
+func TestReceiveSplitFrame(t *testing.T) {
+	srv := server.New(server.Options{Rows: 5, Columns: 20})
+	defer srv.Close()
+
+	done := make(chan error, 1)
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			done <- err
+			return
+		}
+		done <- receive(r.Context(), srv, ws)
+	}))
+	defer hub.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ws, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(hub.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer func() { _ = ws.CloseNow() }()
+
+	frame := protocol.Append(nil, []byte("7:30"), constants.RESIZE)
+	for _, part := range [][]byte{frame[:6], frame[6:]} {
+		if err := ws.Write(ctx, websocket.MessageBinary, part); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	for {
+		if snap := srv.Screen().Snapshot(); snap.Rows == 7 && snap.Cols == 30 {
+			break
+		}
+		select {
+		case err := <-done:
+			t.Fatalf("receive ended: %v", err)
+		case <-ctx.Done():
+			t.Fatal("the split RESIZE frame was not applied")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package relay
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/coder/websocket"
+
+	"github.com/crgimenes/compterm/screen"
+	"github.com/crgimenes/compterm/server"
+)
+
+const (
+	pushMinBackoff = 500 * time.Millisecond
+	pushMaxBackoff = 30 * time.Second
+)
+
+// Push streams srv's session to a hub at target (its /push/<name> URL) over an
+// outbound websocket, for hosts that cannot accept connections. The hub is fed
+// exactly like a viewer: a snapshot on every (re)connection, then the live
+// frames. Drops are retried with backoff until ctx is done. Push returns an
+// error only when the first connection fails.
+func Push(ctx context.Context, srv *server.Server, target, token string) error {
+	header := http.Header{}
+	if token != "" {
+		header.Set("X-Auth-Token", token)
+	}
+
+	first := true
+	backoff := pushMinBackoff
+	for {
+		ws, _, err := websocket.Dial(ctx, target, &websocket.DialOptions{HTTPHeader: header})
+		switch {
+		case err == nil:
+			first = false
+			backoff = pushMinBackoff
+			err = pushTo(ctx, srv, ws)
+		case first:
+			return err
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		log.Printf("push to %s: %v; retrying in %v\n", target, err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, pushMaxBackoff)
+	}
+}
+
+// pushTo attaches the hub connection to the screen as a viewer and waits for
+// it to end.
+func pushTo(ctx context.Context, srv *server.Server, ws *websocket.Conn) error {
+	c := screen.NewClient(ws)
+	c.SessionID = "push"
+	srv.Screen().AttachClient(c)
+
+	select {
+	case <-c.Done():
+		return errPushClosed
+	case <-ctx.Done():
+		c.Close()
+		return nil
+	}
+}
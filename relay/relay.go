@@ -0,0 +1,53 @@
+// Package relay fans a compterm session out to more viewers. A relay watches
+// an upstream compterm as an ordinary viewer and re-serves what it receives
+// from its own server.Server, so the host uploads one stream per relay instead
+// of one per viewer. An end-to-end encrypted session is forwarded as is: the
+// relay never holds the key, and its viewers start at the host's keyframes.
+package relay
+
+import (
+	"context"
+
+	"github.com/crgimenes/compterm/client"
+	"github.com/crgimenes/compterm/server"
+)
+
+// Run mirrors the session at upstream (the origin's websocket URL) into srv
+// until ctx is done. Output feeds srv's screen, and so its emulator and
+// viewers; resize events resize it. Drops are retried with backoff and resumed
+// where possible. Run returns an error only when the first connection fails.
+func Run(ctx context.Context, srv *server.Server, upstream, token string) error {
+	conn, err := client.Dial(ctx, upstream, &client.Options{Token: token})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	events := conn.Events()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-events:
+			if !ok {
+				return nil
+			}
+			apply(srv, ev)
+		}
+	}
+}
+
+// apply relays one upstream event to the local server.
+func apply(srv *server.Server, ev client.Event) {
+	switch ev.Type {
+	case client.Message:
+		// a fresh upstream snapshot is ordinary output here: it redraws the
+		// whole screen, and the local viewers receive it as a delta
+		_, _ = srv.Write(ev.Data)
+	case client.Resize:
+		srv.Resize(ev.Rows, ev.Columns)
+	case client.Sealed:
+		// an end-to-end encrypted upstream: pass it on unread
+		srv.Forward(ev.Data)
+	}
+}
@@ -0,0 +1,203 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crgimenes/compterm/client"
+	"github.com/crgimenes/compterm/e2e"
+	"github.com/crgimenes/compterm/server"
+)
+
+func wsURL(srv *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+}
+
+// TestRelay chains origin -> relay -> viewer and checks output and resize
+// events reach the viewer through the relay, with the upstream token passed
+// along.
+func TestRelay(t *testing.T) {
+	origin := server.New(server.Options{Rows: 10, Columns: 40, AuthToken: "s3cr3t"})
+	defer origin.Close()
+	originHTTP := httptest.NewServer(origin.Handler())
+	defer originHTTP.Close()
+
+	edge := server.New(server.Options{Rows: 10, Columns: 40, AuthToken: "s3cr3t"})
+	defer edge.Close()
+	edgeHTTP := httptest.NewServer(edge.Handler())
+	defer edgeHTTP.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := Run(ctx, edge, wsURL(originHTTP), "wrong"); err == nil {
+		t.Fatal("Run with a wrong upstream token succeeded")
+	}
+
+	go func() { _ = Run(ctx, edge, wsURL(originHTTP), "s3cr3t") }()
+
+	viewer, err := client.Dial(ctx, wsURL(edgeHTTP), &client.Options{Token: "s3cr3t", Mirror: true})
+	if err != nil {
+		t.Fatalf("dial relay: %v", err)
+	}
+	defer viewer.Close()
+
+	_, _ = origin.Write([]byte("hello through the relay"))
+	origin.Resize(12, 50)
+
+	var out strings.Builder
+	resized := false
+	for !resized || !strings.Contains(out.String(), "hello through the relay") {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("relay viewer timed out: resized=%v output=%q", resized, out.String())
+		case ev := <-viewer.Events():
+			switch ev.Type {
+			case client.Message:
+				out.Write(ev.Data)
+			case client.Resize:
+				resized = resized || (ev.Rows == 12 && ev.Columns == 50)
+			}
+		}
+	}
+
+	if got := string(edge.Screen().GetScreenAsANSI()); !strings.Contains(got, "hello through the relay") {
+		t.Fatalf("relay screen = %q, want the origin output", got)
+	}
+}
+
+// TestRelayLargeOutput relays a snapshot and output that run to several
+// messages both upstream and downstream, so that frames span them.
+func TestRelayLargeOutput(t *testing.T) {
+	origin := server.New(server.Options{Rows: 50, Columns: 200})
+	defer origin.Close()
+	originHTTP := httptest.NewServer(origin.Handler())
+	defer originHTTP.Close()
+
+	edge := server.New(server.Options{Rows: 50, Columns: 200})
+	defer edge.Close()
+	edgeHTTP := httptest.NewServer(edge.Handler())
+	defer edgeHTTP.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var history strings.Builder
+	for i := range 1100 {
+		for j := range 20 {
+			fmt.Fprintf(&history, "\033[38;2;%d;%d;%dm%d", i%256, j*10, 255-i%256, j)
+		}
+		fmt.Fprintf(&history, "\033[0m history %d\r\n", i)
+	}
+	_, _ = origin.Write([]byte(history.String()))
+
+	go func() { _ = Run(ctx, edge, wsURL(originHTTP), "") }()
+
+	viewer, err := client.Dial(ctx, wsURL(edgeHTTP), nil)
+	if err != nil {
+		t.Fatalf("dial relay: %v", err)
+	}
+	defer viewer.Close()
+
+	// until reads the viewer's output up to want
+	var out strings.Builder
+	until := func(want string) {
+		t.Helper()
+		for !strings.Contains(out.String(), want) {
+			select {
+			case <-ctx.Done():
+				t.Fatalf("relay viewer timed out waiting for %q after %d bytes", want, out.Len())
+			case ev := <-viewer.Events():
+				if ev.Type == client.Message {
+					out.Write(ev.Data)
+				}
+			}
+		}
+	}
+
+	// the end of the origin's snapshot reaches the viewer, then its output
+	// as written
+	until("history 1099")
+	var large strings.Builder
+	for i := range 12000 {
+		fmt.Fprintf(&large, "line %d of the large output\r\n", i)
+	}
+	large.WriteString("end")
+	_, _ = origin.Write([]byte(large.String()))
+	until("end")
+
+	if !strings.Contains(out.String(), large.String()) {
+		t.Fatal("relay viewer output is missing part of the origin's output")
+	}
+}
+
+// TestRelayEncrypted relays an end-to-end encrypted session: a viewer with the
+// key reads it through the relay, which only ever holds ciphertext.
+func TestRelayEncrypted(t *testing.T) {
+	key, err := e2e.ParseKey(e2e.NewKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	origin := server.New(server.Options{Rows: 10, Columns: 40, Key: key})
+	defer origin.Close()
+	originHTTP := httptest.NewServer(origin.Handler())
+	defer originHTTP.Close()
+	_, _ = origin.Write([]byte("sealed snapshot"))
+
+	edge := server.New(server.Options{Rows: 10, Columns: 40})
+	defer edge.Close()
+	edgeHTTP := httptest.NewServer(edge.Handler())
+	defer edgeHTTP.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go func() { _ = Run(ctx, edge, wsURL(originHTTP), "") }()
+
+	// the relay has the origin's keyframe once a keyless viewer sees it sealed
+	blind, err := client.Dial(ctx, wsURL(edgeHTTP), nil)
+	if err != nil {
+		t.Fatalf("dial relay: %v", err)
+	}
+	defer blind.Close()
+	for sealed := false; !sealed; {
+		select {
+		case <-ctx.Done():
+			t.Fatal("keyless viewer never saw a sealed frame")
+		case ev := <-blind.Events():
+			sealed = ev.Type == client.Sealed
+		}
+	}
+
+	viewer, err := client.Dial(ctx, wsURL(edgeHTTP), &client.Options{Key: key, Mirror: true})
+	if err != nil {
+		t.Fatalf("dial relay: %v", err)
+	}
+	defer viewer.Close()
+
+	_, _ = origin.Write([]byte("sealed live"))
+
+	var out strings.Builder
+	for !strings.Contains(out.String(), "sealed live") {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("relay viewer timed out: output=%q", out.String())
+		case ev := <-viewer.Events():
+			if ev.Type == client.Message {
+				out.Write(ev.Data)
+			}
+		}
+	}
+	if !strings.Contains(out.String(), "sealed snapshot") {
+		t.Fatalf("relay viewer output = %q, want the host's keyframe first", out.String())
+	}
+
+	if got := string(edge.Screen().GetScreenAsANSI()); strings.Contains(got, "sealed") {
+		t.Fatalf("relay screen = %q, want no plaintext", got)
+	}
+}
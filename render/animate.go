@@ -0,0 +1,326 @@
+package render
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/crgimenes/compterm/mterm"
+	"github.com/crgimenes/compterm/record"
+)
+
+// Defaults and limits of AnimationOptions.
+const (
+	DefaultFPS       = 10
+	MaxFPS           = 50 // browsers slow down GIF frames shorter than 20 ms
+	DefaultIdleLimit = 2 * time.Second
+)
+
+// AnimationOptions set how a recording is animated.
+type AnimationOptions struct {
+	Theme *Theme
+	Font  *Font
+	Size  float64 // font size in pixels
+
+	// FPS is the most frames per second: output closer together is shown
+	// at once. Zero means DefaultFPS.
+	FPS int
+	// IdleLimit is the longest pause kept, between output and after the last
+	// of it. Zero means DefaultIdleLimit.
+	IdleLimit time.Duration
+}
+
+// animate replays rec through a terminal and calls fn with each frame that
+// differs from the one before, the region that changed, and when to show it.
+// It returns when the animation ends. The canvas fits the largest size the
+// recording has; fn must not keep img, which is reused.
+func animate(rec *record.Recording, opts *AnimationOptions, fn func(img *image.RGBA, changed image.Rectangle, at time.Duration) error) (time.Duration, error) {
+	fps := min(cmp.Or(opts.FPS, DefaultFPS), MaxFPS)
+	step := time.Second / time.Duration(max(fps, 1))
+	idle := cmp.Or(opts.IdleLimit, DefaultIdleLimit)
+
+	p, err := newPainter(opts.Theme, opts.Font, opts.Size)
+	if err != nil {
+		return 0, err
+	}
+	defer p.close()
+
+	rows, cols := rec.Rows, rec.Cols
+	for _, ev := range rec.Events {
+		if ev.Code == record.Resize {
+			rows, cols = max(rows, ev.Rows), max(cols, ev.Cols)
+		}
+	}
+	bounds := image.Rect(0, 0, cols*p.cw, rows*p.ch)
+	shown, next := image.NewRGBA(bounds), image.NewRGBA(bounds)
+	bg := image.NewUniform(opts.Theme.Background)
+
+	term := mterm.New(rec.Rows, rec.Cols)
+	first := true
+	flush := func(at time.Duration) error {
+		draw.Draw(next, bounds, bg, image.Point{}, draw.Src)
+		img := p.draw(term.Snapshot())
+		draw.Draw(next, img.Bounds(), img, image.Point{}, draw.Src)
+
+		changed := bounds
+		if !first {
+			changed = changedRect(shown, next)
+			if changed.Empty() {
+				return nil
+			}
+		}
+		first = false
+		shown, next = next, shown
+		return fn(shown, changed, at)
+	}
+
+	// clock is the time with pauses cut to the idle limit; a frame shows the
+	// output from pending for a step.
+	var clock, last, pending time.Duration
+	dirty := false
+	for i, ev := range rec.Events {
+		if i > 0 {
+			clock += min(max(ev.Time-last, 0), idle)
+		}
+		last = ev.Time
+
+		if dirty && clock >= pending+step {
+			if err := flush(pending); err != nil {
+				return 0, err
+			}
+			dirty = false
+		}
+		if !dirty {
+			pending, dirty = clock, true
+		}
+
+		switch ev.Code {
+		case record.Output:
+			_, _ = term.Write(ev.Data) // a bad escape sequence is dropped, as on screen
+		case record.Resize:
+			term.Resize(ev.Rows, ev.Cols)
+		}
+	}
+	if err := flush(pending); err != nil {
+		return 0, err
+	}
+	return max(clock, pending) + idle, nil
+}
+
+// changedRect returns the smallest rectangle holding every pixel that differs
+// between a and b, which have the same bounds.
+func changedRect(a, b *image.RGBA) image.Rectangle {
+	var r image.Rectangle
+	w := a.Rect.Dx() * 4
+	for y := range a.Rect.Dy() {
+		ra := a.Pix[y*a.Stride : y*a.Stride+w]
+		rb := b.Pix[y*b.Stride : y*b.Stride+w]
+		if bytes.Equal(ra, rb) {
+			continue
+		}
+		x0 := 0
+		for ; ra[x0] == rb[x0]; x0++ {
+		}
+		x1 := w
+		for ; ra[x1-1] == rb[x1-1]; x1-- {
+		}
+		r = r.Union(image.Rect(x0/4, y, (x1+3)/4, y+1))
+	}
+	return r.Add(a.Rect.Min)
+}
+
+// centiseconds rounds d to the unit of GIF and APNG frame delays.
+func centiseconds(d time.Duration) int {
+	return int((d + 5*time.Millisecond) / (10 * time.Millisecond))
+}
+
+// delays returns how long each frame shown at the given times lasts, in
+// centiseconds, until the next or end. Rounding the times rather than the
+// differences keeps the total in step with the recording.
+func delays(at []time.Duration, end time.Duration) []int {
+	d := make([]int, len(at))
+	for i := range at {
+		until := end
+		if i+1 < len(at) {
+			until = at[i+1]
+		}
+		d[i] = max(centiseconds(until)-centiseconds(at[i]), 2)
+	}
+	return d
+}
+
+// GIF writes rec to w as an animated GIF. Each frame after the first holds
+// only the region that changed, with a palette of its own.
+func GIF(w io.Writer, rec *record.Recording, opts *AnimationOptions) error {
+	var (
+		g  gif.GIF
+		at []time.Duration
+	)
+	end, err := animate(rec, opts, func(img *image.RGBA, changed image.Rectangle, t time.Duration) error {
+		g.Image = append(g.Image, quantize(img, changed))
+		g.Disposal = append(g.Disposal, gif.DisposalNone)
+		at = append(at, t)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	g.Delay = delays(at, end)
+	return gif.EncodeAll(w, &g)
+}
+
+// quantize returns the r region of img with a palette of its 256 most common
+// colors; a screen has few, besides the edges of glyphs.
+func quantize(img *image.RGBA, r image.Rectangle) *image.Paletted {
+	counts := map[color.RGBA]int{}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			counts[img.RGBAAt(x, y)]++
+		}
+	}
+	colors := slices.SortedFunc(maps.Keys(counts), func(a, b color.RGBA) int {
+		return cmp.Or(counts[b]-counts[a], cmp.Compare(rgb(a), rgb(b)))
+	})
+	colors = colors[:min(len(colors), 256)]
+
+	pal := make(color.Palette, len(colors))
+	index := make(map[color.RGBA]uint8, len(colors))
+	for i, c := range colors {
+		pal[i], index[c] = c, uint8(i)
+	}
+
+	out := image.NewPaletted(r, pal)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := img.RGBAAt(x, y)
+			i, ok := index[c]
+			if !ok {
+				i = uint8(pal.Index(c))
+				index[c] = i
+			}
+			out.SetColorIndex(x, y, i)
+		}
+	}
+	return out
+}
+
+func rgb(c color.RGBA) int { return int(c.R)<<16 | int(c.G)<<8 | int(c.B) }
+
+// APNG writes rec to w as an animated PNG, in full color. Each frame after the
+// first holds only the region that changed.
+func APNG(w io.Writer, rec *record.Recording, opts *AnimationOptions) error {
+	type frame struct {
+		r    image.Rectangle
+		at   time.Duration
+		data [][]byte // IDAT chunk data
+	}
+	var (
+		frames []frame
+		ihdr   []byte
+	)
+	enc := png.Encoder{CompressionLevel: png.BestCompression}
+	end, err := animate(rec, opts, func(img *image.RGBA, changed image.Rectangle, at time.Duration) error {
+		var buf bytes.Buffer
+		if err := enc.Encode(&buf, img.SubImage(changed)); err != nil {
+			return err
+		}
+		f := frame{r: changed, at: at}
+		err := pngChunks(buf.Bytes(), func(typ string, data []byte) {
+			switch {
+			case typ == "IHDR" && ihdr == nil:
+				ihdr = data
+			case typ == "IDAT":
+				f.data = append(f.data, data)
+			}
+		})
+		frames = append(frames, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	at := make([]time.Duration, len(frames))
+	for i, f := range frames {
+		at[i] = f.at
+	}
+	delay := delays(at, end)
+
+	cw := &chunkWriter{w: w}
+	_, cw.err = io.WriteString(w, pngHeader)
+	cw.chunk("IHDR", ihdr)
+	cw.chunk("acTL", binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, uint32(len(frames))), 0)) // #nosec G115 -- frame count
+	seq := uint32(0)
+	for i, f := range frames {
+		fctl := make([]byte, 0, 26)
+		for _, v := range []int{int(seq), f.r.Dx(), f.r.Dy(), f.r.Min.X, f.r.Min.Y} {
+			fctl = binary.BigEndian.AppendUint32(fctl, uint32(v)) // #nosec G115 -- non-negative
+		}
+		fctl = binary.BigEndian.AppendUint16(fctl, uint16(min(delay[i], 0xffff))) // #nosec G115 -- clamped
+		fctl = binary.BigEndian.AppendUint16(fctl, 100)
+		fctl = append(fctl, 0, 0) // dispose and blend: none, source
+		cw.chunk("fcTL", fctl)
+		seq++
+		for _, d := range f.data {
+			if i == 0 {
+				cw.chunk("IDAT", d)
+				continue
+			}
+			cw.chunk("fdAT", append(binary.BigEndian.AppendUint32(nil, seq), d...))
+			seq++
+		}
+	}
+	cw.chunk("IEND", nil)
+	return cw.err
+}
+
+const pngHeader = "\x89PNG\r\n\x1a\n"
+
+var errPNG = errors.New("render: malformed PNG")
+
+// pngChunks calls fn with the type and data of each chunk of a PNG file.
+func pngChunks(b []byte, fn func(typ string, data []byte)) error {
+	if !bytes.HasPrefix(b, []byte(pngHeader)) {
+		return errPNG
+	}
+	b = b[len(pngHeader):]
+	for len(b) > 0 {
+		if len(b) < 12 {
+			return errPNG
+		}
+		n := int(binary.BigEndian.Uint32(b))
+		if n > len(b)-12 {
+			return errPNG
+		}
+		fn(string(b[4:8]), b[8:8+n])
+		b = b[12+n:]
+	}
+	return nil
+}
+
+// chunkWriter writes PNG chunks, keeping the first error.
+type chunkWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (c *chunkWriter) chunk(typ string, data []byte) {
+	if c.err != nil {
+		return
+	}
+	b := binary.BigEndian.AppendUint32(nil, uint32(len(data))) // #nosec G115 -- chunks are small
+	b = append(append(b, typ...), data...)
+	b = binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b[4:]))
+	_, c.err = c.w.Write(b)
+}
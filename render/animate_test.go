@@ -0,0 +1,102 @@
+package render
+
+import (
+	"bytes"
+	"image/gif"
+	"image/png"
+	"testing"
+	"time"
+
+	"github.com/crgimenes/compterm/assets"
+	"github.com/crgimenes/compterm/record"
+)
+
+func testRecording() *record.Recording {
+	out := func(at time.Duration, s string) record.Event {
+		return record.Event{Time: at, Code: record.Output, Data: []byte(s)}
+	}
+	return &record.Recording{Rows: 4, Cols: 10, Events: []record.Event{
+		out(0, "$ "),
+		out(20*time.Millisecond, "l"),  // typed within a frame of the prompt
+		out(300*time.Millisecond, "s"), // a frame of its own
+		out(time.Minute, "\r\n"),       // after a long pause, but no visible change
+		out(time.Minute+100*time.Millisecond, "\033[1mfile\033[0m"),
+	}}
+}
+
+func testOptions(t *testing.T) *AnimationOptions {
+	data, err := assets.ReadFile(assets.FontFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := ParseFont(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &AnimationOptions{Theme: &DefaultTheme, Font: f, Size: 12, IdleLimit: time.Second}
+}
+
+func TestGIF(t *testing.T) {
+	var buf bytes.Buffer
+	if err := GIF(&buf, testRecording(), testOptions(t)); err != nil {
+		t.Fatal(err)
+	}
+	g, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// "$ l" at 0, "s" at 0.3 s, the newline (cursor moved) at 1.3 s, "file"
+	// at 1.4 s, held for the idle limit
+	want := []int{30, 100, 10, 100}
+	if len(g.Delay) != len(want) {
+		t.Fatalf("delays = %v, want %v", g.Delay, want)
+	}
+	for i := range want {
+		if g.Delay[i] != want[i] {
+			t.Errorf("delays = %v, want %v", g.Delay, want)
+			break
+		}
+	}
+
+	full := g.Image[0].Bounds()
+	if full.Dx() == 0 || full.Dy() == 0 {
+		t.Fatalf("first frame is empty: %v", full)
+	}
+	for i, img := range g.Image[1:] {
+		if b := img.Bounds(); !b.In(full) || b.Eq(full) {
+			t.Errorf("frame %d bounds = %v, want the changed part of %v", i+1, b, full)
+		}
+	}
+}
+
+func TestAPNG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := APNG(&buf, testRecording(), testOptions(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	// readers without APNG support show the first frame
+	img, err := png.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() == 0 {
+		t.Fatal("empty default image")
+	}
+
+	counts := map[string]int{}
+	var frames uint32
+	err = pngChunks(buf.Bytes(), func(typ string, data []byte) {
+		counts[typ]++
+		if typ == "acTL" {
+			frames = uint32(data[0])<<24 | uint32(data[1])<<16 | uint32(data[2])<<8 | uint32(data[3])
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frames != 4 || counts["fcTL"] != 4 || counts["fdAT"] < 3 || counts["IEND"] != 1 {
+		t.Errorf("frames = %d, chunks = %v", frames, counts)
+	}
+}
@@ -0,0 +1,126 @@
+package render
+
+import (
+	"encoding/json"
+	"image/color"
+	"strconv"
+	"strings"
+
+	"github.com/crgimenes/compterm/mterm"
+)
+
+// Theme is the palette a screen is drawn in.
+type Theme struct {
+	Foreground, Background color.RGBA
+	// Palette is the 256-color palette: the 16 ANSI colors, the 6x6x6 cube,
+	// and the grayscale ramp.
+	Palette [256]color.RGBA
+}
+
+// DefaultTheme matches the web viewer's built-in theme (assets/term.js), so
+// every rendering of a session looks alike.
+var DefaultTheme = func() (t Theme) {
+	t.Foreground = color.RGBA{0xd4, 0xd4, 0xd4, 0xff}
+	t.Background = color.RGBA{0x00, 0x00, 0x00, 0xff}
+	ansi := [16]uint32{
+		0x000000, 0xc91b00, 0x00c200, 0xc7c400, 0x0225c7, 0xc930c7, 0x00c5c7, 0xc7c7c7,
+		0x676767, 0xff6d67, 0x5ff967, 0xfefb67, 0x6871ff, 0xff76ff, 0x5ffdff, 0xfffeff,
+	}
+	for i, c := range ansi {
+		t.Palette[i] = color.RGBA{byte(c >> 16), byte(c >> 8), byte(c), 0xff}
+	}
+	level := func(n int) byte {
+		if n == 0 {
+			return 0
+		}
+		return byte(55 + 40*n)
+	}
+	for i := range 216 {
+		t.Palette[16+i] = color.RGBA{level(i / 36), level(i / 6 % 6), level(i % 6), 0xff}
+	}
+	for i := range 24 {
+		v := byte(8 + 10*i)
+		t.Palette[232+i] = color.RGBA{v, v, v, 0xff}
+	}
+	return t
+}()
+
+// themeNames are the xterm.js theme fields for the 16 ANSI colors, in order.
+var themeNames = [16]string{
+	"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white",
+	"brightBlack", "brightRed", "brightGreen", "brightYellow",
+	"brightBlue", "brightMagenta", "brightCyan", "brightWhite",
+}
+
+// ParseTheme reads an xterm.js theme, as served to the web viewer from
+// theme.json, over DefaultTheme. Fields that are not "#rgb" or "#rrggbb"
+// colors are ignored.
+func ParseTheme(data []byte) (*Theme, error) {
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	t := DefaultTheme
+	set := func(name string, dst *color.RGBA) {
+		if s, ok := fields[name].(string); ok {
+			if c, ok := parseHex(s); ok {
+				*dst = c
+			}
+		}
+	}
+	set("foreground", &t.Foreground)
+	set("background", &t.Background)
+	for i, name := range themeNames {
+		set(name, &t.Palette[i])
+	}
+	return &t, nil
+}
+
+func parseHex(s string) (color.RGBA, bool) {
+	s, ok := strings.CutPrefix(s, "#")
+	if !ok || (len(s) != 3 && len(s) != 6) {
+		return color.RGBA{}, false
+	}
+	n, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.RGBA{}, false
+	}
+	if len(s) == 3 {
+		r, g, b := byte(n>>8&0xf), byte(n>>4&0xf), byte(n&0xf)
+		return color.RGBA{r * 0x11, g * 0x11, b * 0x11, 0xff}, true
+	}
+	return color.RGBA{byte(n >> 16), byte(n >> 8), byte(n), 0xff}, true
+}
+
+// resolve returns a color of the given type (mterm.Color16 and friends) and
+// value, or def when it is the default.
+func (t *Theme) resolve(typ uint8, c mterm.Color, def color.RGBA) color.RGBA {
+	switch typ {
+	case mterm.Color16:
+		// stored as the SGR code: 30-37, 40-47, 90-97, 100-107
+		n := int(c[0])
+		if n >= 90 {
+			return t.Palette[(n%10)+8]
+		}
+		return t.Palette[n%10]
+	case mterm.Color256:
+		return t.Palette[c[0]]
+	case mterm.Color16M:
+		return color.RGBA{c[0], c[1], c[2], 0xff}
+	}
+	return def
+}
+
+// Colors returns the foreground and background a cell of style st is drawn
+// in, inverse and invisible applied.
+func (t *Theme) Colors(st mterm.SGRState) (fg, bg color.RGBA) {
+	fg = t.resolve(st.ColorType&0b11, st.FG, t.Foreground)
+	bg = t.resolve(st.ColorType>>2&0b11, st.BG, t.Background)
+	if st.Flags&mterm.FlagInverse != 0 {
+		fg, bg = bg, fg
+	}
+	if st.Flags&mterm.FlagInvisible != 0 {
+		fg = bg
+	}
+	return fg, bg
+}
@@ -0,0 +1,155 @@
+// Package render draws an mterm screen for viewers without a terminal
+// emulator, in the colors of the web viewer.
+package render
+
+import (
+	"fmt"
+	"html"
+	"image/color"
+	"strings"
+
+	"github.com/crgimenes/compterm/mterm"
+)
+
+// HTML appends s as a <pre> of styled spans, one line per row, in theme's
+// colors. Styles are inline so the markup stands alone; trailing blanks are
+// trimmed and the cursor, unless hidden, is drawn as an inverted cell.
+// Hyperlinks become anchors.
+func HTML(dst []byte, s *mterm.Snapshot, theme *Theme) []byte {
+	fg, bg := hexColor(theme.Foreground), hexColor(theme.Background)
+	dst = fmt.Appendf(dst, `<pre class="screen" style="color:%s;background-color:%s">`, fg, bg)
+
+	for r := range s.Rows {
+		row := s.Cells[r*s.Cols : (r+1)*s.Cols]
+		end := len(row)
+		for end > 0 && theme.blank(row[end-1]) && !(!s.CursorHidden && r == s.Cursor[0] && end-1 == s.Cursor[1]) {
+			end--
+		}
+
+		open, link := "", ""
+		for c, cell := range row[:end] {
+			if cell.Width() == 0 {
+				continue // the wide character before it covers it
+			}
+			st := cell.SGRState
+			if !s.CursorHidden && r == s.Cursor[0] && c == s.Cursor[1] {
+				st.Flags ^= mterm.FlagInverse
+			}
+			if href := linkHref(cell.Link().URI); href != link {
+				if open != "" {
+					dst = append(dst, "</span>"...)
+					open = ""
+				}
+				if link != "" {
+					dst = append(dst, "</a>"...)
+				}
+				if href != "" {
+					dst = fmt.Appendf(dst, `<a href="%s">`, html.EscapeString(href))
+				}
+				link = href
+			}
+			if style := theme.spanStyle(st); style != open {
+				if open != "" {
+					dst = append(dst, "</span>"...)
+				}
+				if style != "" {
+					dst = append(dst, `<span style="`...)
+					dst = append(dst, style...)
+					dst = append(dst, `">`...)
+				}
+				open = style
+			}
+			dst = append(dst, html.EscapeString(grapheme(cell))...)
+		}
+		if open != "" {
+			dst = append(dst, "</span>"...)
+		}
+		if link != "" {
+			dst = append(dst, "</a>"...)
+		}
+		dst = append(dst, '\n')
+	}
+	return append(dst, "</pre>"...)
+}
+
+// spanStyle returns the inline CSS for st, empty for the default style.
+func (t *Theme) spanStyle(st mterm.SGRState) string {
+	var b strings.Builder
+	fg, bg := t.Colors(st)
+	if fg != t.Foreground {
+		fmt.Fprintf(&b, "color:%s;", hexColor(fg))
+	}
+	if bg != t.Background {
+		fmt.Fprintf(&b, "background-color:%s;", hexColor(bg))
+	}
+	if st.Flags&mterm.FlagBold != 0 {
+		b.WriteString("font-weight:bold;")
+	}
+	if st.Flags&mterm.FlagDim != 0 {
+		b.WriteString("opacity:0.6;")
+	}
+	if st.Flags&mterm.FlagItalic != 0 {
+		b.WriteString("font-style:italic;")
+	}
+	var lines []string
+	if st.Flags&mterm.FlagUnderline != 0 {
+		lines = append(lines, "underline")
+	}
+	if st.Extra&mterm.ExtraOverline != 0 {
+		lines = append(lines, "overline")
+	}
+	if st.Flags&mterm.FlagStrike != 0 {
+		lines = append(lines, "line-through")
+	}
+	if len(lines) > 0 {
+		fmt.Fprintf(&b, "text-decoration:%s;", strings.Join(lines, " "))
+	}
+	if st.Flags&mterm.FlagUnderline != 0 {
+		if style := underlineStyles[st.Extra&mterm.ExtraUnderlineStyle]; style != "" {
+			fmt.Fprintf(&b, "text-decoration-style:%s;", style)
+		}
+	}
+	return strings.TrimSuffix(b.String(), ";")
+}
+
+// underlineStyles are the names of the underline styles, in CSS and in JSON;
+// a single underline has none.
+var underlineStyles = map[uint8]string{
+	mterm.UnderlineDouble: "double",
+	mterm.UnderlineCurly:  "wavy",
+	mterm.UnderlineDotted: "dotted",
+	mterm.UnderlineDashed: "dashed",
+}
+
+// linkHref returns uri for an href, or empty when the scheme is not one a
+// page can follow safely: a program on the host sets the links.
+func linkHref(uri string) string {
+	scheme, _, ok := strings.Cut(uri, ":")
+	if !ok {
+		return ""
+	}
+	switch strings.ToLower(scheme) {
+	case "http", "https", "ftp", "mailto", "file":
+		return uri
+	}
+	return ""
+}
+
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// grapheme returns what a cell shows: unwritten cells hold 0.
+func grapheme(c mterm.Cell) string {
+	if c.Char < ' ' {
+		return " "
+	}
+	return c.Grapheme()
+}
+
+// blank reports whether a cell shows nothing: a space in the default colors.
+func (t *Theme) blank(c mterm.Cell) bool {
+	_, bg := t.Colors(c.SGRState)
+	return c.Char <= ' ' && bg == t.Background && c.Flags&(mterm.FlagUnderline|mterm.FlagStrike) == 0 &&
+		c.Extra&mterm.ExtraOverline == 0
+}
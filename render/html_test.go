@@ -0,0 +1,97 @@
+package render
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/crgimenes/compterm/mterm"
+)
+
+func TestHTML(t *testing.T) {
+	term := mterm.New(3, 12)
+	_, _ = term.Write([]byte("a<b>&c \033[1;31mred\033[0m\r\n\033[44;38;5;16mx\033[0m\033[7mi\033[0m\033[3;1H"))
+	got := string(HTML(nil, term.Snapshot(), &DefaultTheme))
+
+	for _, want := range []string{
+		`<pre class="screen" style="color:#d4d4d4;background-color:#000000">`,
+		"a&lt;b&gt;&amp;c ",
+		`<span style="color:#c91b00;font-weight:bold">red</span>` + "\n",
+		`<span style="color:#000000;background-color:#0225c7">x</span>`,
+		`<span style="color:#000000;background-color:#d4d4d4">i</span>` + "\n",
+		// the cursor, on an otherwise empty row
+		`<span style="color:#000000;background-color:#d4d4d4"> </span>` + "\n</pre>",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("HTML lacks %q:\n%s", want, got)
+		}
+	}
+}
+
+// TestHTMLMarks escapes a cell whose base is markup, with the marks on it.
+func TestHTMLMarks(t *testing.T) {
+	term := mterm.New(1, 8)
+	_, _ = term.Write([]byte("<\u0301 &\u200d"))
+	got := string(HTML(nil, term.Snapshot(), &DefaultTheme))
+
+	if want := "&lt;\u0301 &amp;\u200d"; !strings.Contains(got, want) {
+		t.Errorf("HTML lacks %q:\n%s", want, got)
+	}
+}
+
+func TestColors(t *testing.T) {
+	tests := []struct {
+		name   string
+		sgr    string
+		fg, bg string
+	}{
+		{"default", "", "#d4d4d4", "#000000"},
+		{"ansi", "\033[32;43m", "#00c200", "#c7c400"},
+		{"bright", "\033[92;103m", "#5ff967", "#fefb67"},
+		{"cube", "\033[38;5;196;48;5;21m", "#ff0000", "#0000ff"},
+		{"gray", "\033[38;5;244m", "#808080", "#000000"},
+		{"truecolor", "\033[38;2;1;2;3m", "#010203", "#000000"},
+		{"inverse", "\033[31;7m", "#000000", "#c91b00"},
+		{"invisible", "\033[31;44;8m", "#0225c7", "#0225c7"},
+	}
+	for _, tt := range tests {
+		term := mterm.New(1, 2)
+		_, _ = term.Write([]byte(tt.sgr + "x"))
+		fg, bg := DefaultTheme.Colors(term.Snapshot().Cells[0].SGRState)
+		if hexColor(fg) != tt.fg || hexColor(bg) != tt.bg {
+			t.Errorf("%s: Colors = %s on %s, want %s on %s", tt.name, hexColor(fg), hexColor(bg), tt.fg, tt.bg)
+		}
+	}
+}
+
+func TestHTMLHiddenCursor(t *testing.T) {
+	term := mterm.New(1, 4)
+	_, _ = term.Write([]byte("\033[?25lab"))
+	if got := string(HTML(nil, term.Snapshot(), &DefaultTheme)); strings.Contains(got, "<span") {
+		t.Errorf("HTML draws the hidden cursor: %s", got)
+	}
+}
+
+func TestHTMLDecorations(t *testing.T) {
+	term := mterm.New(1, 8)
+	_, _ = term.Write([]byte("\033[4:3ma\033[0;21;9mb\033[0;53mc\033[0m\033[?25l"))
+	got := string(HTML(nil, term.Snapshot(), &DefaultTheme))
+	for _, want := range []string{
+		`<span style="text-decoration:underline;text-decoration-style:wavy">a</span>`,
+		`<span style="text-decoration:underline line-through;text-decoration-style:double">b</span>`,
+		`<span style="text-decoration:overline">c</span>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("HTML lacks %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestHTMLLinks(t *testing.T) {
+	term := mterm.New(1, 20)
+	_, _ = term.Write([]byte("\033]8;;https://a.b/?x=1&y=\"\033\\a\033[1mb\033]8;;javascript:alert(1)\033\\c\033]8;;\033\\\033[?25l"))
+	got := string(HTML(nil, term.Snapshot(), &DefaultTheme))
+	want := `<a href="https://a.b/?x=1&amp;y=&#34;">a<span style="font-weight:bold">b</span></a><span style="font-weight:bold">c</span>`
+	if !strings.Contains(got, want) {
+		t.Errorf("HTML lacks %q:\n%s", want, got)
+	}
+}
@@ -0,0 +1,139 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+
+	"github.com/crgimenes/compterm/mterm"
+)
+
+// Font is a parsed TrueType or OpenType font to draw screens with; a
+// monospaced one, such as the viewer's embedded 3270 Nerd Font.
+type Font struct {
+	f *opentype.Font
+}
+
+// ParseFont parses a TrueType or OpenType font file.
+func ParseFont(data []byte) (*Font, error) {
+	f, err := opentype.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	return &Font{f: f}, nil
+}
+
+// Image draws s in theme's colors with f at size pixels per em. Cells are the
+// font's advance wide and line high; bold is drawn twice, a pixel apart, and
+// the cursor, unless hidden, as an inverted cell.
+func Image(s *mterm.Snapshot, theme *Theme, f *Font, size float64) (*image.RGBA, error) {
+	p, err := newPainter(theme, f, size)
+	if err != nil {
+		return nil, err
+	}
+	defer p.close()
+	return p.draw(s), nil
+}
+
+// CellSize returns the size in pixels of a cell drawn with f at size pixels
+// per em, so the image of rows by cols cells is cols*w by rows*h.
+func (f *Font) CellSize(size float64) (w, h int, err error) {
+	p, err := newPainter(&DefaultTheme, f, size)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer p.close()
+	return p.cw, p.ch, nil
+}
+
+// painter draws snapshots with one face, which caches rasterized glyphs
+// between them. A face is not safe for concurrent use, and neither is a
+// painter.
+type painter struct {
+	theme           *Theme
+	face            font.Face
+	cw, ch          int
+	ascent, xHeight int
+}
+
+func newPainter(theme *Theme, f *Font, size float64) (*painter, error) {
+	face, err := opentype.NewFace(f.f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, err
+	}
+	m := face.Metrics()
+	adv, _ := face.GlyphAdvance('M')
+	return &painter{
+		theme:   theme,
+		face:    face,
+		cw:      adv.Ceil(),
+		ch:      m.Height.Ceil(),
+		ascent:  m.Ascent.Ceil(),
+		xHeight: m.XHeight.Ceil(),
+	}, nil
+}
+
+func (p *painter) close() { _ = p.face.Close() }
+
+func (p *painter) draw(s *mterm.Snapshot) *image.RGBA {
+	theme, cw, ch, ascent := p.theme, p.cw, p.ch, p.ascent
+
+	img := image.NewRGBA(image.Rect(0, 0, s.Cols*cw, s.Rows*ch))
+	draw.Draw(img, img.Bounds(), image.NewUniform(theme.Background), image.Point{}, draw.Src)
+
+	d := &font.Drawer{Dst: img, Face: p.face}
+	for i, c := range s.Cells {
+		r, col := i/s.Cols, i%s.Cols
+		st := c.SGRState
+		if !s.CursorHidden && r == s.Cursor[0] && col == s.Cursor[1] {
+			st.Flags ^= mterm.FlagInverse
+		}
+		fg, bg := theme.Colors(st)
+		if st.Flags&mterm.FlagDim != 0 {
+			fg = blend(fg, bg)
+		}
+
+		x, y := col*cw, r*ch
+		cell := image.Rect(x, y, x+cw, y+ch)
+		if bg != theme.Background {
+			draw.Draw(img, cell, image.NewUniform(bg), image.Point{}, draw.Src)
+		}
+
+		d.Src = image.NewUniform(fg)
+		if glyph := grapheme(c); glyph != " " && c.Width() > 0 {
+			d.Dot = fixed.P(x, y+ascent)
+			d.DrawString(glyph)
+			if st.Flags&mterm.FlagBold != 0 {
+				d.Dot = fixed.P(x+1, y+ascent)
+				d.DrawString(glyph)
+			}
+		}
+		if st.Flags&mterm.FlagUnderline != 0 {
+			line(img, x, x+cw, y+min(ascent+1, ch-1), fg)
+		}
+		if st.Flags&mterm.FlagStrike != 0 {
+			line(img, x, x+cw, y+ascent-p.xHeight/2, fg)
+		}
+	}
+	return img
+}
+
+// blend returns the color halfway between a and b, for dim text.
+func blend(a, b color.RGBA) color.RGBA {
+	return color.RGBA{
+		byte((int(a.R) + int(b.R)) / 2),
+		byte((int(a.G) + int(b.G)) / 2),
+		byte((int(a.B) + int(b.B)) / 2),
+		0xff,
+	}
+}
+
+func line(img *image.RGBA, x0, x1, y int, c color.RGBA) {
+	for x := x0; x < x1; x++ {
+		img.SetRGBA(x, y, c)
+	}
+}
@@ -0,0 +1,105 @@
+package render
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/crgimenes/compterm/mterm"
+)
+
+type jsonScreen struct {
+	Rows       int        `json:"rows"`
+	Columns    int        `json:"columns"`
+	Scrollback int        `json:"scrollback"`
+	Cursor     jsonCursor `json:"cursor"`
+	Lines      []jsonLine `json:"lines"`
+}
+
+type jsonCursor struct {
+	Row    int  `json:"row"`
+	Column int  `json:"column"`
+	Hidden bool `json:"hidden,omitempty"`
+}
+
+type jsonLine struct {
+	Text  string     `json:"text"`
+	Cells []jsonCell `json:"cells"`
+}
+
+// jsonCell is one cell. Colors are "#rrggbb", omitted when default, and
+// before inverse is applied. A wide character is in the first of its two
+// cells; the second has an empty char.
+type jsonCell struct {
+	Char      string `json:"char"`
+	Wide      bool   `json:"wide,omitempty"`
+	FG        string `json:"fg,omitempty"`
+	BG        string `json:"bg,omitempty"`
+	UL        string `json:"ul,omitempty"`
+	Bold      bool   `json:"bold,omitempty"`
+	Dim       bool   `json:"dim,omitempty"`
+	Italic    bool   `json:"italic,omitempty"`
+	Underline bool   `json:"underline,omitempty"`
+	ULStyle   string `json:"underline_style,omitempty"` // double, wavy, dotted or dashed
+	Overline  bool   `json:"overline,omitempty"`
+	Blink     bool   `json:"blink,omitempty"`
+	Inverse   bool   `json:"inverse,omitempty"`
+	Invisible bool   `json:"invisible,omitempty"`
+	Strike    bool   `json:"strike,omitempty"`
+	Link      string `json:"link,omitempty"` // the OSC 8 hyperlink URI
+}
+
+// JSON returns s as a JSON document for bots and tests: its size, cursor, and
+// every line as text and as cells with their colors and attributes. The first
+// scrollback rows of s are scrollback (see WithScrollback); rows and the
+// cursor row count from the screen below them. Colors are theme's.
+func JSON(s *mterm.Snapshot, scrollback int, theme *Theme) ([]byte, error) {
+	doc := jsonScreen{
+		Rows:       s.Rows - scrollback,
+		Columns:    s.Cols,
+		Scrollback: scrollback,
+		Cursor:     jsonCursor{Row: s.Cursor[0] - scrollback, Column: s.Cursor[1], Hidden: s.CursorHidden},
+		Lines:      make([]jsonLine, s.Rows),
+	}
+
+	var text strings.Builder
+	for r := range s.Rows {
+		cells := make([]jsonCell, s.Cols)
+		for i, c := range s.Cells[r*s.Cols : (r+1)*s.Cols] {
+			st := c.SGRState
+			cells[i] = jsonCell{
+				Wide:      c.Width() == 2,
+				FG:        theme.colorName(st.ColorType&0b11, st.FG),
+				BG:        theme.colorName(st.ColorType>>2&0b11, st.BG),
+				UL:        theme.colorName(st.ColorType>>4&0b11, st.UL),
+				Bold:      st.Flags&mterm.FlagBold != 0,
+				Dim:       st.Flags&mterm.FlagDim != 0,
+				Italic:    st.Flags&mterm.FlagItalic != 0,
+				Underline: st.Flags&mterm.FlagUnderline != 0,
+				Overline:  st.Extra&mterm.ExtraOverline != 0,
+				Blink:     st.Flags&mterm.FlagBlink != 0,
+				Inverse:   st.Flags&mterm.FlagInverse != 0,
+				Invisible: st.Flags&mterm.FlagInvisible != 0,
+				Strike:    st.Flags&mterm.FlagStrike != 0,
+				Link:      c.Link().URI,
+			}
+			if cells[i].Underline {
+				cells[i].ULStyle = underlineStyles[st.Extra&mterm.ExtraUnderlineStyle]
+			}
+			if c.Width() > 0 {
+				cells[i].Char = grapheme(c)
+				text.WriteString(cells[i].Char)
+			}
+		}
+		doc.Lines[r] = jsonLine{Text: strings.TrimRight(text.String(), " "), Cells: cells}
+		text.Reset()
+	}
+	return json.Marshal(doc)
+}
+
+// colorName returns a color as "#rrggbb", or "" for the default.
+func (t *Theme) colorName(typ uint8, c mterm.Color) string {
+	if typ == 0 {
+		return ""
+	}
+	return hexColor(t.resolve(typ, c, t.Foreground))
+}
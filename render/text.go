@@ -0,0 +1,65 @@
+package render
+
+import (
+	"strings"
+
+	"github.com/crgimenes/compterm/mterm"
+)
+
+// Text appends s as plain text: one line per row, without trailing blanks or
+// the empty rows below the last line of text. Hyperlinked text is followed by
+// its URI in angle brackets, unless it is the URI.
+func Text(dst []byte, s *mterm.Snapshot) []byte {
+	last := len(dst) // the end of the last line holding text
+	for r := range s.Rows {
+		line := len(dst)
+		link, start := "", 0 // the open link and where its text starts
+		for _, c := range s.Cells[r*s.Cols : (r+1)*s.Cols] {
+			if c.Width() == 0 {
+				continue
+			}
+			if uri := c.Link().URI; uri != link {
+				dst = appendURI(dst, start, link)
+				link, start = uri, len(dst)
+			}
+			dst = append(dst, grapheme(c)...)
+		}
+		dst = appendURI(dst, start, link)
+		for len(dst) > line && dst[len(dst)-1] == ' ' {
+			dst = dst[:len(dst)-1]
+		}
+		if len(dst) > line {
+			last = len(dst) + 1
+		}
+		dst = append(dst, '\n')
+	}
+	return dst[:last]
+}
+
+// appendURI appends " <uri>" after the text from start that uri links,
+// unless that text is uri itself.
+func appendURI(dst []byte, start int, uri string) []byte {
+	if uri == "" {
+		return dst
+	}
+	text := strings.TrimRight(string(dst[start:]), " ")
+	if text == uri {
+		return dst
+	}
+	dst = append(dst[:start+len(text)], " <"...)
+	dst = append(dst, uri...)
+	return append(dst, '>')
+}
+
+// WithScrollback returns s with the given scrollback rows (mterm's
+// Scrollback, at s's width) above it; the cursor moves down with the screen.
+func WithScrollback(s *mterm.Snapshot, scrollback []mterm.Cell) *mterm.Snapshot {
+	n := len(scrollback) / s.Cols
+	return &mterm.Snapshot{
+		Rows:         n + s.Rows,
+		Cols:         s.Cols,
+		Cells:        append(scrollback[:n*s.Cols:n*s.Cols], s.Cells...),
+		Cursor:       [2]int{s.Cursor[0] + n, s.Cursor[1]},
+		CursorHidden: s.CursorHidden,
+	}
+}
@@ -0,0 +1,76 @@
+package render
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/crgimenes/compterm/mterm"
+)
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"empty", "", ""},
+		{"trailing blanks and rows", "a  b   \r\n\r\n  c\033[31m   ", "a  b\n\n  c\n"},
+		{"styles dropped", "\033[1;4mbold\033[0m", "bold\n"},
+		{"wide characters", "中文 ok", "中文 ok\n"},
+	}
+	for _, tt := range tests {
+		term := mterm.New(4, 10)
+		_, _ = term.Write([]byte(tt.in))
+		if got := string(Text([]byte(">"), term.Snapshot())); got != ">"+tt.want {
+			t.Errorf("%s: Text = %q, want %q", tt.name, got, ">"+tt.want)
+		}
+	}
+}
+
+func TestScrollbackJSON(t *testing.T) {
+	term := mterm.New(2, 6)
+	_, _ = term.Write([]byte("one\r\ntwo\r\nthree\r\n\033[1;32mfour"))
+
+	scrollback := term.Scrollback()
+	s := WithScrollback(term.Snapshot(), scrollback)
+	if got, want := string(Text(nil, s)), "one\ntwo\nthree\nfour\n"; got != want {
+		t.Fatalf("Text with scrollback = %q, want %q", got, want)
+	}
+
+	data, err := JSON(s, len(scrollback)/6, &DefaultTheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Rows, Columns, Scrollback int
+		Cursor                    struct{ Row, Column int }
+		Lines                     []struct {
+			Text  string
+			Cells []struct {
+				Char, FG, BG string
+				Bold         bool
+			}
+		}
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Rows != 2 || doc.Columns != 6 || doc.Scrollback != 2 || doc.Cursor.Row != 1 || doc.Cursor.Column != 4 {
+		t.Fatalf("JSON = %d rows, %d columns, %d scrollback, cursor %+v", doc.Rows, doc.Columns, doc.Scrollback, doc.Cursor)
+	}
+	if len(doc.Lines) != 4 || doc.Lines[0].Text != "one" || doc.Lines[3].Text != "four" {
+		t.Fatalf("JSON lines = %+v", doc.Lines)
+	}
+	if c := doc.Lines[3].Cells[0]; c.Char != "f" || c.FG != "#00c200" || c.BG != "" || !c.Bold {
+		t.Fatalf("JSON cell = %+v, want a bold green f", c)
+	}
+	if c := doc.Lines[0].Cells[5]; c.Char != " " || c.FG != "" || c.Bold {
+		t.Fatalf("JSON blank cell = %+v", c)
+	}
+}
+
+func TestTextLinks(t *testing.T) {
+	term := mterm.New(2, 30)
+	_, _ = term.Write([]byte("see \033]8;;https://a.b/c\033\\docs\033]8;;\033\\ or\r\n\033]8;;https://a.b\033\\https://a.b\033]8;;\033\\"))
+	if got, want := string(Text(nil, term.Snapshot())), "see docs <https://a.b/c> or\nhttps://a.b\n"; got != want {
+		t.Errorf("Text = %q, want %q", got, want)
+	}
+}
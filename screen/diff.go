@@ -0,0 +1,72 @@
+package screen
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/crgimenes/compterm/constants"
+	"github.com/crgimenes/compterm/diff"
+	"github.com/crgimenes/compterm/e2e"
+	"github.com/crgimenes/compterm/mterm"
+)
+
+// diffInterval caps how often a client in diff mode is sent the changes: at
+// most one state per interval, and only once it has drained the last one, so a
+// slow viewer skips states instead of falling behind.
+const diffInterval = 50 * time.Millisecond
+
+// attachDiff starts a diff-mode client at a full redraw and makes sure the
+// diff loop runs. pubMu must be held.
+func (s *Screen) attachDiff(c *Client) {
+	c.last, c.sent = nil, 0
+	s.sendDiff(c, s.mt.Snapshot())
+	s.diffOnce.Do(func() { go s.diffLoop() })
+}
+
+// diffLoop sends the diff-mode clients what changed, every diffInterval.
+func (s *Screen) diffLoop() {
+	ticker := time.NewTicker(diffInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.pubMu.Lock()
+		// pubMu keeps the emulator at s.seq
+		var cur *mterm.Snapshot
+		for _, c := range s.snapshotClients() {
+			if !c.Diff || c.dvr != nil || c.IsClosed() ||
+				c.sent == s.seq || c.bs.Len() > 0 {
+				continue
+			}
+			if cur == nil {
+				cur = s.mt.Snapshot()
+			}
+			s.sendDiff(c, cur)
+		}
+		s.pubMu.Unlock()
+	}
+}
+
+// sendDiff sends c the changes from the last state it got to cur, preceded by
+// a RESIZE when the size changed. On an encrypted screen they are sealed, as a
+// keyframe when they start the client over. pubMu must be held.
+func (s *Screen) sendDiff(c *Client, cur *mterm.Snapshot) {
+	var frames []frame
+	if c.last == nil || c.last.Rows != cur.Rows || c.last.Cols != cur.Cols {
+		frames = append(frames, frame{cmd: constants.RESIZE, payload: fmt.Appendf(nil, "%d:%d", cur.Rows, cur.Cols)})
+	}
+	for _, p := range diff.Encode(c.last, cur) {
+		frames = append(frames, frame{cmd: constants.DIFF, payload: p})
+	}
+
+	if s.key != nil && len(frames) > 0 {
+		var flags byte
+		if c.last == nil {
+			flags = e2e.Keyframe
+		}
+		frames = s.seal(flags, frames)
+	}
+	if err := sendFrames(c, frames); err != nil {
+		c.Close()
+	}
+	c.last, c.sent = cur, s.seq
+}
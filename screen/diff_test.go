@@ -0,0 +1,86 @@
+package screen
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/crgimenes/compterm/constants"
+	"github.com/crgimenes/compterm/diff"
+	"github.com/crgimenes/compterm/mterm"
+	"github.com/crgimenes/compterm/protocol"
+)
+
+// take reads what is queued to c, leaving its stream open and empty.
+func take(t *testing.T, c *Client) (cmds []byte, payloads [][]byte) {
+	t.Helper()
+	buf := make([]byte, constants.BufferSize)
+	n, err := c.bs.Read(buf)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	err = protocol.DecodeFrames(make([]byte, constants.BufferSize), buf[:n], func(cmd byte, p []byte) {
+		cmds = append(cmds, cmd)
+		payloads = append(payloads, bytes.Clone(p))
+	})
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return cmds, payloads
+}
+
+func TestDiffClient(t *testing.T) {
+	s := New(5, 20)
+	_, _ = s.Write([]byte("one"))
+	waitSeq(t, s, 1)
+
+	c := bareClient()
+	c.Diff = true
+	s.AttachClient(c)
+
+	mirror := mterm.New(1, 1)
+	apply := func(cmds []byte, payloads [][]byte) {
+		t.Helper()
+		for i, cmd := range cmds {
+			switch cmd {
+			case constants.RESIZE:
+				mirror.Resize(5, 20)
+			case constants.DIFF:
+				out, err := diff.Render(nil, payloads[i])
+				if err != nil {
+					t.Fatalf("render: %v", err)
+				}
+				_, _ = mirror.Write(out)
+			default:
+				t.Fatalf("diff client sent cmd %d", cmd)
+			}
+		}
+	}
+
+	// attaching sends the size and a full diff, and no SEQ
+	cmds, payloads := take(t, c)
+	if !bytes.Equal(cmds, []byte{constants.RESIZE, constants.DIFF}) || payloads[1][0]&diff.Full == 0 {
+		t.Fatalf("attach cmds = %v, want RESIZE and a full DIFF", cmds)
+	}
+	apply(cmds, payloads)
+
+	// output reaches it as a diff, not as MSG frames
+	_, _ = s.Write([]byte("\033[2;1Htwo"))
+	waitSeq(t, s, 2)
+	deadline := time.Now().Add(5 * time.Second)
+	for c.bs.Len() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no diff sent after output")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cmds, payloads = take(t, c)
+	if !bytes.Equal(cmds, []byte{constants.DIFF}) || payloads[0][0]&diff.Full != 0 {
+		t.Fatalf("update cmds = %v, want one partial DIFF", cmds)
+	}
+	apply(cmds, payloads)
+
+	if got, want := mirror.GetScreenAsAnsi(), s.GetScreenAsANSI(); !bytes.Equal(got, want) {
+		t.Fatalf("mirror = %q, want %q", got, want)
+	}
+}
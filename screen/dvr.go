@@ -0,0 +1,253 @@
+package screen
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/crgimenes/compterm/constants"
+	"github.com/crgimenes/compterm/e2e"
+)
+
+// A viewer can rewind a live session (REWIND, seconds behind live) and play it
+// from there, time-shifted, until it asks for LIVE again. The screen keeps a
+// rolling recording of what it published, with periodic keyframes of the
+// emulator to start playback from, and plays it to a time-shifted client from
+// a goroutine of its own; the broadcast skips that client in the meantime.
+const (
+	dvrWindow      = 5 * time.Minute  // how far back a viewer can rewind
+	dvrLimit       = 16 << 20         // 16M of recorded frames and keyframes
+	dvrKeyInterval = 10 * time.Second // time between keyframes
+	dvrTick        = 50 * time.Millisecond
+)
+
+// keyframe is the screen as of frame seq, ready to send.
+type keyframe struct {
+	at     time.Time
+	seq    uint64
+	frames []frame
+	size   int
+}
+
+// recording holds the keyframes of the last dvrWindow and every frame after
+// the oldest one. It is guarded by Screen.pubMu.
+type recording struct {
+	keys   []keyframe
+	frames []frame
+	size   int
+}
+
+func (r *recording) add(f frame) {
+	if len(r.keys) == 0 {
+		return // nothing to play it from yet
+	}
+	r.frames = append(r.frames, f)
+	r.size += len(f.payload)
+	r.evict(f.at)
+}
+
+// due reports whether a keyframe should be taken at now.
+func (r *recording) due(now time.Time) bool {
+	return len(r.keys) == 0 || now.Sub(r.keys[len(r.keys)-1].at) >= dvrKeyInterval
+}
+
+func (r *recording) addKey(k keyframe) {
+	for _, f := range k.frames {
+		k.size += len(f.payload)
+	}
+	r.keys = append(r.keys, k)
+	r.size += k.size
+	r.evict(k.at)
+}
+
+// evict drops the oldest keyframe, and the frames it led to, while the next
+// one still reaches back dvrWindow or the recording is over dvrLimit.
+func (r *recording) evict(now time.Time) {
+	drop := 0
+	for len(r.keys)-drop > 1 &&
+		(!r.keys[drop+1].at.After(now.Add(-dvrWindow)) || r.size > dvrLimit) {
+		r.size -= r.keys[drop].size
+		drop++
+		n := 0
+		for n < len(r.frames) && r.frames[n].seq <= r.keys[drop].seq {
+			r.size -= len(r.frames[n].payload)
+			n++
+		}
+		r.frames = r.frames[n:]
+	}
+	if drop > 0 {
+		clear(r.keys[:drop])
+		r.keys = r.keys[drop:]
+	}
+}
+
+// oldest returns when the recording starts.
+func (r *recording) oldest() (time.Time, bool) {
+	if len(r.keys) == 0 {
+		return time.Time{}, false
+	}
+	return r.keys[0].at, true
+}
+
+// seek returns the keyframe to play target from, and the frames after it up to
+// target.
+func (r *recording) seek(target time.Time) (keyframe, []frame) {
+	k := r.keys[0]
+	for _, kk := range r.keys[1:] {
+		if kk.at.After(target) {
+			break
+		}
+		k = kk
+	}
+	due, _ := r.from(k.seq+1, target)
+	return k, due
+}
+
+// from returns the frames from seq next up to time until, or false when frames
+// from next were already dropped.
+func (r *recording) from(next uint64, until time.Time) ([]frame, bool) {
+	if len(r.keys) == 0 || next <= r.keys[0].seq {
+		return nil, false
+	}
+	if len(r.frames) == 0 {
+		return nil, true
+	}
+	if next < r.frames[0].seq {
+		return nil, false
+	}
+	i := int(next - r.frames[0].seq)
+	if i > len(r.frames) {
+		return nil, true
+	}
+	j := i
+	for j < len(r.frames) && !r.frames[j].at.After(until) {
+		j++
+	}
+	return r.frames[i:j], true
+}
+
+// record adds a published frame to the recording, with a keyframe of the
+// resulting screen every dvrKeyInterval.
+func (s *Screen) record(f frame) {
+	s.rec.add(f)
+	if s.rec.due(f.at) {
+		s.rec.addKey(keyframe{at: f.at, seq: f.seq, frames: s.snapshot(keyframeScrollback)})
+	}
+}
+
+// player plays the recording to a time-shifted client, offset behind live.
+type player struct {
+	offset time.Duration
+	next   uint64 // the next frame to send
+	stop   chan struct{}
+}
+
+// control handles a client's REWIND and LIVE requests.
+func (s *Screen) control(c *Client, cmd byte, payload []byte) {
+	switch cmd {
+	case constants.REWIND:
+		secs, err := strconv.Atoi(string(payload))
+		if err != nil || secs <= 0 {
+			return
+		}
+		s.rewind(c, time.Duration(secs)*time.Second)
+	case constants.LIVE:
+		s.pubMu.Lock()
+		if c.dvr != nil {
+			s.live(c)
+		}
+		s.pubMu.Unlock()
+	}
+}
+
+// rewind starts playing the session to c offset behind live, as far back as
+// the recording goes. The client is told the actual offset with a REWIND
+// frame, then gets the screen as it was and the output since, in real time.
+func (s *Screen) rewind(c *Client, offset time.Duration) {
+	s.pubMu.Lock()
+	defer s.pubMu.Unlock()
+
+	start, ok := s.rec.oldest()
+	if !ok || s.forwarding {
+		return
+	}
+	now := time.Now()
+	offset = min(offset, now.Sub(start)).Truncate(time.Second)
+	if offset <= 0 {
+		return
+	}
+
+	if c.dvr != nil {
+		close(c.dvr.stop)
+	}
+	k, due := s.rec.seek(now.Add(-offset))
+
+	snap := k.frames
+	if s.key != nil {
+		snap = s.seal(e2e.Keyframe, snap)
+	}
+	err := c.Send(constants.REWIND, strconv.AppendInt(nil, int64(offset/time.Second), 10))
+	if err == nil {
+		err = sendFrames(c, snap)
+	}
+	if err != nil {
+		c.dvr = nil
+		c.Close()
+		return
+	}
+	next := k.seq + 1
+	for _, f := range due {
+		_ = sendFrames(c, s.outgoing(f))
+		next = f.seq + 1
+	}
+
+	p := &player{offset: offset, next: next, stop: make(chan struct{})}
+	c.dvr = p
+	go s.play(c, p)
+}
+
+// live returns a time-shifted client to the broadcast: a LIVE frame, the
+// current snapshot, and its SEQ. A client in diff mode gets a full diff
+// instead. pubMu must be held.
+func (s *Screen) live(c *Client) {
+	close(c.dvr.stop)
+	c.dvr = nil
+	_ = c.Send(constants.LIVE, nil)
+	if c.Diff {
+		c.last, c.sent = nil, 0
+		s.sendDiff(c, s.mt.Snapshot())
+		return
+	}
+	s.updateToCurrentState(c)
+	_ = c.Send(constants.SEQ, formatMark(s.epoch, s.seq))
+}
+
+// play sends the recorded frames as they fall due until the client goes live
+// or disconnects. A client left behind the recording is sent back to live.
+func (s *Screen) play(c *Client, p *player) {
+	ticker := time.NewTicker(dvrTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-c.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.pubMu.Lock()
+		due, ok := s.rec.from(p.next, time.Now().Add(-p.offset))
+		switch {
+		case c.dvr != p:
+		case !ok:
+			s.live(c)
+		default:
+			for _, f := range due {
+				_ = sendFrames(c, s.outgoing(f))
+				p.next = f.seq + 1
+			}
+		}
+		s.pubMu.Unlock()
+	}
+}
@@ -0,0 +1,126 @@
+package screen
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crgimenes/compterm/constants"
+)
+
+func TestRecording(t *testing.T) {
+	t0 := time.Now()
+	at := func(s int) time.Time { return t0.Add(time.Duration(s) * time.Second) }
+
+	var r recording
+	r.add(frame{seq: 1, payload: []byte("x"), at: at(0)}) // no keyframe yet: dropped
+	r.addKey(keyframe{at: at(0), seq: 1})
+	for i := uint64(2); i <= 30; i++ {
+		r.add(frame{seq: i, payload: []byte("x"), at: at(int(i) * 10)})
+		if i%10 == 0 {
+			r.addKey(keyframe{at: at(int(i) * 10), seq: i})
+		}
+	}
+	// frames at 20s..300s, keyframes at 0, 100, 200 and 300s; a 5 minute
+	// window from 300s still needs the first
+	if len(r.keys) != 4 || len(r.frames) != 29 {
+		t.Fatalf("recording holds %d keyframes and %d frames, want 4 and 29", len(r.keys), len(r.frames))
+	}
+
+	tests := []struct {
+		name   string
+		target int
+		key    uint64
+		due    []uint64
+	}{
+		{"before the recording", -50, 1, nil},
+		{"at a keyframe", 100, 10, nil},
+		{"between keyframes", 135, 10, []uint64{11, 12, 13}},
+		{"live", 300, 30, nil},
+	}
+	for _, tt := range tests {
+		k, due := r.seek(at(tt.target))
+		var got []uint64
+		for _, f := range due {
+			got = append(got, f.seq)
+		}
+		if k.seq != tt.key || len(got) != len(tt.due) || (len(got) > 0 && got[len(got)-1] != tt.due[len(tt.due)-1]) {
+			t.Errorf("%s: seek = keyframe %d, frames %v; want %d, %v", tt.name, k.seq, got, tt.key, tt.due)
+		}
+	}
+
+	// once the second keyframe reaches back a full window the first goes,
+	// with the frames only it led to
+	r.add(frame{seq: 31, payload: []byte("x"), at: at(400)})
+	if r.keys[0].seq != 10 || r.frames[0].seq != 11 {
+		t.Fatalf("after eviction: keyframe %d, first frame %d; want 10, 11", r.keys[0].seq, r.frames[0].seq)
+	}
+	if _, ok := r.from(5, at(400)); ok {
+		t.Fatal("from(5) found frames that were dropped")
+	}
+	if due, ok := r.from(30, at(400)); !ok || len(due) != 2 {
+		t.Fatalf("from(30) = %d frames, %v; want 2, true", len(due), ok)
+	}
+}
+
+// TestRewind rewinds a client, lets its player send a recorded frame, and
+// brings it back to live, while the broadcast goes on without it.
+func TestRewind(t *testing.T) {
+	s := New(5, 20)
+	s.publish(constants.MSG, []byte("old"))
+	s.publish(constants.MSG, []byte("new"))
+
+	// pretend "old" was published a minute ago and "new" 44.8 seconds ago
+	now := time.Now()
+	s.pubMu.Lock()
+	s.rec.keys[0].at = now.Add(-time.Minute)
+	s.rec.frames[0].at = now.Add(-44800 * time.Millisecond)
+	s.pubMu.Unlock()
+
+	c := bareClient()
+	s.AttachClient(c)
+	s.control(c, constants.REWIND, []byte("45"))
+	s.publish(constants.MSG, []byte("live"))
+
+	// wait for the player to send "new"
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.pubMu.Lock()
+		next := c.dvr.next
+		s.pubMu.Unlock()
+		if next == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("player never sent the recorded frame")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	s.control(c, constants.LIVE, nil)
+
+	cmds, payloads := drain(t, c)
+	want := []byte{
+		// attach
+		constants.RESIZE, constants.MSG, constants.SEQ,
+		// rewound: the keyframe, then "new" from the player
+		constants.REWIND, constants.RESIZE, constants.MSG, constants.MSG,
+		// back to live
+		constants.LIVE, constants.RESIZE, constants.MSG, constants.SEQ,
+	}
+	if !bytes.Equal(cmds, want) {
+		t.Fatalf("cmds = %v, want %v", cmds, want)
+	}
+	if payloads[3] != "45" {
+		t.Fatalf("REWIND = %q, want 45", payloads[3])
+	}
+	if !strings.Contains(payloads[5], "old") || strings.Contains(payloads[5], "new") {
+		t.Fatalf("rewound screen = %q, want only the old output", payloads[5])
+	}
+	if payloads[6] != "new" {
+		t.Fatalf("played frame = %q, want new", payloads[6])
+	}
+	if !strings.Contains(payloads[9], "live") {
+		t.Fatalf("live snapshot = %q, want the live output", payloads[9])
+	}
+}
@@ -0,0 +1,121 @@
+package screen
+
+import (
+	"slices"
+
+	"github.com/crgimenes/compterm/constants"
+	"github.com/crgimenes/compterm/e2e"
+	"github.com/crgimenes/compterm/protocol"
+)
+
+// keyframeInterval is how much output an encrypted screen publishes between
+// keyframes. It bounds what a relay replays to a late joiner.
+const keyframeInterval = 64 << 10 // 64K
+
+// sealChunk bounds the plaintext sealed into one ENC frame so the frame still
+// fits a client's send buffer.
+const sealChunk = constants.BufferSize - e2e.Overhead - 2*protocol.Overhead - 64
+
+// Encrypt seals everything the screen sends from now on with key: output and
+// resizes travel as ENC frames, snapshots as keyframes, and a fresh keyframe
+// follows every keyframeInterval bytes of output so that relays, which cannot
+// build snapshots of their own, can start late joiners. SEQ frames stay in
+// the clear for resuming. Call it before attaching clients.
+func (s *Screen) Encrypt(key *e2e.Key) {
+	s.pubMu.Lock()
+	defer s.pubMu.Unlock()
+	s.key = key
+}
+
+// Forward relays an ENC payload from an encrypted upstream without opening
+// it. Once forwarding, the screen's own (empty) state is meaningless: it keeps
+// the latest keyframe and the payloads after it instead, and starts every new
+// client there.
+func (s *Screen) Forward(payload []byte) {
+	s.pubMu.Lock()
+	defer s.pubMu.Unlock()
+
+	p := slices.Clone(payload)
+	s.forwarding = true
+	switch {
+	case e2e.IsKeyframe(p):
+		s.sealed, s.sealedSize = append(s.sealed[:0], p), len(p)
+	case len(s.sealed) > 0 && s.sealedSize+len(p) <= historyLimit:
+		s.sealed = append(s.sealed, p)
+		s.sealedSize += len(p)
+	default:
+		// no keyframe yet, or too much since it: new clients wait for the next
+		clear(s.sealed)
+		s.sealed, s.sealedSize = s.sealed[:0], 0
+	}
+
+	s.broadcast([]frame{{cmd: constants.ENC, payload: p}}, nil)
+}
+
+// joinForwarded attaches a client to a forwarding screen, starting it at the
+// latest keyframe.
+func (s *Screen) joinForwarded(c *Client) {
+	for _, p := range s.sealed {
+		_ = c.Send(constants.ENC, p)
+	}
+	s.addClient(c)
+}
+
+// outgoing returns what clients are sent for a published frame: the frame
+// itself, or the ENC frames sealing it.
+func (s *Screen) outgoing(f frame) []frame {
+	if s.key == nil {
+		return []frame{f}
+	}
+	return s.seal(0, []frame{f})
+}
+
+// maybeKeyframe counts n bytes of output and broadcasts a keyframe once
+// keyframeInterval bytes have gone out since the last one. Clients already in
+// sync skip it.
+func (s *Screen) maybeKeyframe(n int) {
+	s.sinceKey += n
+	if s.sinceKey < keyframeInterval {
+		return
+	}
+	s.sinceKey = 0
+	s.broadcast(s.seal(e2e.Keyframe, s.snapshot(keyframeScrollback)), nil)
+}
+
+// seal packs frames into as few ENC frames as fit, splitting long output on
+// rune boundaries. Only the first carries flags, so a keyframe split in parts
+// is a keyframe followed by deltas.
+func (s *Screen) seal(flags byte, frames []frame) []frame {
+	var (
+		out   []frame
+		plain []byte
+	)
+	flush := func() {
+		if len(plain) == 0 {
+			return
+		}
+		out = append(out, frame{cmd: constants.ENC, payload: s.key.Seal(flags, plain)})
+		flags, plain = 0, nil
+	}
+
+	for _, f := range frames {
+		p := f.payload
+		for first := true; first || len(p) > 0; first = false {
+			n := len(p)
+			if n > sealChunk {
+				n = completeRunePrefix(p[:sealChunk])
+				if n == 0 {
+					n = sealChunk
+				}
+			}
+			if len(plain)+n+protocol.Overhead > sealChunk {
+				flush()
+			}
+			plain = protocol.Append(plain, p[:n], f.cmd)
+			p = p[n:]
+		}
+	}
+	flush()
+
+	return out
+}
@@ -0,0 +1,143 @@
+package screen
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/crgimenes/compterm/constants"
+	"github.com/crgimenes/compterm/e2e"
+	"github.com/crgimenes/compterm/protocol"
+)
+
+// open decrypts an ENC payload and decodes the frames inside it.
+func open(t *testing.T, key *e2e.Key, payload string) (flags byte, cmds []byte, payloads []string) {
+	t.Helper()
+	h, plain, err := key.Open([]byte(payload))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	buf := make([]byte, constants.BufferSize)
+	err = protocol.DecodeFrames(buf, plain, func(cmd byte, p []byte) {
+		cmds = append(cmds, cmd)
+		payloads = append(payloads, string(p))
+	})
+	if err != nil {
+		t.Fatalf("decode sealed frames: %v", err)
+	}
+	return h.Flags, cmds, payloads
+}
+
+func TestEncrypt(t *testing.T) {
+	key, err := e2e.ParseKey(e2e.NewKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(5, 20)
+	s.Encrypt(key)
+
+	_, _ = s.Write([]byte("secret"))
+	waitSeq(t, s, 1)
+
+	// a new client gets the snapshot as a keyframe, then its SEQ in the clear
+	snap := bareClient()
+	s.AttachClient(snap)
+	cmds, payloads := drain(t, snap)
+	if !bytes.Equal(cmds, []byte{constants.ENC, constants.SEQ}) {
+		t.Fatalf("snapshot cmds = %v, want [ENC SEQ]", cmds)
+	}
+	flags, inner, innerPayloads := open(t, key, payloads[0])
+	if flags != e2e.Keyframe || !bytes.Equal(inner, []byte{constants.RESIZE, constants.MSG}) {
+		t.Fatalf("snapshot = flags %d, cmds %v; want a keyframe of [RESIZE MSG]", flags, inner)
+	}
+	if !strings.Contains(innerPayloads[1], "secret") {
+		t.Fatalf("snapshot = %q, want the screen content", innerPayloads[1])
+	}
+
+	// live output longer than one ENC frame is split on rune boundaries and,
+	// past keyframeInterval, followed by an unnumbered keyframe (the client is
+	// attached directly to skip its snapshot)
+	c := bareClient()
+	s.mx.Lock()
+	s.Clients = []*Client{c}
+	s.mx.Unlock()
+
+	big := strings.Repeat("é", constants.BufferSize/2+100)
+	s.publish(constants.MSG, []byte(big))
+	cmds, payloads = drain(t, c)
+
+	var (
+		got  strings.Builder
+		i    int
+		keys int
+	)
+	for ; cmds[i] == constants.ENC; i++ {
+		flags, inner, innerPayloads := open(t, key, payloads[i])
+		if flags != 0 || len(inner) != 1 || inner[0] != constants.MSG {
+			t.Fatalf("delta %d = flags %d, cmds %v; want one MSG", i, flags, inner)
+		}
+		if strings.Contains(payloads[i], strings.Repeat("é", 8)) {
+			t.Fatalf("delta %d carries plaintext", i)
+		}
+		got.WriteString(innerPayloads[0])
+	}
+	if i < 2 || got.String() != big {
+		t.Fatalf("output sealed in %d frames, reassembled %d bytes; want ≥2 frames and %d bytes", i, got.Len(), len(big))
+	}
+	if cmds[i] != constants.SEQ {
+		t.Fatalf("cmd after the deltas = %d, want SEQ", cmds[i])
+	}
+	for _, cmd := range cmds[i+1:] {
+		if cmd != constants.ENC {
+			t.Fatalf("keyframe cmds = %v, want only ENC", cmds[i+1:])
+		}
+		if flags, _, _ := open(t, key, payloads[i+1+keys]); (flags == e2e.Keyframe) != (keys == 0) {
+			t.Fatalf("keyframe part %d has flags %d", keys, flags)
+		}
+		keys++
+	}
+	if keys == 0 {
+		t.Fatal("no keyframe after keyframeInterval bytes of output")
+	}
+}
+
+func TestForward(t *testing.T) {
+	key, err := e2e.ParseKey(e2e.NewKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(5, 20)
+
+	early := string(key.Seal(0, []byte("before any keyframe")))
+	kf := string(key.Seal(e2e.Keyframe, []byte("keyframe")))
+	delta := string(key.Seal(0, []byte("delta")))
+
+	live := bareClient()
+	s.Forward([]byte(early))
+	s.AttachClient(live)
+	s.Forward([]byte(kf))
+	s.Forward([]byte(delta))
+
+	late := bareClient()
+	s.AttachClient(late)
+
+	tests := []struct {
+		name string
+		c    *Client
+		want []string
+	}{
+		{"live", live, []string{kf, delta}},
+		{"late joiner starts at the keyframe", late, []string{kf, delta}},
+	}
+	for _, tt := range tests {
+		cmds, payloads := drain(t, tt.c)
+		if !bytes.Equal(cmds, []byte{constants.ENC, constants.ENC}) {
+			t.Fatalf("%s: cmds = %v, want two ENC frames and no SEQ", tt.name, cmds)
+		}
+		for i := range payloads {
+			if payloads[i] != tt.want[i] {
+				t.Fatalf("%s: payload %d differs from what was forwarded", tt.name, i)
+			}
+		}
+	}
+}
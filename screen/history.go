@@ -0,0 +1,86 @@
+package screen
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/crgimenes/compterm/session"
+)
+
+// historyLimit is how many payload bytes of recent frames are kept for
+// resuming viewers. Older frames are dropped; a viewer whose gap reaches past
+// them gets a full snapshot instead.
+const historyLimit = 1 << 20 // 1M
+
+// frame is a broadcast frame kept for replay.
+type frame struct {
+	seq     uint64
+	cmd     byte
+	payload []byte
+	at      time.Time // when it was published, for the recording
+}
+
+// history is a ring of the most recent broadcast frames, bounded by the total
+// payload size. It is guarded by Screen.pubMu.
+type history struct {
+	frames []frame
+	size   int
+	limit  int
+}
+
+func (h *history) add(f frame) {
+	h.frames = append(h.frames, f)
+	h.size += len(f.payload)
+
+	drop := 0
+	for h.size > h.limit && drop < len(h.frames)-1 {
+		h.size -= len(h.frames[drop].payload)
+		drop++
+	}
+	if drop > 0 {
+		// shift instead of reslicing so the backing array does not grow forever
+		n := copy(h.frames, h.frames[drop:])
+		clear(h.frames[n:])
+		h.frames = h.frames[:n]
+	}
+}
+
+// since returns the frames after seq, or false when frames after seq were
+// already dropped.
+func (h *history) since(seq, head uint64) ([]frame, bool) {
+	if seq == head {
+		return nil, true
+	}
+	if seq > head || len(h.frames) == 0 || h.frames[0].seq > seq+1 {
+		return nil, false
+	}
+	i := int(seq + 1 - h.frames[0].seq)
+	return h.frames[i:], true
+}
+
+// newEpoch names a stream. Sequence numbers restart with every Screen, so a
+// resume position is only meaningful within the epoch it was issued in.
+func newEpoch() string {
+	return session.RandomID()[:8]
+}
+
+// formatMark encodes a resume position as carried by SEQ frames and the since
+// query parameter: "epoch:seq".
+func formatMark(epoch string, seq uint64) []byte {
+	return fmt.Appendf(nil, "%s:%d", epoch, seq)
+}
+
+// ParseMark decodes a resume position written by formatMark.
+func ParseMark(mark string) (epoch string, seq uint64, ok bool) {
+	epoch, n, found := strings.Cut(mark, ":")
+	if !found || epoch == "" {
+		return "", 0, false
+	}
+	seq, err := strconv.ParseUint(n, 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return epoch, seq, true
+}
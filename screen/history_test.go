@@ -0,0 +1,163 @@
+package screen
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/crgimenes/compterm/constants"
+	"github.com/crgimenes/compterm/protocol"
+)
+
+func TestHistorySince(t *testing.T) {
+	h := history{limit: 10}
+	for i := uint64(1); i <= 5; i++ {
+		h.add(frame{seq: i, cmd: constants.MSG, payload: []byte("abc")})
+	}
+	// 15 bytes over a 10-byte limit: frames 1 and 2 were dropped
+	if len(h.frames) != 3 || h.frames[0].seq != 3 {
+		t.Fatalf("kept frames from seq %d (%d frames), want 3 (3 frames)", h.frames[0].seq, len(h.frames))
+	}
+
+	tests := []struct {
+		name  string
+		seq   uint64
+		want  []uint64
+		found bool
+	}{
+		{"up to date", 5, nil, true},
+		{"one behind", 4, []uint64{5}, true},
+		{"oldest kept gap", 2, []uint64{3, 4, 5}, true},
+		{"too old", 1, nil, false},
+		{"from the future", 9, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := h.since(tt.seq, 5)
+			if ok != tt.found {
+				t.Fatalf("since(%d) ok = %v, want %v", tt.seq, ok, tt.found)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("since(%d) = %d frames, want %d", tt.seq, len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i].seq != tt.want[i] {
+					t.Fatalf("since(%d)[%d] = seq %d, want %d", tt.seq, i, got[i].seq, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseMark(t *testing.T) {
+	tests := []struct {
+		in    string
+		epoch string
+		seq   uint64
+		ok    bool
+	}{
+		{"ab12cd34:42", "ab12cd34", 42, true},
+		{"ab12cd34:0", "ab12cd34", 0, true},
+		{"", "", 0, false},
+		{":42", "", 0, false},
+		{"ab12cd34", "", 0, false},
+		{"ab12cd34:x", "", 0, false},
+	}
+	for _, tt := range tests {
+		epoch, seq, ok := ParseMark(tt.in)
+		if epoch != tt.epoch || seq != tt.seq || ok != tt.ok {
+			t.Errorf("ParseMark(%q) = %q, %d, %v", tt.in, epoch, seq, ok)
+		}
+	}
+}
+
+// drain decodes the frames queued for a bare client.
+func drain(t *testing.T, c *Client) (cmds []byte, payloads []string) {
+	t.Helper()
+	_ = c.bs.Close()
+	var raw bytes.Buffer
+	buf := make([]byte, constants.BufferSize)
+	for {
+		n, err := c.bs.Read(buf)
+		if err != nil {
+			break
+		}
+		raw.Write(buf[:n])
+	}
+
+	data := raw.Bytes()
+	for len(data) > 0 {
+		cmd, n, err := protocol.Decode(buf, data)
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		cmds = append(cmds, cmd)
+		payloads = append(payloads, string(buf[:n]))
+		data = data[n+protocol.Overhead:]
+	}
+	return cmds, payloads
+}
+
+// waitSeq waits for the pump to publish up to seq.
+func waitSeq(t *testing.T, s *Screen, seq uint64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		s.pubMu.Lock()
+		cur := s.seq
+		s.pubMu.Unlock()
+		if cur >= seq {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("screen never reached seq %d", seq)
+}
+
+func TestResumeClient(t *testing.T) {
+	s := New(5, 20)
+
+	_, _ = s.Write([]byte("one"))
+	waitSeq(t, s, 1)
+	mark := string(formatMark(s.epoch, 1))
+
+	_, _ = s.Write([]byte("two"))
+	waitSeq(t, s, 2)
+	s.publish(constants.RESIZE, []byte("6:20"))
+	_, _ = s.Write([]byte("three"))
+	waitSeq(t, s, 4)
+
+	// a known mark gets only the missed frames, framed by SEQs
+	c := bareClient()
+	if !s.ResumeClient(c, mark) {
+		t.Fatal("ResumeClient did not resume a recent mark")
+	}
+	cmds, payloads := drain(t, c)
+	wantCmds := []byte{constants.SEQ, constants.MSG, constants.RESIZE, constants.MSG, constants.SEQ}
+	if !bytes.Equal(cmds, wantCmds) {
+		t.Fatalf("resumed frames = %v, want %v", cmds, wantCmds)
+	}
+	if payloads[0] != mark || payloads[1] != "two" || payloads[2] != "6:20" || payloads[3] != "three" {
+		t.Fatalf("resumed payloads = %q", payloads)
+	}
+	if want := fmt.Sprintf("%s:4", s.epoch); payloads[4] != want {
+		t.Fatalf("closing SEQ = %q, want %q", payloads[4], want)
+	}
+
+	// a mark from another stream falls back to a snapshot
+	for _, m := range []string{"", "deadbeef:1", "garbage"} {
+		c := bareClient()
+		if s.ResumeClient(c, m) {
+			t.Fatalf("ResumeClient(%q) resumed, want a snapshot", m)
+		}
+		cmds, payloads := drain(t, c)
+		if len(cmds) != 3 || cmds[0] != constants.RESIZE || cmds[1] != constants.MSG || cmds[2] != constants.SEQ {
+			t.Fatalf("snapshot frames for %q = %v", m, cmds)
+		}
+		if !bytes.Contains([]byte(payloads[1]), []byte("onetwo")) {
+			t.Fatalf("snapshot for %q = %q, want the screen content", m, payloads[1])
+		}
+	}
+}
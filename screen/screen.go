@@ -1,6 +1,7 @@
 package screen
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -13,6 +14,7 @@ import (
 	"github.com/coder/websocket"
 
 	"github.com/crgimenes/compterm/constants"
+	"github.com/crgimenes/compterm/e2e"
 	"github.com/crgimenes/compterm/mterm"
 	"github.com/crgimenes/compterm/protocol"
 	"github.com/crgimenes/compterm/stream"
@@ -22,9 +24,19 @@ import (
 // authoritative in-memory terminal emulator so new clients can be brought to
 // the current state.
 //
+// Every broadcast frame is numbered. Each is followed by a SEQ frame carrying
+// its position, and the most recent frames are kept so a viewer that
+// reconnects can resume with just the frames it missed.
+//
 // mx guards Clients, Rows, and Columns. The emulator (mt) and the broadcast
 // Stream are internally synchronized, so they are used without holding mx. The
-// lock is never held while sending to a client.
+// lock is never held while sending to a client. pubMu serializes publishing
+// with attaching, so a client sees every frame after its catch-up exactly once.
+//
+// An encrypted screen (see Encrypt and Forward, in e2e.go) seals everything
+// but the SEQ frames before it reaches a client. A client may also rewind the
+// session and watch it time-shifted (dvr.go), or be sent only the cells that
+// changed (diff.go) instead of the output.
 type Screen struct {
 	Columns int             `json:"columns"`
 	Rows    int             `json:"rows"`
@@ -33,21 +45,62 @@ type Screen struct {
 	mt      *mterm.Terminal `json:"-"`
 	mx      sync.Mutex      `json:"-"`
 
+	pubMu sync.Mutex `json:"-"`
+	epoch string     `json:"-"`
+	seq   uint64     `json:"-"`
+	hist  history    `json:"-"`
+	rec   recording  `json:"-"`
+
+	key      *e2e.Key `json:"-"`
+	sinceKey int      `json:"-"` // output bytes published since the last keyframe
+
+	forwarding bool     `json:"-"`
+	sealed     [][]byte `json:"-"` // forwarded: the latest keyframe and what followed
+	sealedSize int      `json:"-"`
+
+	diffOnce sync.Once `json:"-"`
+
 	// writeMu serializes Write so the stateful stream filters are safe.
 	writeMu sync.Mutex      `json:"-"`
 	clip    clipboardFilter `json:"-"`
 	clipBuf []byte          `json:"-"`
 	sgr     sgrFilter       `json:"-"`
 	sgrBuf  []byte          `json:"-"`
+	// queued counts the bytes written to the stream; resizes are the size
+	// changes waiting for the output before them. Guarded by writeMu.
+	queued  int64          `json:"-"`
+	resizes []queuedResize `json:"-"`
+}
+
+// queuedResize is a size change that applies once the stream is published up
+// to the offset at.
+type queuedResize struct {
+	at            int64
+	rows, columns int
 }
 
 type Client struct {
 	bs        *stream.Stream
-	conn      *websocket.Conn
+	conn      *websocket.Conn // nil for a client made by NewStreamClient
+	write     func(p []byte) error
 	SessionID string `json:"session_id"`
-	outbuff   []byte
-	mx        sync.Mutex
-	done      chan struct{}
+	// Diff asks for the diff mode: the client is sent what changed on the
+	// screen (DIFF frames, see package diff) instead of the output. Set it
+	// before attaching the client. A forwarding screen ignores it.
+	Diff    bool `json:"diff"`
+	outbuff []byte
+	mx      sync.Mutex
+	done    chan struct{}
+
+	// ctl handles the control frames the client may send; guarded by mx.
+	ctl func(c *Client, cmd byte, payload []byte)
+	// dvr plays the recording to a time-shifted client; guarded by the
+	// Screen's pubMu.
+	dvr *player
+	// last and sent are the state a diff-mode client was last sent and its
+	// sequence number; guarded by the Screen's pubMu.
+	last *mterm.Snapshot
+	sent uint64
 }
 
 func New(rows, columns int) *Screen {
@@ -56,6 +109,8 @@ func New(rows, columns int) *Screen {
 		Rows:    rows,
 		Stream:  stream.New(),
 		mt:      mterm.New(rows, columns),
+		epoch:   newEpoch(),
+		hist:    history{limit: historyLimit},
 	}
 
 	go s.writeToAttachedClients()
@@ -65,13 +120,76 @@ func New(rows, columns int) *Screen {
 
 // AttachClient attaches a client and brings it to the current screen state.
 func (s *Screen) AttachClient(c *Client) {
+	s.ResumeClient(c, "")
+}
+
+// ResumeClient attaches a client that already saw the stream up to mark, the
+// payload of the last SEQ frame it received. When the frames after mark are
+// still held it gets only those, introduced by a SEQ frame repeating mark;
+// otherwise (an empty, foreign, or too old mark) it gets a full snapshot. Either
+// way the catch-up ends with a SEQ frame for the current position. It reports
+// whether the client was resumed.
+func (s *Screen) ResumeClient(c *Client, mark string) bool {
+	s.pubMu.Lock()
+	defer s.pubMu.Unlock()
+
+	c.mx.Lock()
+	c.ctl = s.control
+	c.mx.Unlock()
+
+	if s.forwarding {
+		s.joinForwarded(c)
+		return false
+	}
+
+	if c.Diff {
+		// there is no resuming a diff: the client starts over from a full one
+		s.attachDiff(c)
+		s.addClient(c)
+		return false
+	}
+
+	var (
+		missed  []frame
+		resumed bool
+	)
+	if epoch, seq, ok := ParseMark(mark); ok && epoch == s.epoch {
+		missed, resumed = s.hist.since(seq, s.seq)
+	}
+
+	var err error
+	if resumed {
+		err = c.Send(constants.SEQ, []byte(mark))
+		for _, f := range missed {
+			if err == nil {
+				err = sendFrames(c, s.outgoing(f))
+			}
+		}
+	} else {
+		err = s.updateToCurrentState(c)
+	}
+	if err == nil {
+		err = c.Send(constants.SEQ, formatMark(s.epoch, s.seq))
+	}
+	if err != nil {
+		// a client that missed part of the catch-up would show a wrong screen
+		log.Printf("error bringing a client up to date: %s\r\n", err)
+		c.Close()
+		return false
+	}
+
+	s.addClient(c)
+
+	return resumed
+}
+
+// addClient adds c to the attached clients, once.
+func (s *Screen) addClient(c *Client) {
 	s.mx.Lock()
+	defer s.mx.Unlock()
 	if !slices.Contains(s.Clients, c) {
 		s.Clients = append(s.Clients, c)
 	}
-	s.mx.Unlock()
-
-	s.updateToCurrentState(c)
 }
 
 // size returns the current dimensions under the lock.
@@ -98,9 +216,36 @@ func (s *Screen) removeClients(dead []*Client) {
 	})
 }
 
-// broadcast sends a framed message to every attached client and detaches the
-// ones that are closed or fail to receive it.
-func (s *Screen) broadcast(prefix byte, p []byte) {
+// publish numbers a frame, records it for resuming clients, and broadcasts it
+// followed by its SEQ frame. Output (MSG) and size changes (RESIZE) are
+// applied to the emulator here, not on Write or Resize, so a snapshot always
+// matches the last published sequence number.
+func (s *Screen) publish(prefix byte, p []byte) {
+	s.pubMu.Lock()
+	defer s.pubMu.Unlock()
+
+	switch prefix {
+	case constants.MSG:
+		_, _ = s.mt.Write(p)
+	case constants.RESIZE:
+		s.applySize(p)
+	}
+
+	s.seq++
+	f := frame{seq: s.seq, cmd: prefix, payload: slices.Clone(p), at: time.Now()}
+	s.hist.add(f)
+	s.record(f)
+	s.broadcast(s.outgoing(f), formatMark(s.epoch, s.seq))
+
+	if s.key != nil {
+		s.maybeKeyframe(len(p))
+	}
+}
+
+// broadcast sends frames, then the SEQ frame carrying mark (if any), to every
+// attached client and detaches the ones that are closed or fail to receive
+// them.
+func (s *Screen) broadcast(frames []frame, mark []byte) {
 	var dead []*Client
 
 	for _, c := range s.snapshotClients() {
@@ -108,7 +253,14 @@ func (s *Screen) broadcast(prefix byte, p []byte) {
 			dead = append(dead, c)
 			continue
 		}
-		if err := c.Send(prefix, p); err != nil {
+		if c.dvr != nil || (c.Diff && !s.forwarding) {
+			continue // time-shifted, or in diff mode: fed on their own
+		}
+		err := sendFrames(c, frames)
+		if err == nil && mark != nil {
+			err = c.Send(constants.SEQ, mark)
+		}
+		if err != nil {
 			log.Printf("error writing to websocket: %s\r\n", err)
 			c.Close()
 			dead = append(dead, c)
@@ -121,8 +273,10 @@ func (s *Screen) broadcast(prefix byte, p []byte) {
 }
 
 func (s *Screen) writeToAttachedClients() {
-	buf := make([]byte, constants.BufferSize)
-	carry := 0 // bytes of an incomplete trailing UTF-8 rune, held at buf's front
+	// output is published in MSG frames that must fit a client's send buffer
+	buf := make([]byte, constants.BufferSize-protocol.Overhead)
+	carry := 0     // bytes of an incomplete trailing UTF-8 rune, held at buf's front
+	var read int64 // the stream offset buf[carry] is read at
 	for {
 		n, err := s.Read(buf[carry:])
 		if err != nil {
@@ -133,13 +287,26 @@ func (s *Screen) writeToAttachedClients() {
 			log.Printf("error reading from byte stream: %s\r\n", err)
 		}
 
+		// a resize queued within what was read goes out after the output
+		// before it, which ends in its CSI 8 t
+		start, end := 0, read+int64(n)
+		for r, ok := s.dueResize(end); ok; r, ok = s.dueResize(end) {
+			at := carry + int(r.at-read)
+			if at > start {
+				s.publish(constants.MSG, buf[start:at])
+			}
+			s.publish(constants.RESIZE, fmt.Appendf(nil, "%d:%d", r.rows, r.columns))
+			start = at
+		}
+		read = end
+
 		total := carry + n
 		// Never end a frame mid-rune: hold back an incomplete trailing UTF-8
 		// sequence so every client receives whole characters (image ANSI, which
 		// is dense with multibyte glyphs, would otherwise split into U+FFFD).
-		good := completeRunePrefix(buf[:total])
-		if good > 0 {
-			s.broadcast(constants.MSG, buf[:good])
+		good := start + completeRunePrefix(buf[start:total])
+		if good > start {
+			s.publish(constants.MSG, buf[start:good])
 		}
 		carry = total - good
 		copy(buf, buf[good:total])
@@ -163,50 +330,172 @@ func completeRunePrefix(b []byte) int {
 
 // Write implements io.Writer. It strips the host's clipboard sequences (OSC 52)
 // and normalizes colon-form color SGR to the semicolon form every xterm.js
-// renders correctly, then feeds the cleaned bytes to both the emulator and the
-// broadcast stream.
+// renders correctly, then queues the cleaned bytes on the broadcast stream. The
+// emulator is fed as the stream is published.
 func (s *Screen) Write(p []byte) (n int, err error) {
 	s.writeMu.Lock()
+	s.write(p)
+	s.writeMu.Unlock()
+	return len(p), nil
+}
+
+// write filters p onto the stream; writeMu must be held.
+func (s *Screen) write(p []byte) {
 	s.clipBuf = s.clip.filter(s.clipBuf[:0], p)
 	s.sgrBuf = s.sgr.filter(s.sgrBuf[:0], s.clipBuf)
-	_, _ = s.mt.Write(s.sgrBuf)
 	_, _ = s.Stream.Write(s.sgrBuf)
-	s.writeMu.Unlock()
-	return len(p), nil
+	s.queued += int64(len(s.sgrBuf))
+}
+
+func (s *Screen) updateToCurrentState(c *Client) error {
+	snap := s.snapshot(joinScrollback)
+	if s.key != nil {
+		snap = s.seal(e2e.Keyframe, snap)
+	}
+	return sendFrames(c, snap)
 }
 
-func (s *Screen) updateToCurrentState(c *Client) {
+const (
+	// joinScrollback bounds the scrollback lines a joining client gets.
+	joinScrollback = 500
+	// keyframeScrollback bounds them in the keyframes taken as output goes
+	// by, for the DVR and for encrypted relays; a client starting at one
+	// gets the scrollback that follows it.
+	keyframeScrollback = 0
+
+	// snapshotChunk bounds the MSG frames of a snapshot so each fits a
+	// client's send buffer.
+	snapshotChunk = constants.BufferSize - protocol.Overhead
+)
+
+// snapshot returns the frames that bring a client to the current state: the
+// size, then a redraw of the whole screen, below at most scrollback lines of
+// its scrollback, that leaves the cursor in place. The redraw is split into
+// MSG frames that fit a client's buffer.
+func (s *Screen) snapshot(scrollback int) []frame {
 	rows, columns := s.size()
-	crows, ccolumns := s.CursorPos()
-	msg := s.GetScreenAsANSI()
+	msg := s.mt.GetRecentScreenAsAnsi(scrollback)
 
-	_ = c.Send(constants.RESIZE,
-		fmt.Appendf(nil, "%d:%d", rows, columns))
+	m := fmt.Appendf(nil, "\033[8;%d;%dt\033[0;0H%s%s", rows, columns, msg, s.mt.CursorAsAnsi())
 
-	m := fmt.Sprintf("\033[8;%d;%dt\033[0;0H%s\033[%d;%dH",
-		rows, columns, msg, crows+1, ccolumns+1)
+	frames := []frame{{cmd: constants.RESIZE, payload: fmt.Appendf(nil, "%d:%d", rows, columns)}}
+	for len(m) > snapshotChunk {
+		n := ansiCut(m, snapshotChunk)
+		frames = append(frames, frame{cmd: constants.MSG, payload: m[:n]})
+		m = m[n:]
+	}
+	return append(frames, frame{cmd: constants.MSG, payload: m})
+}
 
-	_ = c.Send(constants.MSG, []byte(m))
+// ansiCut returns where to cut p at most n bytes in so that neither part
+// holds half an escape sequence or half a rune: before the escape sequence n
+// would split, or else at the last rune boundary.
+func ansiCut(p []byte, n int) int {
+	i := bytes.LastIndexByte(p[:n], 0x1b)
+	if i >= 0 && i+1 < len(p) && p[i+1] == '\\' {
+		// the string terminator of an OSC: the OSC starts further back
+		i = bytes.LastIndexByte(p[:i], 0x1b)
+	}
+	if i > 0 && seqEnd(p[i:n]) < 0 {
+		return i
+	}
+	if c := completeRunePrefix(p[:n]); c > 0 {
+		return c
+	}
+	return n
+}
+
+// seqEnd returns the length of the escape sequence p starts with, or -1 when
+// p ends before it does.
+func seqEnd(p []byte) int {
+	if len(p) < 2 {
+		return -1
+	}
+	switch p[1] {
+	case '[': // CSI: parameters and intermediates, then a final byte
+		for j := 2; j < len(p); j++ {
+			if p[j] >= 0x40 && p[j] <= 0x7e {
+				return j + 1
+			}
+		}
+	case ']': // OSC: up to BEL or ST
+		for j := 2; j < len(p); j++ {
+			switch {
+			case p[j] == 0x07:
+				return j + 1
+			case p[j] == 0x1b && j+1 < len(p) && p[j+1] == '\\':
+				return j + 2
+			}
+		}
+	default: // intermediates, then a final byte
+		for j := 1; j < len(p); j++ {
+			if p[j] >= 0x30 {
+				return j + 1
+			}
+		}
+	}
+	return -1
+}
+
+// sendFrames queues frames to c, stopping at the first error.
+func sendFrames(c *Client, frames []frame) error {
+	for _, f := range frames {
+		if err := c.Send(f.cmd, f.payload); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (s *Screen) Read(p []byte) (n int, err error) {
 	return s.Stream.Read(p)
 }
 
-// Resize resizes the screen and notifies attached clients.
+// Resize resizes the screen and notifies attached clients. The new size is
+// queued behind the output written so far: it applies, and goes out, once that
+// output is published.
 func (s *Screen) Resize(rows, columns int) {
-	s.mx.Lock()
-	if rows == s.Rows && columns == s.Columns {
-		s.mx.Unlock()
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	cur := queuedResize{}
+	if n := len(s.resizes); n > 0 {
+		cur = s.resizes[n-1]
+	} else {
+		cur.rows, cur.columns = s.size()
+	}
+	if rows == cur.rows && columns == cur.columns {
+		return
+	}
+
+	s.write(fmt.Appendf(nil, "\033[8;%d;%dt", rows, columns))
+	s.resizes = append(s.resizes, queuedResize{at: s.queued, rows: rows, columns: columns})
+}
+
+// dueResize takes the first queued resize off the queue if the stream was
+// read up to it, to offset read.
+func (s *Screen) dueResize(read int64) (queuedResize, bool) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if len(s.resizes) == 0 || s.resizes[0].at > read {
+		return queuedResize{}, false
+	}
+	r := s.resizes[0]
+	s.resizes = s.resizes[1:]
+	return r, true
+}
+
+// applySize resizes the screen to a RESIZE payload, "rows:columns".
+func (s *Screen) applySize(p []byte) {
+	var rows, columns int
+	if _, err := fmt.Sscanf(string(p), "%d:%d", &rows, &columns); err != nil {
 		return
 	}
+	s.mx.Lock()
+	defer s.mx.Unlock()
 	s.Rows = rows
 	s.Columns = columns
 	s.mt.Resize(rows, columns)
-	s.mx.Unlock()
-
-	_, _ = s.Write(fmt.Appendf(nil, "\033[8;%d;%dt", rows, columns))
-	s.broadcast(constants.RESIZE, fmt.Appendf(nil, "%d:%d", rows, columns))
 }
 
 // GetScreenAsANSI returns the current screen content as ANSI.
@@ -214,6 +503,31 @@ func (s *Screen) GetScreenAsANSI() []byte {
 	return s.mt.GetScreenAsAnsi()
 }
 
+// Snapshot returns a copy of the current screen, for rendering it.
+func (s *Screen) Snapshot() *mterm.Snapshot {
+	return s.mt.Snapshot()
+}
+
+// Scrollback returns a copy of the lines that scrolled off the screen, oldest
+// first.
+func (s *Screen) Scrollback() []mterm.Cell {
+	return s.mt.Scrollback()
+}
+
+// SnapshotWithScrollback returns a copy of the current screen and of the
+// lines that scrolled off it, taken at once so they join up.
+func (s *Screen) SnapshotWithScrollback() (*mterm.Snapshot, []mterm.Cell) {
+	return s.mt.SnapshotWithScrollback()
+}
+
+// Sealed reports whether the screen is end-to-end encrypted: encrypting its
+// output, or forwarding a sealed session whose screen it cannot see.
+func (s *Screen) Sealed() bool {
+	s.pubMu.Lock()
+	defer s.pubMu.Unlock()
+	return s.key != nil || s.forwarding
+}
+
 // CursorPos returns the cursor position.
 func (s *Screen) CursorPos() (rows, columns int) {
 	return s.mt.CursorPos()
@@ -226,6 +540,7 @@ func NewClient(conn *websocket.Conn) *Client {
 		outbuff: make([]byte, constants.BufferSize),
 		done:    make(chan struct{}),
 	}
+	c.write = c.writeWS
 
 	go c.writeLoop()
 	go c.rejectInput()
@@ -233,16 +548,41 @@ func NewClient(conn *websocket.Conn) *Client {
 	return c
 }
 
+// NewStreamClient returns a client for a transport other than a websocket,
+// such as Server-Sent Events: write is handed what the client is sent, whole
+// frames at a time, from a single goroutine. When write fails the client is
+// closed. Such a client cannot send anything, playback controls included.
+func NewStreamClient(write func(p []byte) error) *Client {
+	c := &Client{
+		bs:      stream.New(),
+		write:   write,
+		outbuff: make([]byte, constants.BufferSize),
+		done:    make(chan struct{}),
+	}
+
+	go c.writeLoop()
+
+	return c
+}
+
 func (c *Client) Close() {
 	select {
 	case <-c.done:
 		return
 	default:
 		close(c.done)
-		_ = c.conn.Close(websocket.StatusNormalClosure, "")
+		_ = c.bs.Close()
+		if c.conn != nil {
+			_ = c.conn.Close(websocket.StatusNormalClosure, "")
+		}
 	}
 }
 
+// Done returns a channel that is closed when the client disconnects.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
 // IsClosed reports whether the client is closed.
 func (c *Client) IsClosed() bool {
 	select {
@@ -274,15 +614,17 @@ func (c *Client) Send(prefix byte, p []byte) (err error) {
 }
 
 // rejectInput enforces compterm's one-way contract. A viewer must never send
-// anything to the host, so the connection is read only to detect disconnects
-// and to drop any client that tries to send data.
+// anything to the host: the connection is read to detect disconnects, to pass
+// on the viewer's own playback controls (REWIND and LIVE frames), and to drop a
+// client that sends anything else.
 func (c *Client) rejectInput() {
+	buf := make([]byte, constants.BufferSize)
 	for {
 		select {
 		case <-c.done:
 			return
 		default:
-			_, _, err := c.conn.Read(context.Background())
+			_, data, err := c.conn.Read(context.Background())
 			if err != nil {
 				cs := websocket.CloseStatus(err)
 				if cs != websocket.StatusNormalClosure &&
@@ -294,6 +636,10 @@ func (c *Client) rejectInput() {
 				return
 			}
 
+			if c.control(buf, data) {
+				continue
+			}
+
 			// The stream is one-way; a client that sends data is dropped.
 			log.Printf("client %q sent data on a read-only connection; closing\r\n", c.SessionID)
 			c.Close()
@@ -302,7 +648,31 @@ func (c *Client) rejectInput() {
 	}
 }
 
-// writeLoop drains the client stream to the websocket.
+// control passes the playback controls in data to the screen. It reports
+// false if data holds anything else, or the client is not attached yet.
+func (c *Client) control(buf, data []byte) bool {
+	c.mx.Lock()
+	ctl := c.ctl
+	c.mx.Unlock()
+	if ctl == nil {
+		return false
+	}
+
+	ok := true
+	err := protocol.DecodeFrames(buf, data, func(cmd byte, payload []byte) {
+		if cmd != constants.REWIND && cmd != constants.LIVE {
+			ok = false
+		}
+		if ok {
+			ctl(c, cmd, payload)
+		}
+	})
+	return ok && err == nil
+}
+
+// writeLoop drains the client stream to its transport. Messages are cut
+// wherever a read of the stream ends, not at frame boundaries: readers
+// reassemble the frames, as protocol.Stream does.
 func (c *Client) writeLoop() {
 	buff := make([]byte, constants.BufferSize)
 	for {
@@ -312,21 +682,30 @@ func (c *Client) writeLoop() {
 		default:
 			n, err := c.bs.Read(buff)
 			if err != nil {
-				log.Printf("error reading from byte stream: %s\r\n", err)
+				if err != io.EOF { // closed
+					log.Printf("error reading from byte stream: %s\r\n", err)
+				}
 				return
 			}
 
-			err = c.conn.Write(context.Background(), websocket.MessageBinary, buff[:n])
-			if err != nil {
-				cs := websocket.CloseStatus(err)
-				if cs != websocket.StatusNormalClosure &&
-					cs != websocket.StatusGoingAway &&
-					cs != -1 {
-					log.Printf("error writing to websocket: %s, %v\r\n", err, cs)
-				}
+			if err := c.write(buff[:n]); err != nil {
 				c.Close()
 				return
 			}
 		}
 	}
 }
+
+// writeWS sends p to the websocket as one binary message.
+func (c *Client) writeWS(p []byte) error {
+	err := c.conn.Write(context.Background(), websocket.MessageBinary, p)
+	if err != nil {
+		cs := websocket.CloseStatus(err)
+		if cs != websocket.StatusNormalClosure &&
+			cs != websocket.StatusGoingAway &&
+			cs != -1 {
+			log.Printf("error writing to websocket: %s, %v\r\n", err, cs)
+		}
+	}
+	return err
+}
@@ -1,10 +1,15 @@
 package screen
 
 import (
+	"bytes"
+	"fmt"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/crgimenes/compterm/constants"
+	"github.com/crgimenes/compterm/mterm"
 	"github.com/crgimenes/compterm/stream"
 )
 
@@ -76,3 +81,99 @@ func TestScreenConcurrency(t *testing.T) {
 
 	wg.Wait()
 }
+
+// TestResizeInOrder resizes the screen while output written before is still
+// queued: the resize must apply, and go out, after that output.
+func TestResizeInOrder(t *testing.T) {
+	s := New(5, 40)
+	_, _ = s.Write([]byte("\033[1;30HX"))
+	s.Resize(5, 20)
+	_, _ = s.Write([]byte("end"))
+
+	var out strings.Builder
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(out.String(), "end") {
+		if time.Now().After(deadline) {
+			t.Fatalf("screen never published the output, got %q", out.String())
+		}
+		time.Sleep(time.Millisecond)
+		out.Reset()
+		s.pubMu.Lock()
+		for _, f := range s.hist.frames {
+			if f.cmd == constants.RESIZE {
+				out.WriteString("<resize>")
+			} else {
+				out.Write(f.payload)
+			}
+		}
+		s.pubMu.Unlock()
+	}
+
+	if want := "\033[1;30HX\033[8;5;20t<resize>end"; out.String() != want {
+		t.Errorf("published %q, want %q", out.String(), want)
+	}
+	// X went to column 30 of 40, then wrapped to the second row
+	cells := s.Snapshot().Cells
+	if got := cells[20+9].Char; got != 'X' {
+		t.Errorf("cell at 2,10 = %q, want X; screen %q", got, s.GetScreenAsANSI())
+	}
+}
+
+func TestAnsiCut(t *testing.T) {
+	tests := []struct {
+		in   string
+		n    int
+		want int
+	}{
+		{"abcdef", 4, 4},
+		{"ab\033[31mcd", 4, 2},
+		{"ab\033[31mcd", 7, 7},
+		{"ab\033[31mcd", 8, 8},
+		{"ab\033]8;;http://x\033\\cd", 16, 2},
+		{"ab\033]8;;http://x\033\\cd", 17, 17},
+		{"ab\033]8;;http://x\033\\cd", 18, 18},
+		{"ab\0337cd", 3, 2},
+		{"a中b", 3, 1},
+	}
+	for _, tt := range tests {
+		if got := ansiCut([]byte(tt.in), tt.n); got != tt.want {
+			t.Errorf("ansiCut(%q, %d) = %d, want %d", tt.in, tt.n, got, tt.want)
+		}
+	}
+}
+
+// TestLargeSnapshot joins a client to a screen with a long colored
+// scrollback: the snapshot must arrive whole, in frames a client can take.
+func TestLargeSnapshot(t *testing.T) {
+	s := New(50, 200)
+	var out bytes.Buffer
+	for i := range 1100 {
+		for j := range 20 {
+			fmt.Fprintf(&out, "\033[38;2;%d;%d;%dm%d", i%256, j*10, 255-i%256, j)
+		}
+		fmt.Fprintf(&out, "\033[0m line %d\r\n", i)
+	}
+	_, _ = s.mt.Write(out.Bytes())
+
+	c := bareClient()
+	s.AttachClient(c)
+	cmds, payloads := drain(t, c)
+
+	mirror := mterm.New(50, 200)
+	var size int
+	for i, cmd := range cmds {
+		if cmd == constants.MSG {
+			_, _ = mirror.Write([]byte(payloads[i]))
+			size += len(payloads[i])
+		}
+	}
+	if n := strings.Count(string(cmds), string(rune(constants.MSG))); n < 2 {
+		t.Errorf("snapshot of %d bytes in %d MSG frames", size, n)
+	}
+	if got, want := mirror.GetScreenAsAnsi(), s.mt.GetRecentScreenAsAnsi(joinScrollback); string(got) != string(want) {
+		t.Error("the mirror does not match the screen")
+	}
+	if got := len(mirror.Scrollback()) / 200; got != joinScrollback {
+		t.Errorf("mirror scrollback = %d lines, want %d", got, joinScrollback)
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+
+	"github.com/crgimenes/compterm/session"
+)
+
+// authorize reports whether a connection is allowed. An empty requiredToken
+// disables authentication entirely.
+func authorize(requiredToken, providedToken string, sessionAuthed bool) bool {
+	if requiredToken == "" {
+		return true
+	}
+	if sessionAuthed {
+		return true
+	}
+	return providedToken != "" &&
+		subtle.ConstantTimeCompare([]byte(providedToken), []byte(requiredToken)) == 1
+}
+
+// tokenFromRequest extracts an access token from the query string or header,
+// supporting shared links and non-browser clients.
+func tokenFromRequest(r *http.Request) string {
+	if t := r.URL.Query().Get("token"); t != "" {
+		return t
+	}
+	return r.Header.Get("X-Auth-Token")
+}
+
+func (s *Server) isAuthorized(r *http.Request, sd *session.SessionData) bool {
+	if s.opts.Authorize != nil {
+		return s.opts.Authorize(r)
+	}
+	return authorize(s.opts.AuthToken, tokenFromRequest(r), sd != nil && sd.Authenticated)
+}
+
+// isViewAuthorized widens isAuthorized with the embed token. That token is
+// scoped to watching: it opens /embed, the websocket, and the event stream but
+// never logs a session in, so it cannot be traded for the full viewer.
+func (s *Server) isViewAuthorized(r *http.Request, sd *session.SessionData) bool {
+	if s.isAuthorized(r, sd) {
+		return true
+	}
+	return s.opts.EmbedToken != "" &&
+		authorize(s.opts.EmbedToken, tokenFromRequest(r), false)
+}
+
+// loginEnabled reports whether viewers log in with the access token. An
+// Authorize hook replaces the token, and with it the login page.
+func (s *Server) loginEnabled() bool {
+	return s.opts.Authorize == nil && s.opts.AuthToken != ""
+}
+
+// isPage reports whether path is an HTML page rather than a static asset.
+// Only pages sit behind the login gate: scripts, styles, fonts, and icons are
+// the same for every deployment, and an embedded viewer on another site has no
+// session cookie to fetch them with.
+func isPage(path string) bool {
+	return path == "/" || strings.HasSuffix(path, ".html")
+}
+
+// loginPageFmt is a self-contained login page; the first %s is where the form
+// posts, the second an optional error block.
+const loginPageFmt = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>compterm — login</title>
+<style>
+  html,body{height:100%%;margin:0;background:#000;color:#d4d4d4;font-family:monospace}
+  form{position:absolute;top:50%%;left:50%%;transform:translate(-50%%,-50%%);
+    display:flex;flex-direction:column;gap:.75rem;min-width:16rem}
+  h1{margin:0 0 .5rem;font-size:1.25rem;text-align:center}
+  input,button{padding:.6rem;font:inherit;border:1px solid #444;background:#111;
+    color:#d4d4d4;border-radius:4px}
+  button{cursor:pointer;background:#1b3a1b;border-color:#2d5a2d}
+  .error{margin:0;color:#ff6d67;text-align:center}
+</style>
+</head>
+<body>
+<form method="post" action="%s">
+<h1>compterm</h1>
+%s<input type="password" name="token" placeholder="Access token" autofocus
+  autocomplete="current-password">
+<button type="submit">Enter</button>
+</form>
+</body>
+</html>
+`
+
+func serveLogin(w http.ResponseWriter, status int, errMsg string) {
+	serveLoginForm(w, "login", status, errMsg)
+}
+
+// serveLoginForm serves the login page with its form posting to action, a
+// path relative to the current page.
+func serveLoginForm(w http.ResponseWriter, action string, status int, errMsg string) {
+	errBlock := ""
+	if errMsg != "" {
+		errBlock = `<p class="error">` + html.EscapeString(errMsg) + "</p>\n"
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintf(w, loginPageFmt, action, errBlock)
+}
+
+// redirectToBase sends a relative ("./") redirect set manually, so it resolves
+// in the browser against the current URL — working whether compterm is served
+// at the root or under a reverse-proxy subpath (e.g. /compterm/). net/http's
+// Redirect would absolutize it against the proxy-stripped path and send "/".
+func redirectToBase(w http.ResponseWriter) {
+	w.Header().Set("Location", "./")
+	w.WriteHeader(http.StatusSeeOther)
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/crgimenes/compterm/screen"
+)
+
+const (
+	// eventsPing is how often an idle event stream gets a comment, so proxies
+	// keep it open and a gone viewer is noticed.
+	eventsPing = 30 * time.Second
+	// eventsWriteTimeout bounds each write to an event stream.
+	eventsWriteTimeout = 10 * time.Second
+)
+
+var errEventsClosed = errors.New("event stream closed")
+
+// eventsHandler serves the session as Server-Sent Events, for networks that
+// block websocket upgrades. Each event's data is a base64 batch of the same
+// frames the websocket carries, snapshot first; the since and mode query
+// parameters work as they do there. The stream is one-way, so a viewer on it
+// cannot rewind.
+func (s *Server) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	sid, sd, ok := s.sessions.Get(r)
+	if !ok {
+		sid, sd = s.sessions.Create()
+	}
+
+	s.sessions.Save(w, r, sid, sd)
+
+	if !s.isViewAuthorized(r, sd) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// keep nginx and friends from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	var (
+		mu     sync.Mutex
+		closed bool
+		rc     = http.NewResponseController(w)
+	)
+	// send writes one event, unless the handler returned
+	send := func(p []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return errEventsClosed
+		}
+		_ = rc.SetWriteDeadline(time.Now().Add(eventsWriteTimeout))
+		if _, err := w.Write(p); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+	defer func() {
+		mu.Lock()
+		closed = true
+		mu.Unlock()
+	}()
+
+	if err := send([]byte(": compterm\n\n")); err != nil {
+		return
+	}
+
+	client := screen.NewStreamClient(func(p []byte) error {
+		ev := make([]byte, 0, len("data: \n\n")+base64.StdEncoding.EncodedLen(len(p)))
+		ev = append(ev, "data: "...)
+		ev = base64.StdEncoding.AppendEncode(ev, p)
+		return send(append(ev, "\n\n"...))
+	})
+	s.attachViewer(client, r, sid)
+	defer client.Close()
+
+	ping := time.NewTicker(eventsPing)
+	defer ping.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-client.Done():
+			return
+		case <-ping.C:
+			if send([]byte(": ping\n\n")) != nil {
+				return
+			}
+		}
+	}
+}
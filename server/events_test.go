@@ -0,0 +1,101 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crgimenes/compterm/constants"
+	"github.com/crgimenes/compterm/protocol"
+)
+
+// TestEvents watches the session over Server-Sent Events: the same frames as
+// the websocket, snapshot first, behind the same authorization and hooks.
+func TestEvents(t *testing.T) {
+	joined := make(chan Viewer, 1)
+	left := make(chan Viewer, 1)
+	s := New(Options{
+		AuthToken:     "s3cr3t",
+		OnViewerJoin:  func(v Viewer) { joined <- v },
+		OnViewerLeave: func(v Viewer) { left <- v },
+	})
+	defer s.Close()
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("without a token: status %d, want 401", res.StatusCode)
+	}
+
+	_, _ = s.Write([]byte("before"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/events?token=s3cr3t", nil)
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = res.Body.Close() }()
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	select {
+	case <-joined:
+	case <-ctx.Done():
+		t.Fatal("OnViewerJoin was not called")
+	}
+	_, _ = s.Write([]byte("after"))
+
+	var (
+		cmds []byte
+		out  strings.Builder
+		buf  = make([]byte, constants.BufferSize)
+		sc   = bufio.NewScanner(res.Body)
+	)
+	sc.Buffer(nil, 2*constants.BufferSize)
+	for !strings.Contains(out.String(), "after") && sc.Scan() {
+		data, ok := strings.CutPrefix(sc.Text(), "data: ")
+		if !ok {
+			continue // comments and blank separators
+		}
+		raw, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			t.Fatalf("event data: %v", err)
+		}
+		err = protocol.DecodeFrames(buf, raw, func(cmd byte, p []byte) {
+			cmds = append(cmds, cmd)
+			if cmd == constants.MSG {
+				out.Write(p)
+			}
+		})
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+	}
+	if !strings.Contains(out.String(), "after") {
+		t.Fatalf("stream ended (%v) with output %q", sc.Err(), out.String())
+	}
+	if len(cmds) < 3 || cmds[0] != constants.RESIZE || cmds[1] != constants.MSG || cmds[2] != constants.SEQ ||
+		!strings.Contains(out.String(), "before") {
+		t.Fatalf("stream = cmds %v, output %q; want the snapshot first", cmds, out.String())
+	}
+
+	cancel()
+	select {
+	case <-left:
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnViewerLeave was not called")
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/crgimenes/compterm/mterm"
+	"github.com/crgimenes/compterm/render"
+)
+
+// exportHandler serves the current screen as text (/api/screen.txt), HTML
+// (/api/screen.html), or JSON cells (/api/screen.json), for notes and bots.
+// ?scrollback=1 adds the lines that scrolled off above it. It takes the same
+// credentials as the viewer: a session, or the access token as the token
+// parameter or the X-Auth-Token header.
+func (s *Server) exportHandler(w http.ResponseWriter, r *http.Request) {
+	_, sd, _ := s.sessions.Get(r)
+	if !s.isAuthorized(r, sd) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if s.screen.Sealed() {
+		http.Error(w, "this session is end-to-end encrypted", http.StatusForbidden)
+		return
+	}
+
+	snap := s.screen.Snapshot()
+	scrollback := 0
+	if ok, _ := strconv.ParseBool(r.URL.Query().Get("scrollback")); ok {
+		var history []mterm.Cell
+		snap, history = s.screen.SnapshotWithScrollback()
+		snap = render.WithScrollback(snap, history)
+		scrollback = len(history) / snap.Cols
+	}
+
+	var (
+		body  []byte
+		ctype string
+	)
+	switch path.Ext(r.URL.Path) {
+	case ".txt":
+		body, ctype = render.Text(nil, snap), "text/plain; charset=utf-8"
+	case ".html":
+		body, ctype = htmlPage(snap, s.theme(), 0), "text/html; charset=utf-8"
+	default:
+		var err error
+		body, err = render.JSON(snap, scrollback, s.theme())
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		ctype = "application/json"
+	}
+
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(body)
+}
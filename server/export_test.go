@@ -0,0 +1,69 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExport(t *testing.T) {
+	s := New(Options{Rows: 2, Columns: 10, AuthToken: "s3cr3t"})
+	defer s.Close()
+	h := s.Handler()
+
+	_, _ = s.Write([]byte("first\r\nsecond\r\n\033[1mthird"))
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(string(s.Screen().GetScreenAsANSI()), "third") && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	get := func(target string, header ...string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if len(header) == 2 {
+			req.Header.Set(header[0], header[1])
+		}
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get("/api/screen.txt"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("without a token: status %d, want 401", rec.Code)
+	}
+
+	tests := []struct {
+		target, ctype, want string
+	}{
+		{"/api/screen.txt?token=s3cr3t", "text/plain; charset=utf-8", "second\nthird\n"},
+		{"/api/screen.txt?token=s3cr3t&scrollback=1", "text/plain; charset=utf-8", "first\nsecond\nthird\n"},
+		{"/api/screen.html?token=s3cr3t", "text/html; charset=utf-8", `<span style="font-weight:bold">third</span>`},
+	}
+	for _, tt := range tests {
+		rec := get(tt.target)
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != tt.ctype {
+			t.Fatalf("GET %s = %d %q", tt.target, rec.Code, rec.Header().Get("Content-Type"))
+		}
+		if body := rec.Body.String(); body != tt.want && !strings.Contains(body, tt.want) {
+			t.Errorf("GET %s = %q, want %q", tt.target, body, tt.want)
+		}
+	}
+
+	rec := get("/api/screen.json?scrollback=1", "X-Auth-Token", "s3cr3t")
+	var doc struct {
+		Rows, Scrollback int
+		Lines            []struct {
+			Text  string
+			Cells []struct{ Bold bool }
+		}
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("GET /api/screen.json: %v\n%s", err, rec.Body)
+	}
+	if doc.Rows != 2 || doc.Scrollback != 1 || len(doc.Lines) != 3 ||
+		doc.Lines[2].Text != "third" || !doc.Lines[2].Cells[0].Bold {
+		t.Fatalf("GET /api/screen.json = %s", rec.Body)
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/crgimenes/compterm/mterm"
+	"github.com/crgimenes/compterm/render"
+)
+
+const (
+	// plainRefresh is how often the /plain page reloads by default, in seconds.
+	plainRefresh    = 2
+	plainMaxRefresh = 3600
+)
+
+// plainHandler serves the screen rendered on the server as HTML, for browsers
+// that cannot run the viewer: e-readers, text browsers, old devices. The page
+// reloads itself with a meta refresh every ?refresh= seconds (0 stops). It is
+// a page like the viewer, behind the same login, whose form posts back here so
+// a viewer without JavaScript stays on this page. An end-to-end encrypted
+// session is only readable with its key, in the browser, so it is not
+// rendered here.
+func (s *Server) plainHandler(w http.ResponseWriter, r *http.Request) {
+	sid, sd, ok := s.sessions.Get(r)
+	if !ok {
+		sid, sd = s.sessions.Create()
+	}
+	if s.loginEnabled() && !sd.Authenticated && s.isAuthorized(r, sd) {
+		sd.Authenticated = true
+	}
+
+	if s.loginEnabled() && r.Method == http.MethodPost {
+		if !authorize(s.opts.AuthToken, r.PostFormValue("token"), false) {
+			s.sessions.Save(w, r, sid, sd)
+			serveLoginForm(w, "plain", http.StatusUnauthorized, "Invalid token.")
+			return
+		}
+		sd.Authenticated = true
+		s.sessions.Save(w, r, sid, sd)
+		w.Header().Set("Location", "plain")
+		w.WriteHeader(http.StatusSeeOther)
+		return
+	}
+	s.sessions.Save(w, r, sid, sd)
+
+	if s.loginEnabled() && !sd.Authenticated {
+		serveLoginForm(w, "plain", http.StatusOK, "")
+		return
+	}
+	if !s.isAuthorized(r, sd) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if s.screen.Sealed() {
+		http.Error(w, "this session is end-to-end encrypted: open it in the web viewer with its key",
+			http.StatusForbidden)
+		return
+	}
+
+	refresh := plainRefresh
+	if v, err := strconv.Atoi(r.URL.Query().Get("refresh")); err == nil {
+		refresh = min(max(v, 0), plainMaxRefresh)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(htmlPage(s.screen.Snapshot(), s.theme(), refresh))
+}
+
+// htmlPage returns a standalone page showing snap, reloading every refresh
+// seconds unless it is 0.
+func htmlPage(snap *mterm.Snapshot, theme *render.Theme, refresh int) []byte {
+	page := []byte("<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\">\n")
+	if refresh > 0 {
+		page = fmt.Appendf(page, "<meta http-equiv=\"refresh\" content=\"%d\">\n", refresh)
+	}
+	bg := theme.Background
+	page = fmt.Appendf(page, "<meta name=\"viewport\" content=\"width=device-width\">\n"+
+		"<title>compterm</title></head>\n"+
+		"<body style=\"margin:0;background-color:#%02x%02x%02x\">\n", bg.R, bg.G, bg.B)
+	page = render.HTML(page, snap, theme)
+	return append(page, "\n</body></html>\n"...)
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crgimenes/compterm/e2e"
+)
+
+func TestPlain(t *testing.T) {
+	s := New(Options{Rows: 3, Columns: 20, AuthToken: "s3cr3t"})
+	defer s.Close()
+	h := s.Handler()
+
+	get := func(target string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// without a session: a login form that posts back to the page
+	rec := get("/plain")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `action="plain"`) {
+		t.Fatalf("GET /plain = %d, want the login page posting to plain:\n%s", rec.Code, rec.Body)
+	}
+	login := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/plain", strings.NewReader(url.Values{"token": {"s3cr3t"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	h.ServeHTTP(login, req)
+	if login.Code != http.StatusSeeOther || login.Header().Get("Location") != "plain" {
+		t.Fatalf("POST /plain = %d to %q, want 303 to plain", login.Code, login.Header().Get("Location"))
+	}
+
+	_, _ = s.Write([]byte("\033[32mhi <there>"))
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(string(s.Screen().GetScreenAsANSI()), "there") && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	rec = get("/plain", login.Result().Cookies()...)
+	body := rec.Body.String()
+	for _, want := range []string{
+		`<meta http-equiv="refresh" content="2">`,
+		`<span style="color:#00c200">hi &lt;there&gt;</span>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("GET /plain lacks %q:\n%s", want, body)
+		}
+	}
+
+	if body := get("/plain?token=s3cr3t&refresh=0").Body.String(); strings.Contains(body, "refresh") ||
+		!strings.Contains(body, "there") {
+		t.Errorf("GET /plain?refresh=0 = %s, want the screen without a refresh", body)
+	}
+
+	key, err := e2e.ParseKey(e2e.NewKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := New(Options{Key: key})
+	defer sealed.Close()
+	rec = httptest.NewRecorder()
+	sealed.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/plain", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("GET /plain of an encrypted session = %d, want 403", rec.Code)
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"image/png"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/crgimenes/compterm/assets"
+	"github.com/crgimenes/compterm/render"
+)
+
+const (
+	// screenshotSize is the default font size of a screenshot, in pixels,
+	// the web viewer's.
+	screenshotSize    = 20
+	screenshotMinSize = 6
+	screenshotMaxSize = 64
+
+	// screenshotMaxPixels bounds the image, whatever the font size and the
+	// terminal's size: 16MB of RGBA.
+	screenshotMaxPixels = 4 << 20
+)
+
+// ErrSealed is returned for what an end-to-end encrypted session cannot
+// provide without its key.
+var ErrSealed = errors.New("the session is end-to-end encrypted")
+
+var errScreenTooLarge = errors.New("the screen is too large for a screenshot")
+
+var loadFont = sync.OnceValues(func() (*render.Font, error) {
+	data, err := assets.ReadFile(assets.FontFile)
+	if err != nil {
+		return nil, err
+	}
+	return render.ParseFont(data)
+})
+
+// theme returns the palette of the theme file, the one the web viewer gets,
+// or the default.
+func (s *Server) theme() *render.Theme {
+	if s.opts.ThemeFile != "" {
+		data, err := os.ReadFile(filepath.Clean(s.opts.ThemeFile)) // #nosec G304 -- operator-controlled config dir
+		if err == nil {
+			if t, err := render.ParseTheme(data); err == nil {
+				return t
+			}
+		}
+	}
+	return &render.DefaultTheme
+}
+
+// Screenshot writes the current screen to w as a PNG, drawn with the viewer's
+// font at size pixels and in its theme. A size that would make the image
+// larger than screenshotMaxPixels is scaled down.
+func (s *Server) Screenshot(w io.Writer, size float64) error {
+	if s.screen.Sealed() {
+		return ErrSealed
+	}
+	f, err := loadFont()
+	if err != nil {
+		return err
+	}
+	snap := s.screen.Snapshot()
+	size, err = fitSize(f, size, snap.Rows, snap.Cols)
+	if err != nil {
+		return err
+	}
+	img, err := render.Image(snap, s.theme(), f, size)
+	if err != nil {
+		return err
+	}
+	return png.Encode(w, img)
+}
+
+// fitSize returns size, or the largest size below it at which rows by cols
+// cells drawn with f take at most screenshotMaxPixels.
+func fitSize(f *render.Font, size float64, rows, cols int) (float64, error) {
+	for {
+		cw, ch, err := f.CellSize(size)
+		if err != nil {
+			return 0, err
+		}
+		pixels := float64(cols*cw) * float64(rows*ch)
+		switch {
+		case pixels <= screenshotMaxPixels:
+			return size, nil
+		case size <= 1:
+			return 0, errScreenTooLarge
+		}
+		// cells grow with the size squared; the rounding up of their sides
+		// may take another step
+		size = max(math.Floor(size*math.Sqrt(screenshotMaxPixels/pixels)), 1)
+	}
+}
+
+// screenshotHandler serves /api/screenshot.png, the current screen as an
+// image; ?size= sets the font size in pixels. It takes the same credentials as
+// the exports.
+func (s *Server) screenshotHandler(w http.ResponseWriter, r *http.Request) {
+	_, sd, _ := s.sessions.Get(r)
+	if !s.isAuthorized(r, sd) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if s.screen.Sealed() {
+		http.Error(w, "this session is end-to-end encrypted", http.StatusForbidden)
+		return
+	}
+
+	size := screenshotSize
+	if v, err := strconv.Atoi(r.URL.Query().Get("size")); err == nil {
+		size = min(max(v, screenshotMinSize), screenshotMaxSize)
+	}
+
+	var buf bytes.Buffer
+	if err := s.Screenshot(&buf, float64(size)); err != nil {
+		log.Println(err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(buf.Bytes())
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScreenshot(t *testing.T) {
+	theme := filepath.Join(t.TempDir(), "theme.json")
+	if err := os.WriteFile(theme, []byte(`{"red": "#f00", "background": "#101010"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s := New(Options{Rows: 2, Columns: 4, AuthToken: "s3cr3t", ThemeFile: theme})
+	defer s.Close()
+
+	_, _ = s.Write([]byte("\033[41m  \033[0m\033[1;32mok"))
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(string(s.Screen().GetScreenAsANSI()), "ok") && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/screenshot.png", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("without a token: status %d, want 401", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/screenshot.png?token=s3cr3t&size=10", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("GET /api/screenshot.png = %d %q: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+	}
+	img, err := png.Decode(rec.Body)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	b := img.Bounds()
+	if b.Dx()%4 != 0 || b.Dy()%2 != 0 || b.Dx() == 0 {
+		t.Fatalf("image is %v, want 4x2 cells", b)
+	}
+	cw, ch := b.Dx()/4, b.Dy()/2
+	rgba := func(x, y int) color.RGBA {
+		r, g, bl, a := img.At(x, y).RGBA()
+		return color.RGBA{byte(r >> 8), byte(g >> 8), byte(bl >> 8), byte(a >> 8)}
+	}
+	// the theme's red behind the first cells, its background on the second row
+	if got := rgba(cw/2, 1); got != (color.RGBA{0xff, 0, 0, 0xff}) {
+		t.Errorf("red cell = %v", got)
+	}
+	if got := rgba(b.Dx()-1, ch+ch/2); got != (color.RGBA{0x10, 0x10, 0x10, 0xff}) {
+		t.Errorf("blank cell = %v", got)
+	}
+	// something was drawn in the text cells
+	var green bool
+	for x := 2 * cw; x < 4*cw && !green; x++ {
+		for y := range ch {
+			if c := rgba(x, y); c.G > 0x80 && c.R < 0x80 {
+				green = true
+				break
+			}
+		}
+	}
+	if !green {
+		t.Error("no green glyph pixels in the text cells")
+	}
+}
+
+func TestScreenshotMaxPixels(t *testing.T) {
+	s := New(Options{Rows: 60, Columns: 200})
+	defer s.Close()
+
+	var buf bytes.Buffer
+	if err := s.Screenshot(&buf, screenshotMaxSize); err != nil {
+		t.Fatalf("Screenshot: %v", err)
+	}
+	cfg, err := png.DecodeConfig(&buf)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if pixels := cfg.Width * cfg.Height; pixels > screenshotMaxPixels || pixels < screenshotMaxPixels/2 {
+		t.Errorf("image is %dx%d, want it scaled down to about %d pixels", cfg.Width, cfg.Height, screenshotMaxPixels)
+	}
+}
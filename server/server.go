@@ -0,0 +1,330 @@
+// Package server shares a terminal over HTTP. A Server owns the broadcast
+// screen, the viewer sessions, and the web viewer; the caller feeds it the
+// terminal's output and mounts its Handler wherever it likes.
+//
+//	srv := server.New(server.Options{AuthToken: "s3cr3t"})
+//	defer srv.Close()
+//	go func() { _ = srv.Attach(ptmx) }()
+//	log.Fatal(http.ListenAndServe(":2200", srv.Handler()))
+package server
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/coder/websocket"
+
+	"github.com/crgimenes/compterm/assets"
+	"github.com/crgimenes/compterm/e2e"
+	"github.com/crgimenes/compterm/screen"
+	"github.com/crgimenes/compterm/session"
+)
+
+const (
+	defaultCookieName = "compterm"
+	defaultRows       = 24
+	defaultColumns    = 80
+	// sessionSweep is how often idle viewer sessions are expired.
+	sessionSweep = 10 * time.Minute
+)
+
+// Options configures a Server. The zero value serves an open session (no
+// authentication) on a 24x80 screen.
+type Options struct {
+	// Rows and Columns are the initial screen size.
+	Rows    int
+	Columns int
+
+	// AuthToken is the viewer access token; empty disables authentication.
+	AuthToken string
+	// EmbedToken is a read-only token accepted only by /embed and its
+	// websocket. It never logs a session in.
+	EmbedToken string
+	// AllowedOrigins are the host patterns (e.g. "*.example.com") allowed to
+	// open the websocket from another site and to frame /embed.
+	AllowedOrigins []string
+
+	// Key, when set, encrypts the session end to end (see package e2e): only
+	// viewers holding the key can read it, whoever relays it.
+	Key *e2e.Key
+
+	// ThemeFile is an optional xterm.js theme served as /theme.json.
+	ThemeFile string
+	// CookieName names the session cookie; defaults to "compterm".
+	CookieName string
+
+	// Authorize, when set, replaces the token check: it alone decides whether
+	// a request may watch the session, and the login page is disabled.
+	Authorize func(r *http.Request) bool
+	// OnViewerJoin and OnViewerLeave are called as websocket viewers attach
+	// and detach. They run on the connection's goroutine and must not block.
+	OnViewerJoin  func(Viewer)
+	OnViewerLeave func(Viewer)
+}
+
+// Viewer describes a connected viewer for the join and leave hooks.
+type Viewer struct {
+	SessionID  string
+	RemoteAddr string
+}
+
+// Server is a shared terminal: write the terminal output to it, serve its
+// Handler, and every viewer sees the same screen.
+type Server struct {
+	opts     Options
+	screen   *screen.Screen
+	sessions *session.Control
+	mux      *http.ServeMux
+	done     chan struct{}
+}
+
+// New returns a Server configured by opts.
+func New(opts Options) *Server {
+	if opts.Rows <= 0 || opts.Columns <= 0 {
+		opts.Rows, opts.Columns = defaultRows, defaultColumns
+	}
+	if opts.CookieName == "" {
+		opts.CookieName = defaultCookieName
+	}
+
+	s := &Server{
+		opts:     opts,
+		screen:   screen.New(opts.Rows, opts.Columns),
+		sessions: session.New(opts.CookieName),
+		done:     make(chan struct{}),
+	}
+	if opts.Key != nil {
+		s.screen.Encrypt(opts.Key)
+	}
+
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("/ws", s.wsHandler)
+	s.mux.HandleFunc("/events", s.eventsHandler)
+	s.mux.HandleFunc("/plain", s.plainHandler)
+	s.mux.HandleFunc("/api/screen.txt", s.exportHandler)
+	s.mux.HandleFunc("/api/screen.html", s.exportHandler)
+	s.mux.HandleFunc("/api/screen.json", s.exportHandler)
+	s.mux.HandleFunc("/api/screenshot.png", s.screenshotHandler)
+	s.mux.HandleFunc("/login", s.loginHandler)
+	s.mux.HandleFunc("/embed", s.embedHandler)
+	s.mux.HandleFunc("/theme.json", s.themeHandler)
+	s.mux.HandleFunc("/", s.mainHandler)
+
+	go s.expireSessions()
+
+	return s
+}
+
+// Handler returns the HTTP handler serving the viewer, login page, and
+// websocket. It can be mounted under a subpath with http.StripPrefix.
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// Screen returns the broadcast screen.
+func (s *Server) Screen() *screen.Screen {
+	return s.screen
+}
+
+// Write implements io.Writer, feeding terminal output to every viewer.
+func (s *Server) Write(p []byte) (int, error) {
+	return s.screen.Write(p)
+}
+
+// Attach copies src (a pty, or any io.Reader producing terminal output) to the
+// viewers until it returns EOF or fails. A pty read error after the child
+// exits is reported as a clean end.
+func (s *Server) Attach(src io.Reader) error {
+	_, err := io.Copy(s.screen, src)
+	if errors.Is(err, os.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// Forward relays a sealed payload from an encrypted upstream to the viewers
+// without opening it. A server that forwards serves only what it forwards.
+func (s *Server) Forward(sealed []byte) {
+	s.screen.Forward(sealed)
+}
+
+// Resize changes the shared screen size and notifies the viewers.
+func (s *Server) Resize(rows, columns int) {
+	s.screen.Resize(rows, columns)
+}
+
+// Close stops the server's background work. It does not close the viewers'
+// connections; shut down the http.Server serving Handler for that.
+func (s *Server) Close() {
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+}
+
+// expireSessions removes idle viewer sessions periodically until Close.
+func (s *Server) expireSessions() {
+	ticker := time.NewTicker(sessionSweep)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.sessions.RemoveExpired()
+		}
+	}
+}
+
+func (s *Server) loginHandler(w http.ResponseWriter, r *http.Request) {
+	// nothing to log into when authentication is disabled
+	if !s.loginEnabled() {
+		redirectToBase(w)
+		return
+	}
+
+	sid, sd, ok := s.sessions.Get(r)
+	if !ok {
+		sid, sd = s.sessions.Create()
+	}
+
+	if r.Method != http.MethodPost {
+		s.sessions.Save(w, r, sid, sd)
+		serveLogin(w, http.StatusOK, "")
+		return
+	}
+
+	if authorize(s.opts.AuthToken, r.PostFormValue("token"), false) {
+		sd.Authenticated = true
+		s.sessions.Save(w, r, sid, sd)
+		redirectToBase(w)
+		return
+	}
+
+	s.sessions.Save(w, r, sid, sd)
+	serveLogin(w, http.StatusUnauthorized, "Invalid token.")
+}
+
+func (s *Server) mainHandler(w http.ResponseWriter, r *http.Request) {
+	sid, sd, ok := s.sessions.Get(r)
+	if !ok {
+		sid, sd = s.sessions.Create()
+	}
+
+	// a valid token in the URL (a shared link) authenticates the session
+	if s.loginEnabled() && !sd.Authenticated && s.isAuthorized(r, sd) {
+		sd.Authenticated = true
+	}
+
+	s.sessions.Save(w, r, sid, sd)
+
+	if isPage(r.URL.Path) {
+		if s.loginEnabled() && !sd.Authenticated {
+			serveLogin(w, http.StatusOK, "")
+			return
+		}
+		if !s.isAuthorized(r, sd) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	// Assets are embedded and have no cache validators, so tell the browser to
+	// revalidate — otherwise an old term.min.js lingers after an upgrade.
+	w.Header().Set("Cache-Control", "no-cache")
+	http.FileServer(assets.FS).ServeHTTP(w, r)
+}
+
+func (s *Server) wsHandler(w http.ResponseWriter, r *http.Request) {
+	sid, sd, ok := s.sessions.Get(r)
+	if !ok {
+		sid, sd = s.sessions.Create()
+	}
+
+	s.sessions.Save(w, r, sid, sd)
+
+	if !s.isViewAuthorized(r, sd) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	c, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+		OriginPatterns: s.opts.AllowedOrigins,
+	})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	s.attachViewer(screen.NewClient(c), r, sid)
+}
+
+// attachViewer attaches a viewer's client, whatever its transport, and runs
+// the join and leave hooks.
+func (s *Server) attachViewer(client *screen.Client, r *http.Request, sid string) {
+	client.SessionID = sid
+	// ?mode=diff asks for the changed cells instead of the raw output
+	client.Diff = r.URL.Query().Get("mode") == "diff"
+	// a reconnecting viewer passes the last SEQ it saw to get only the frames
+	// it missed instead of a full redraw
+	s.screen.ResumeClient(client, r.URL.Query().Get("since"))
+
+	v := Viewer{SessionID: sid, RemoteAddr: r.RemoteAddr}
+	if s.opts.OnViewerJoin != nil {
+		s.opts.OnViewerJoin(v)
+	}
+	if s.opts.OnViewerLeave != nil {
+		go func() {
+			<-client.Done()
+			s.opts.OnViewerLeave(v)
+		}()
+	}
+}
+
+// embedHandler serves the chrome-less viewer used by iframes and the embed.js
+// widget; font size and theme come from its query string. It never shows the
+// login page: without a session, access token, or embed token it answers 401.
+func (s *Server) embedHandler(w http.ResponseWriter, r *http.Request) {
+	_, sd, _ := s.sessions.Get(r)
+	if !s.isViewAuthorized(r, sd) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// restrict which sites may frame the viewer to the same allow-list the
+	// websocket uses for its Origin check
+	if len(s.opts.AllowedOrigins) > 0 {
+		w.Header().Set("Content-Security-Policy",
+			"frame-ancestors 'self' "+strings.Join(s.opts.AllowedOrigins, " "))
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+
+	r2 := r.Clone(r.Context())
+	r2.URL.Path = "/embed.html"
+	http.FileServer(assets.FS).ServeHTTP(w, r2)
+}
+
+// themeHandler serves the optional xterm.js theme file so the viewer's palette
+// can match the operator's terminal. Absent file -> defaults.
+func (s *Server) themeHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if s.opts.ThemeFile == "" {
+		_, _ = w.Write([]byte("{}"))
+		return
+	}
+	data, err := os.ReadFile(filepath.Clean(s.opts.ThemeFile)) // #nosec G304 -- operator-controlled config dir
+	if err != nil {
+		_, _ = w.Write([]byte("{}"))
+		return
+	}
+	_, _ = w.Write(data)
+}
@@ -0,0 +1,477 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+
+	"github.com/crgimenes/compterm/client"
+	"github.com/crgimenes/compterm/e2e"
+	"github.com/crgimenes/compterm/mterm"
+)
+
+func TestAuthorize(t *testing.T) {
+	const token = "s3cr3t"
+
+	tests := []struct {
+		name          string
+		required      string
+		provided      string
+		sessionAuthed bool
+		want          bool
+	}{
+		{name: "disabled allows everyone", required: "", provided: "", want: true},
+		{name: "disabled ignores wrong token", required: "", provided: "nope", want: true},
+		{name: "authenticated session passes", required: token, sessionAuthed: true, want: true},
+		{name: "correct token passes", required: token, provided: token, want: true},
+		{name: "wrong token fails", required: token, provided: "wrong", want: false},
+		{name: "empty token fails when required", required: token, provided: "", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := authorize(tt.required, tt.provided, tt.sessionAuthed)
+			if got != tt.want {
+				t.Errorf("authorize(%q, %q, %v) = %v, want %v",
+					tt.required, tt.provided, tt.sessionAuthed, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoginGateAndFlow(t *testing.T) {
+	s := New(Options{AuthToken: "s3cr3t"})
+	defer s.Close()
+	h := s.Handler()
+
+	// unauthenticated GET / shows the login page
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET / status = %d, want 200", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "Access token") {
+		t.Fatalf("GET / did not return the login page")
+	}
+
+	// unauthenticated /ws is rejected
+	wsRec := httptest.NewRecorder()
+	h.ServeHTTP(wsRec, httptest.NewRequest(http.MethodGet, "/ws", nil))
+	if wsRec.Code != http.StatusUnauthorized {
+		t.Fatalf("unauthenticated GET /ws status = %d, want 401", wsRec.Code)
+	}
+
+	// POST /login with the correct token authenticates and redirects
+	form := url.Values{"token": {"s3cr3t"}}
+	loginRec := httptest.NewRecorder()
+	loginReq := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	loginReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	h.ServeHTTP(loginRec, loginReq)
+	if loginRec.Code != http.StatusSeeOther {
+		t.Fatalf("POST /login status = %d, want 303", loginRec.Code)
+	}
+	cookies := loginRec.Result().Cookies()
+	if len(cookies) == 0 {
+		t.Fatal("POST /login set no session cookie")
+	}
+
+	// the session cookie now gets past the /ws auth gate (the upgrade itself
+	// fails under httptest, but a 401 would mean auth rejected it)
+	wsRec2 := httptest.NewRecorder()
+	wsReq2 := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	for _, c := range cookies {
+		wsReq2.AddCookie(c)
+	}
+	h.ServeHTTP(wsRec2, wsReq2)
+	if wsRec2.Code == http.StatusUnauthorized {
+		t.Fatalf("authenticated GET /ws was rejected (401)")
+	}
+
+	// a wrong token is rejected
+	badRec := httptest.NewRecorder()
+	badReq := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(url.Values{"token": {"nope"}}.Encode()))
+	badReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	h.ServeHTTP(badRec, badReq)
+	if badRec.Code != http.StatusUnauthorized {
+		t.Fatalf("POST /login wrong token status = %d, want 401", badRec.Code)
+	}
+}
+
+// TestAssetsServed verifies the embedded assets are served and that the
+// terminal CSS is vendored locally instead of pulled from a CDN.
+func TestAssetsServed(t *testing.T) {
+	s := New(Options{})
+	defer s.Close()
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	for _, path := range []string{"/", "/term.css", "/xterm.css"} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s status = %d, want 200", path, resp.StatusCode)
+		}
+
+		if path != "/term.css" {
+			continue
+		}
+		if strings.Contains(string(body), "unpkg") {
+			t.Errorf("term.css still references the unpkg CDN")
+		}
+		if !strings.Contains(string(body), `@import "xterm.css"`) {
+			t.Errorf("term.css does not import the vendored xterm.css")
+		}
+	}
+}
+
+// TestEmbedAuth verifies the embed token is scoped: it opens /embed and gets
+// past the /ws gate, but never logs in a session for the full viewer.
+func TestEmbedAuth(t *testing.T) {
+	s := New(Options{
+		AuthToken:      "s3cr3t",
+		EmbedToken:     "v1ew",
+		AllowedOrigins: []string{"*.school.edu"},
+	})
+	defer s.Close()
+	h := s.Handler()
+
+	tests := []struct {
+		name   string
+		target string
+		want   int
+	}{
+		{"embed without token", "/embed", http.StatusUnauthorized},
+		{"embed with wrong token", "/embed?token=nope", http.StatusUnauthorized},
+		{"embed with embed token", "/embed?token=v1ew&fontSize=14", http.StatusOK},
+		{"embed with access token", "/embed?token=s3cr3t", http.StatusOK},
+		{"ws without token", "/ws", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if rec.Code != tt.want {
+				t.Fatalf("GET %s status = %d, want %d", tt.target, rec.Code, tt.want)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			csp := rec.Header().Get("Content-Security-Policy")
+			if csp != "frame-ancestors 'self' *.school.edu" {
+				t.Errorf("Content-Security-Policy = %q", csp)
+			}
+		})
+	}
+
+	// the embed token gets past the /ws gate (the upgrade itself fails under
+	// httptest, but a 401 would mean auth rejected it)
+	wsRec := httptest.NewRecorder()
+	h.ServeHTTP(wsRec, httptest.NewRequest(http.MethodGet, "/ws?token=v1ew", nil))
+	if wsRec.Code == http.StatusUnauthorized {
+		t.Fatalf("GET /ws with the embed token was rejected (401)")
+	}
+
+	// but it does not log in the full viewer
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?token=v1ew", nil))
+	if !strings.Contains(rec.Body.String(), "Access token") {
+		t.Fatalf("GET / with the embed token did not return the login page")
+	}
+
+	// static assets stay reachable for an embedded viewer without a session
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/embed.js", nil))
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "Access token") {
+		t.Fatalf("GET /embed.js status = %d, want the script", rec.Code)
+	}
+}
+
+// TestAuthorizeHook verifies a custom Authorize replaces the token check and
+// the login page.
+func TestAuthorizeHook(t *testing.T) {
+	s := New(Options{
+		AuthToken: "ignored",
+		Authorize: func(r *http.Request) bool {
+			return r.Header.Get("X-User") == "alice"
+		},
+	})
+	defer s.Close()
+	h := s.Handler()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?token=ignored", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("GET / without the hook's approval status = %d, want 401", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-User", "alice")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "Access token") {
+		t.Fatalf("GET / approved by the hook status = %d, want the viewer", rec.Code)
+	}
+}
+
+// TestAttachAndViewerHooks feeds a source through Attach and checks a
+// websocket viewer receives it, firing the join and leave hooks.
+func TestAttachAndViewerHooks(t *testing.T) {
+	joined := make(chan Viewer, 1)
+	left := make(chan Viewer, 1)
+	s := New(Options{
+		OnViewerJoin:  func(v Viewer) { joined <- v },
+		OnViewerLeave: func(v Viewer) { left <- v },
+	})
+	defer s.Close()
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+
+	select {
+	case <-joined:
+	case <-ctx.Done():
+		t.Fatal("OnViewerJoin was not called")
+	}
+
+	if err := s.Attach(strings.NewReader("hello from the pty")); err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+
+	var got strings.Builder
+	for !strings.Contains(got.String(), "hello from the pty") {
+		_, data, err := c.Read(ctx)
+		if err != nil {
+			t.Fatalf("read: %v (got %q)", err, got.String())
+		}
+		got.Write(data)
+	}
+
+	_ = c.Close(websocket.StatusNormalClosure, "")
+	select {
+	case <-left:
+	case <-ctx.Done():
+		t.Fatal("OnViewerLeave was not called")
+	}
+}
+
+// TestRewindAndLive rewinds a viewer over the websocket and brings it back to
+// live, while another viewer stays live throughout.
+func TestRewindAndLive(t *testing.T) {
+	s := New(Options{Rows: 5, Columns: 40})
+	defer s.Close()
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, _ = s.Write([]byte("earlier"))
+	time.Sleep(1200 * time.Millisecond) // the recording must reach back a second
+
+	viewer, err := client.Dial(ctx, wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer viewer.Close()
+	other, err := client.Dial(ctx, wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer other.Close()
+
+	// until returns the output after the next Shift event to offset (with a
+	// negative offset, right away), up to the first message containing want
+	until := func(c *client.Conn, offset time.Duration, want string) string {
+		t.Helper()
+		shifted := offset < 0
+		var out strings.Builder
+		for {
+			select {
+			case <-ctx.Done():
+				t.Fatalf("timed out waiting for %q (shifted=%v, output %q)", want, shifted, out.String())
+			case ev := <-c.Events():
+				switch {
+				case ev.Type == client.Shift && ev.Offset == offset:
+					shifted = true
+				case ev.Type == client.Message && shifted:
+					out.Write(ev.Data)
+					if strings.Contains(string(ev.Data), want) {
+						return out.String()
+					}
+				}
+			}
+		}
+	}
+
+	if err := viewer.Rewind(time.Second); err != nil {
+		t.Fatalf("Rewind: %v", err)
+	}
+	until(viewer, time.Second, "earlier")
+	if viewer.Offset() != time.Second {
+		t.Fatalf("Offset = %v, want 1s", viewer.Offset())
+	}
+
+	_, _ = s.Write([]byte("meanwhile"))
+	until(other, -1, "meanwhile")
+
+	if err := viewer.Live(); err != nil {
+		t.Fatalf("Live: %v", err)
+	}
+	until(viewer, 0, "meanwhile")
+	if viewer.Offset() != 0 {
+		t.Fatalf("Offset = %v, want live", viewer.Offset())
+	}
+}
+
+func TestDiffMode(t *testing.T) {
+	key, err := e2e.ParseKey(e2e.NewKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(Options{Rows: 5, Columns: 40, Key: key})
+	defer s.Close()
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, _ = s.Write([]byte("before"))
+	viewer, err := client.Dial(ctx, wsURL, &client.Options{Key: key, Diff: true, Mirror: true})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer viewer.Close()
+
+	// the mirror, fed only by sealed diffs, converges on the screen
+	for _, tt := range []struct{ out, want string }{
+		{"", "before"},
+		{"\033[3;5H\033[1mafter", "after"},
+	} {
+		_, _ = s.Write([]byte(tt.out))
+		for {
+			var got []byte
+			if term := viewer.Terminal(); term != nil { // created by the first RESIZE
+				got = term.GetScreenAsAnsi()
+			}
+			want := s.Screen().GetScreenAsANSI()
+			if strings.Contains(string(got), tt.want) && string(got) == string(want) {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				t.Fatalf("mirror = %q, want %q", got, want)
+			case <-viewer.Events():
+			}
+		}
+	}
+}
+
+// TestLargeSnapshot joins a viewer to a screen whose snapshot and output run
+// to several messages, so that frames span them, and checks its mirror.
+func TestLargeSnapshot(t *testing.T) {
+	s := New(Options{Rows: 50, Columns: 200})
+	defer s.Close()
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	colored := func(from, to int) []byte {
+		var out bytes.Buffer
+		for i := from; i < to; i++ {
+			for j := range 20 {
+				fmt.Fprintf(&out, "\033[38;2;%d;%d;%dm%d", i%256, j*10, 255-i%256, j)
+			}
+			fmt.Fprintf(&out, "\033[0m line %d\r\n", i)
+		}
+		return out.Bytes()
+	}
+	_, _ = s.Write(colored(0, 1100))
+
+	viewer, err := client.Dial(ctx, wsURL, &client.Options{Mirror: true})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer viewer.Close()
+
+	// match reports whether the mirror shows the screen and the end of its
+	// scrollback
+	match := func() bool {
+		term := viewer.Terminal()
+		if term == nil { // created by the first RESIZE
+			return false
+		}
+		got, gotBack := term.SnapshotWithScrollback()
+		want, wantBack := s.Screen().SnapshotWithScrollback()
+		// each trims its scrollback in its own time
+		n := min(len(gotBack), len(wantBack))
+		return n > 0 && cellsEqual(got.Cells, want.Cells) && got.Cursor == want.Cursor &&
+			cellsEqual(gotBack[len(gotBack)-n:], wantBack[len(wantBack)-n:])
+	}
+	wait := func(what string) {
+		t.Helper()
+		for !match() {
+			select {
+			case <-ctx.Done():
+				t.Fatalf("the mirror does not match the screen %s", what)
+			case ev := <-viewer.Events():
+				if ev.Type == client.Disconnected {
+					t.Fatalf("disconnected %s: %v", what, ev.Err)
+				}
+			}
+		}
+	}
+
+	wait("after the snapshot")
+	_, _ = s.Write(colored(1100, 2200))
+	_, _ = s.Write([]byte("done"))
+	wait("after the output")
+}
+
+// cellsEqual compares cells as they look: a snapshot redraws blank cells as
+// spaces.
+func cellsEqual(a, b []mterm.Cell) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		x, y := a[i], b[i]
+		if x.Char == 0 {
+			x.Char = ' '
+		}
+		if y.Char == 0 {
+			y.Char = ' '
+		}
+		if x != y {
+			return false
+		}
+	}
+	return true
+}
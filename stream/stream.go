@@ -51,6 +51,13 @@ func (s *Stream) Read(p []byte) (n int, err error) {
 	return s.buffer.Read(p)
 }
 
+// Len returns the number of bytes waiting to be read.
+func (s *Stream) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buffer.Len()
+}
+
 // Close marck the stream as closed.
 func (s *Stream) Close() error {
 	s.mu.Lock()